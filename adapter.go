@@ -15,6 +15,7 @@
 package osio
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -24,11 +25,14 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 	"unicode"
 
 	lru "github.com/hashicorp/golang-lru"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // KeyStreamerAt is the second interface a handler can implement.
@@ -58,6 +62,36 @@ type KeyStreamerAt interface {
 	StreamAt(key string, off int64, n int64) (io.ReadCloser, int64, error)
 }
 
+// KeyStreamerAtContext is an optional interface that can be implemented by a KeyStreamerAt in
+// order to receive the context of the Adapter call that triggered the request.
+//
+// The Adapter will call StreamAtContext instead of StreamAt whenever it is available.
+type KeyStreamerAtContext interface {
+	KeyStreamerAt
+	// StreamAtContext behaves like StreamAt, except that the request and the returned stream
+	// must be aborted once ctx is done.
+	StreamAtContext(ctx context.Context, key string, off int64, n int64) (io.ReadCloser, int64, error)
+}
+
+// ErrObjectChanged is returned when an object was modified after the Adapter first accessed it.
+// The cached blocks and size of the object are discarded when it is returned.
+var ErrObjectChanged = errors.New("object changed")
+
+// KeyVersionStreamerAt is an optional interface that can be implemented by a KeyStreamerAt that
+// is able to identify the version of the objects it serves (e.g. a GCS generation or an ETag).
+//
+// The Adapter records the version of an object alongside its size, and passes it to all the
+// subsequent requests on that object so that data from different versions is never mixed.
+type KeyVersionStreamerAt interface {
+	KeyStreamerAtContext
+	// StreamAtVersion behaves like StreamAtContext, and additionally returns the version of the
+	// streamed object (or an empty string if it is not known).
+	//
+	// If version is not empty, the request must fail with ErrObjectChanged (or a wrapped error
+	// of ErrObjectChanged) if the current version of the object is not version.
+	StreamAtVersion(ctx context.Context, key string, off int64, n int64, version string) (io.ReadCloser, int64, string, error)
+}
+
 // BlockCacher is the interface that wraps block caching functionality
 //
 // Add inserts data to the cache for the given key and blockID.
@@ -75,6 +109,9 @@ type NamedOnceMutex interface {
 	//Lock aquires a lock to the resource and returns true. If the keyed resource is already locked,
 	//Lock waits until the resource has been unlocked and returns false
 	Lock(key interface{}) bool
+	//LockContext behaves like Lock, except that it stops waiting and returns ctx.Err() once
+	//ctx is done. Giving up does not release the lock held by the current owner of the resource
+	LockContext(ctx context.Context, key interface{}) (bool, error)
 	//TryLock tries to acquire a lock on a keyed resource. If the keyed resource is not already locked,
 	//TryLock aquires a lock to the resource and returns true. If the keyed resource is already locked,
 	//TryLock returns false immediately
@@ -98,69 +135,232 @@ type Adapter struct {
 	blockSize       int64
 	blmu            NamedOnceMutex
 	numCachedBlocks int
+	cacheBytes      int64
 	cache           BlockCacher
 	keyStreamer     KeyStreamerAt
 	splitRanges     bool
 	sizeCache       *lru.Cache
 	retries         int
 	logger          Logger
+	readAhead       int
+	stats           statCounters
+	prefixStats     []*prefixCounters
+	tracer          trace.Tracer
+	retryPolicy     RetryPolicy
+	hedger          *hedger
+	limiter         *fifoSemaphore
+	prefixLimiters  []prefixLimiter
 }
 
-func temporary(err error) bool {
-	type temp interface {
-		Temporary() bool
+// StreamAtContext calls ks.StreamAtContext if ks implements KeyStreamerAtContext, or falls back
+// to ks.StreamAt if it doesn't. It is intended for KeyStreamerAt implementations wrapping
+// another KeyStreamerAt.
+func StreamAtContext(ctx context.Context, ks KeyStreamerAt, key string, off int64, n int64) (io.ReadCloser, int64, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, 0, err
 	}
-	if tt, ok := err.(temp); ok {
-		return tt.Temporary()
+	if ksc, ok := ks.(KeyStreamerAtContext); ok {
+		return ksc.StreamAtContext(ctx, key, off, n)
 	}
-	return false
+	return ks.StreamAt(key, off, n)
 }
 
-func (a *Adapter) srcStreamAt(key string, off int64, n int64) (io.ReadCloser, error) {
+// StreamAtVersion calls ks.StreamAtVersion if ks implements KeyVersionStreamerAt, or falls back
+// to an unversioned StreamAtContext if it doesn't.
+func StreamAtVersion(ctx context.Context, ks KeyStreamerAt, key string, off int64, n int64, version string) (io.ReadCloser, int64, string, error) {
+	if ksv, ok := ks.(KeyVersionStreamerAt); ok {
+		if err := ctx.Err(); err != nil {
+			return nil, 0, "", err
+		}
+		return ksv.StreamAtVersion(ctx, key, off, n, version)
+	}
+	r, size, err := StreamAtContext(ctx, ks, key, off, n)
+	return r, size, "", err
+}
+
+// objectInfo is the value stored in the size cache
+type objectInfo struct {
+	size    int64
+	version string
+	// modTime is only known for listed objects
+	modTime time.Time
+}
+
+func (a *Adapter) objectInfo(key string) (objectInfo, bool) {
+	oi, ok := a.sizeCache.Get(key)
+	if !ok {
+		return objectInfo{}, false
+	}
+	return oi.(objectInfo), true
+}
+
+// addObjectInfo records the size and version of key, keeping its modification time if the
+// object did not change
+func (a *Adapter) addObjectInfo(key string, oi objectInfo) {
+	if prev, ok := a.objectInfo(key); ok && prev.size == oi.size && prev.version == oi.version {
+		oi.modTime = prev.modTime
+	}
+	a.sizeCache.Add(key, oi)
+}
+
+type versionCtxKey struct{}
+
+// withVersion makes the reads done with ctx fail with ErrObjectChanged if the object they
+// target is not at the given version
+func withVersion(ctx context.Context, version string) context.Context {
+	if version == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, versionCtxKey{}, version)
+}
+
+func versionFromContext(ctx context.Context) string {
+	v, _ := ctx.Value(versionCtxKey{}).(string)
+	return v
+}
+
+// pinnedVersion returns the version of key that reads done with ctx must target
+func (a *Adapter) pinnedVersion(ctx context.Context, key string) string {
+	if version := versionFromContext(ctx); version != "" {
+		return version
+	}
+	oi, _ := a.objectInfo(key)
+	return oi.version
+}
+
+// blockCacheKey returns the key under which the blocks of key read with ctx are looked up. The
+// blocks of reads targeting a version are cached along with it, so that the blocks of different
+// versions of an object never get mixed.
+func blockCacheKey(ctx context.Context, key string) string {
+	return versionedKey(key, versionFromContext(ctx))
+}
+
+// cacheBlock adds a block read from the given version of key to the block cache, under ckey, the
+// key it is looked up and locked with, and under the key of its version if the version of the
+// object was not known when it was looked up
+func (a *Adapter) cacheBlock(ckey, key, version string, id int64, data []byte) {
+	a.cache.Add(ckey, uint(id), data)
+	if vkey := versionedKey(key, version); vkey != ckey {
+		a.cache.Add(vkey, uint(id), data)
+	}
+}
+
+func versionedKey(key, version string) string {
+	if version == "" {
+		return key
+	}
+	return key + "\x00" + version
+}
+
+// invalidate discards the cached size of key, and the cached blocks of key at version and
+// at the cached version
+func (a *Adapter) invalidate(key string, version string) {
+	oi, _ := a.objectInfo(key)
+	a.sizeCache.Remove(key)
+	if p, ok := a.cache.(interface{ PurgeKey(string) }); ok {
+		p.PurgeKey(key)
+		if version != "" {
+			p.PurgeKey(versionedKey(key, version))
+		}
+		if oi.version != "" && oi.version != version {
+			p.PurgeKey(versionedKey(key, oi.version))
+		}
+	}
+}
+
+// srcStream requests the range [off,off+n) of key from the source, retrying failed requests
+// while bo allows it, and records the size and version of key in the size cache. It returns
+// the version of the object the stream was read from.
+func (a *Adapter) srcStream(ctx context.Context, key string, off int64, n int64, bo *backoff) (io.ReadCloser, string, error) {
+	version := a.pinnedVersion(ctx, key)
+	r, tot, curVersion, err := a.openStream(ctx, key, off, n, version, bo)
+	if errors.Is(err, ErrObjectChanged) {
+		return r, "", err
+	}
+	if curVersion == "" {
+		curVersion = version
+	}
+	if off == 0 && errors.Is(err, syscall.ENOENT) {
+		a.sizeCache.Add(key, objectInfo{size: -1})
+	}
+	//some handlers only return the size of the object for requests at offset 0
+	if (off == 0 || tot > 0) && (err == nil || errors.Is(err, io.EOF)) {
+		a.addObjectInfo(key, objectInfo{size: tot, version: curVersion})
+	}
+	return r, curVersion, err
+}
+
+// openStream requests the range [off,off+n) of key from the source, retrying failed requests
+// while bo allows it
+func (a *Adapter) openStream(ctx context.Context, key string, off int64, n int64, version string, bo *backoff) (io.ReadCloser, int64, string, error) {
 	if a.logger != nil {
 		a.logger.Log(key, off, n)
 	}
+	ctx, span := a.tracer.Start(ctx, "osio.StreamAt", trace.WithAttributes(
+		AttrKey.String(key), AttrOffset.Int64(off), AttrLength.Int64(n)))
 	try := 1
-	delay := 100 * time.Millisecond
 	var r io.ReadCloser
 	var tot int64
 	var err error
+	var curVersion string
 	for {
-		r, tot, err = a.keyStreamer.StreamAt(key, off, n)
-		if err != nil && try <= a.retries && temporary(err) {
-			try++
-			time.Sleep(delay)
-			delay *= 2
-			continue
+		a.countRequest(key, n)
+		r, tot, curVersion, err = a.streamAt(ctx, key, off, n, version)
+		if err == nil {
+			break
 		}
-		break
-	}
-	if off == 0 {
-		if err != nil {
-			if errors.Is(err, syscall.ENOENT) {
-				a.sizeCache.Add(key, int64(-1))
-			}
-			if errors.Is(err, io.EOF) {
-				a.sizeCache.Add(key, tot)
-			}
-		} else {
-			a.sizeCache.Add(key, tot)
+		delay, retry := bo.next(err)
+		if !retry {
+			break
 		}
+		span.AddEvent("attempt failed", trace.WithAttributes(
+			AttrAttempt.Int(try), attribute.String("error", err.Error())))
+		try++
+		a.count(key, func(c *statCounters) { atomic.AddUint64(&c.retries, 1) })
+		if werr := bo.wait(ctx, delay); werr != nil {
+			span.SetAttributes(AttrAttempt.Int(try-1), AttrOutcome.String(outcome(werr)))
+			endSpan(span, werr)
+			return nil, 0, "", werr
+		}
+	}
+	span.SetAttributes(AttrAttempt.Int(try), AttrOutcome.String(outcome(err)))
+	if r != nil {
+		r = &spanReadCloser{ReadCloser: countingReadCloser{ReadCloser: r, a: a, key: key}, span: span, err: err}
+	} else {
+		endSpan(span, err)
+	}
+	if errors.Is(err, ErrObjectChanged) {
+		a.invalidate(key, version)
 	}
-	return r, err
+	return r, tot, curVersion, err
 }
 
-func (a *Adapter) srcReadAt(key string, p []byte, off int64) (int, error) {
-	r, err := a.srcStreamAt(key, off, int64(len(p)))
+// srcResumableStream behaves like srcStream, and returns a stream that resumes the range
+// where it was interrupted if reading from it fails
+func (a *Adapter) srcResumableStream(ctx context.Context, key string, off int64, n int64) (io.ReadCloser, string, error) {
+	bo := a.newBackoff()
+	r, version, err := a.srcStream(ctx, key, off, n, bo)
 	if err != nil && (r == nil || !errors.Is(err, io.EOF)) {
-		return 0, err
+		return r, version, err
+	}
+	//failed reads are resumed on the same version of the object
+	return &resumingReader{a: a, ctx: withVersion(ctx, version), key: key,
+		off: off, n: n, bo: bo, r: r}, version, err
+}
+
+// srcReadAt reads p at off from the source, and returns the version of the object the data
+// was read from
+func (a *Adapter) srcReadAt(ctx context.Context, key string, p []byte, off int64) (int, string, error) {
+	r, version, err := a.srcResumableStream(ctx, key, off, int64(len(p)))
+	if err != nil && (r == nil || !errors.Is(err, io.EOF)) {
+		return 0, version, err
 	}
 	defer r.Close()
 	n, err := io.ReadFull(r, p)
 	if errors.Is(err, io.ErrUnexpectedEOF) {
 		err = io.EOF
 	}
-	return n, err
+	return n, version, err
 }
 
 type AdapterOption interface {
@@ -211,65 +411,92 @@ func NumCachedBlocks(n int) interface {
 	return ncbao{n}
 }
 
-func (b bsao) adapterOpt(a *Adapter) error {
-	const (
-		BYTE = 1 << (10 * iota)
-		KILOBYTE
-		MEGABYTE
-		//GIGABYTE
-		//TERABYTE
-		//PETABYTE
-		//EXABYTE
-	)
-	s := strings.TrimSpace(b.bs)
+const (
+	kilobyte = 1 << (10 * (iota + 1))
+	megabyte
+	gigabyte
+	terabyte
+)
+
+// parseSize parses a human readable number of bytes, e.g. "1024", "128k", "1.5MB".
+// Units larger than maxUnit are rejected. what names the parsed value in returned errors
+func parseSize(what string, str string, maxUnit int64) (int64, error) {
+	s := strings.TrimSpace(str)
 	if len(s) == 0 {
-		return fmt.Errorf("blocksize is empty")
+		return 0, fmt.Errorf("%s is empty", what)
 	}
 	s = strings.ToUpper(s)
 
 	i := strings.IndexFunc(s, unicode.IsLetter)
 
 	if i == -1 {
-		ii, err := strconv.Atoi(s)
+		ii, err := strconv.ParseInt(s, 10, 64)
 		if err != nil {
-			return fmt.Errorf("failed to parse integer from %s: %w", b.bs, err)
+			return 0, fmt.Errorf("failed to parse integer from %s: %w", str, err)
 		}
 		if ii <= 0 {
-			return fmt.Errorf("blocksize %s must be strictly positive", b.bs)
+			return 0, fmt.Errorf("%s %s must be strictly positive", what, str)
 		}
-		a.blockSize = int64(ii)
-		return nil
+		return ii, nil
 	}
 
 	bytesString, multiple := s[:i], s[i:]
 	bytes, err := strconv.ParseFloat(bytesString, 64)
 	if err != nil {
-		return fmt.Errorf("failed to parse float from %s: %w", b.bs, err)
-	}
-	if bytes < 0 {
-		return fmt.Errorf("blocksize %s must be strictly positive", b.bs)
+		return 0, fmt.Errorf("failed to parse float from %s: %w", str, err)
 	}
 
+	var unit int64
 	switch multiple {
-	/*
-		case "E", "EB", "EIB":
-			return int(bytes * EXABYTE)
-		case "P", "PB", "PIB":
-			return int(bytes * PETABYTE)
-		case "T", "TB", "TIB":
-			return int(bytes * TERABYTE)
-		case "G", "GB", "GIB":
-			return int(bytes * GIGABYTE)
-	*/
+	case "T", "TB", "TIB":
+		unit = terabyte
+	case "G", "GB", "GIB":
+		unit = gigabyte
 	case "M", "MB", "MIB":
-		a.blockSize = int64(bytes * MEGABYTE)
+		unit = megabyte
 	case "K", "KB", "KIB":
-		a.blockSize = int64(bytes * KILOBYTE)
+		unit = kilobyte
 	case "B":
-		a.blockSize = int64(bytes)
-	default:
-		return fmt.Errorf("failed to parse blocksize %s", b.bs)
+		unit = 1
+	}
+	if unit == 0 || unit > maxUnit {
+		return 0, fmt.Errorf("failed to parse %s %s", what, str)
+	}
+	size := int64(bytes * float64(unit))
+	if size <= 0 {
+		return 0, fmt.Errorf("%s %s must be strictly positive", what, str)
+	}
+	return size, nil
+}
+
+type cbao struct {
+	size string
+}
+
+func (b cbao) adapterOpt(a *Adapter) error {
+	size, err := parseSize("cache size", b.size, terabyte)
+	if err != nil {
+		return err
 	}
+	a.cacheBytes = size
+	return nil
+}
+
+// BlockCacheSize is an option to bound the default block cache by the total number of
+// bytes it holds (e.g. "512MB", "2GB") instead of by number of blocks. It may not be
+// used alongside NumCachedBlocks or BlockCache
+func BlockCacheSize(size string) interface {
+	AdapterOption
+} {
+	return cbao{size}
+}
+
+func (b bsao) adapterOpt(a *Adapter) error {
+	bs, err := parseSize("blocksize", b.bs, megabyte)
+	if err != nil {
+		return err
+	}
+	a.blockSize = bs
 	return nil
 }
 
@@ -294,7 +521,8 @@ func (b srao) adapterOpt(a *Adapter) error {
 }
 
 // Retries is an option to set the number of times a ReadAt() will be retried
-// if it returns a temporary/transient error
+// if it returns a temporary/transient error. See WithRetryPolicy to configure which errors
+// are retried, and the delay between retries
 func Retries(retries int) interface {
 	AdapterOption
 } {
@@ -394,6 +622,12 @@ func NewAdapter(keyStreamer KeyStreamerAt, opts ...AdapterOption) (*Adapter, err
 	if bc.cache != nil && bc.numCachedBlocks != DefaultNumCachedBlocks {
 		return nil, fmt.Errorf("invalid options: NumCachedBlocks may not be used alongside BlockCache")
 	}
+	if bc.cacheBytes > 0 && (bc.cache != nil || bc.numCachedBlocks != DefaultNumCachedBlocks) {
+		return nil, fmt.Errorf("invalid options: BlockCacheSize may not be used alongside NumCachedBlocks or BlockCache")
+	}
+	if bc.cacheBytes > 0 {
+		bc.cache, _ = NewByteLRUCache(bc.cacheBytes)
+	}
 	if bc.blmu == nil {
 		bc.blmu = newNamedOnceMutex()
 	}
@@ -403,6 +637,9 @@ func NewAdapter(keyStreamer KeyStreamerAt, opts ...AdapterOption) (*Adapter, err
 	if bc.sizeCache == nil {
 		bc.sizeCache, _ = lru.New(1000)
 	}
+	if bc.tracer == nil {
+		bc.tracer = defaultTracer()
+	}
 	return bc, nil
 }
 
@@ -411,40 +648,55 @@ type blockRange struct {
 	end   int64
 }
 
-func (a *Adapter) getRange(key string, rng blockRange) ([][]byte, error) {
+func (a *Adapter) getRange(ctx context.Context, key string, rng blockRange) ([][]byte, error) {
+	ctx, span := a.tracer.Start(ctx, "osio.getRange", trace.WithAttributes(AttrKey.String(key),
+		AttrOffset.Int64(rng.start*a.blockSize), AttrLength.Int64((rng.end-rng.start+1)*a.blockSize)))
+	blocks, err := a.readRange(ctx, key, rng)
+	endSpan(span, err)
+	return blocks, err
+}
+
+func (a *Adapter) readRange(ctx context.Context, key string, rng blockRange) ([][]byte, error) {
+	ckey := blockCacheKey(ctx, key)
 	blocks := make([][]byte, rng.end-rng.start+1)
 	toFetch := make([]bool, rng.end-rng.start+1)
 	nToFetch := 0
 	for i := rng.start; i <= rng.end; i++ {
-		blockID := a.blockKey(key, i)
+		blockID := a.blockKey(ckey, i)
 		if toFetch[i-rng.start] = a.blmu.TryLock(blockID); toFetch[i-rng.start] {
 			nToFetch++
 		}
 	}
 	if nToFetch == len(blocks) {
-		r, err := a.srcStreamAt(key, rng.start*a.blockSize, (rng.end-rng.start+1)*a.blockSize)
+		r, version, err := a.srcResumableStream(ctx, key, rng.start*a.blockSize, (rng.end-rng.start+1)*a.blockSize)
 		if err != nil && (r == nil || !errors.Is(err, io.EOF)) {
 			for i := rng.start; i <= rng.end; i++ {
-				blockID := a.blockKey(key, i)
+				blockID := a.blockKey(ckey, i)
 				a.blmu.Unlock(blockID)
 			}
 			return nil, err
 		}
 		defer r.Close()
 		for bid := int64(0); bid <= rng.end-rng.start; bid++ {
-			blockID := a.blockKey(key, bid+rng.start)
+			blockID := a.blockKey(ckey, bid+rng.start)
 			buf := make([]byte, a.blockSize)
 			n, err := io.ReadFull(r, buf)
 			if errors.Is(err, io.ErrUnexpectedEOF) {
 				err = io.EOF
 			}
 			if err == nil || errors.Is(err, io.EOF) {
-				blocks[bid] = buf[:n]
-				a.cache.Add(key, uint(rng.start+bid), blocks[bid])
+				if n != int(a.blockSize) {
+					//if smaller than block size, store smaller block to cache
+					smallbuf := make([]byte, n)
+					copy(smallbuf, buf)
+					buf = smallbuf
+				}
+				blocks[bid] = buf
+				a.cacheBlock(ckey, key, version, rng.start+bid, blocks[bid])
 			}
 			if err != nil {
 				for i := rng.start + bid; i <= rng.end; i++ {
-					a.blmu.Unlock(a.blockKey(key, i))
+					a.blmu.Unlock(a.blockKey(ckey, i))
 				}
 				if errors.Is(err, io.EOF) {
 					break
@@ -466,16 +718,17 @@ func (a *Adapter) getRange(key string, rng blockRange) ([][]byte, error) {
 			defer wg.Done()
 			var berr error
 			if !toFetch[id-rng.start] {
-				blocks[id-rng.start], berr = a.getBlock(key, id)
+				blocks[id-rng.start], berr = a.getBlock(ctx, key, id)
 			} else {
 				var n int
+				var version string
 				blocks[id-rng.start] = make([]byte, a.blockSize)
-				n, berr = a.srcReadAt(key, blocks[id-rng.start], id*a.blockSize)
+				n, version, berr = a.srcReadAt(ctx, key, blocks[id-rng.start], id*a.blockSize)
 				if errors.Is(berr, io.EOF) {
 					berr = nil
 				}
 				if berr != nil {
-					blockID := a.blockKey(key, id)
+					blockID := a.blockKey(ckey, id)
 					a.blmu.Unlock(blockID)
 				} else {
 					if n != int(a.blockSize) {
@@ -484,8 +737,8 @@ func (a *Adapter) getRange(key string, rng blockRange) ([][]byte, error) {
 						copy(smallbuf, blocks[id-rng.start])
 						blocks[id-rng.start] = smallbuf
 					}
-					a.cache.Add(key, uint(id), blocks[id-rng.start])
-					blockID := a.blockKey(key, id)
+					a.cacheBlock(ckey, key, version, id, blocks[id-rng.start])
+					blockID := a.blockKey(ckey, id)
 					a.blmu.Unlock(blockID)
 				}
 			}
@@ -535,7 +788,32 @@ func (a *Adapter) applyBlock(mu *sync.Mutex, block int64, data []byte, written [
 	}
 }
 
+// ReadAtMulti reads len(bufs[i]) bytes at offsets[i] from the object identified by key, for each
+// of the provided buffers. It returns the number of bytes written to each buffer.
 func (a *Adapter) ReadAtMulti(key string, bufs [][]byte, offsets []int64) ([]int, error) {
+	return a.ReadAtMultiContext(context.Background(), key, bufs, offsets)
+}
+
+// ReadAtMultiContext behaves like ReadAtMulti. Source requests issued on behalf of this call are
+// aborted once ctx is done, and waits on blocks being fetched by concurrent callers are given up.
+func (a *Adapter) ReadAtMultiContext(ctx context.Context, key string, bufs [][]byte, offsets []int64) ([]int, error) {
+	ctx, span := a.tracer.Start(ctx, "osio.ReadAtMulti", trace.WithAttributes(AttrKey.String(key),
+		attribute.Int("osio.buffers", len(bufs))))
+	written, err := a.readAtMulti(ctx, key, bufs, offsets)
+	endSpan(span, err)
+	return written, err
+}
+
+func (a *Adapter) readAtMulti(ctx context.Context, key string, bufs [][]byte, offsets []int64) ([]int, error) {
+	if version := versionFromContext(ctx); version != "" {
+		if oi, ok := a.objectInfo(key); ok && oi.version != version {
+			//the cached blocks belong to another version of the object
+			return make([]int, len(bufs)), fmt.Errorf("%s: %w", key, ErrObjectChanged)
+		}
+	}
+	//the blocks of this call are all read from, and cached as, the same version of the object
+	ctx = withVersion(ctx, a.pinnedVersion(ctx, key))
+	ckey := blockCacheKey(ctx, key)
 	blids := make(map[int64]bool)
 	errmu := sync.Mutex{}
 	for ibuf := range bufs {
@@ -549,13 +827,27 @@ func (a *Adapter) ReadAtMulti(key string, bufs [][]byte, offsets []int64) ([]int
 	mu := &sync.Mutex{}
 
 	var err error
+	hits := int32(0)
+	defer func() {
+		if n := atomic.LoadInt32(&hits); n > 0 {
+			trace.SpanFromContext(ctx).AddEvent("cache hits", trace.WithAttributes(
+				attribute.Int("osio.blocks", int(n))))
+		}
+	}()
 	if a.splitRanges {
 		wg := sync.WaitGroup{}
 		wg.Add(len(blids))
 		for k := range blids {
 			go func(bid int64) {
 				defer wg.Done()
-				bdata, berr := a.getBlock(key, bid)
+				var berr error
+				bdata, ok := a.cache.Get(ckey, uint(bid))
+				a.countLookup(key, ok)
+				if ok {
+					atomic.AddInt32(&hits, 1)
+				} else {
+					bdata, berr = a.getBlock(ctx, key, bid)
+				}
 				if berr != nil {
 					errmu.Lock()
 					defer errmu.Unlock()
@@ -571,8 +863,10 @@ func (a *Adapter) ReadAtMulti(key string, bufs [][]byte, offsets []int64) ([]int
 	} else {
 		blocks := make([]int64, 0)
 		for k := range blids {
-			bdata, ok := a.cache.Get(key, uint(k))
+			bdata, ok := a.cache.Get(ckey, uint(k))
+			a.countLookup(key, ok)
 			if ok {
+				hits++
 				a.applyBlock(mu, k, bdata, written, bufs, offsets)
 			} else {
 				blocks = append(blocks, k)
@@ -591,7 +885,7 @@ func (a *Adapter) ReadAtMulti(key string, bufs [][]byte, offsets []int64) ([]int
 					//fmt.Printf("get // range [%d,%d]\n", rng.start, rng.end)
 					go func(rng blockRange) {
 						defer wg.Done()
-						bblocks, berr := a.getRange(key, rng)
+						bblocks, berr := a.getRange(ctx, key, rng)
 						if berr != nil {
 							errmu.Lock()
 							defer errmu.Unlock()
@@ -612,7 +906,7 @@ func (a *Adapter) ReadAtMulti(key string, bufs [][]byte, offsets []int64) ([]int
 			}
 
 			//fmt.Printf("get range [%d,%d]\n", rng.start, rng.end)
-			bblocks, berr := a.getRange(key, rng)
+			bblocks, berr := a.getRange(ctx, key, rng)
 			if berr != nil {
 				errmu.Lock()
 				if err == nil {
@@ -639,28 +933,43 @@ func (a *Adapter) ReadAtMulti(key string, bufs [][]byte, offsets []int64) ([]int
 	return written, err
 }
 
+// ReadAt reads len(p) bytes at offset off from the object identified by key.
 func (a *Adapter) ReadAt(key string, p []byte, off int64) (int, error) {
-	written, err := a.ReadAtMulti(key, [][]byte{p}, []int64{off})
+	return a.ReadAtContext(context.Background(), key, p, off)
+}
+
+// ReadAtContext behaves like ReadAt, with the cancellation semantics of ReadAtMultiContext.
+func (a *Adapter) ReadAtContext(ctx context.Context, key string, p []byte, off int64) (int, error) {
+	written, err := a.ReadAtMultiContext(ctx, key, [][]byte{p}, []int64{off})
 	return written[0], err
 }
 
+// Size returns the size of the object identified by key, or syscall.ENOENT if it does not exist.
 func (a *Adapter) Size(key string) (int64, error) {
+	return a.SizeContext(context.Background(), key)
+}
+
+// SizeContext behaves like Size, aborting any source request once ctx is done.
+func (a *Adapter) SizeContext(ctx context.Context, key string) (int64, error) {
 	si, ok := a.sizeCache.Get(key)
 	var err error
+	if ok && si.(objectInfo).size == -1 {
+		a.count(key, func(c *statCounters) { atomic.AddUint64(&c.enoentHits, 1) })
+	}
 	if !ok {
-		_, err = a.ReadAt(key, []byte{0}, 0) //ignore errors as we just want to populate the size cache
+		_, err = a.ReadAtContext(ctx, key, []byte{0}, 0) //ignore errors as we just want to populate the size cache
 		si, ok = a.sizeCache.Get(key)
 	}
 	if err == nil && !ok {
 		//first block may be in the block cache, but the size was evicted from the size cache, so we force
 		//a direct read to the source to repopulate the size cache. This should happen extremely
 		//unfrequently.
-		_, err = a.srcReadAt(key, []byte{0}, 0)
+		_, _, err = a.srcReadAt(ctx, key, []byte{0}, 0)
 		si, ok = a.sizeCache.Get(key)
 	}
 
 	if ok {
-		size := si.(int64)
+		size := si.(objectInfo).size
 		if size == -1 {
 			return -1, syscall.ENOENT
 		}
@@ -676,46 +985,78 @@ func (a *Adapter) blockKey(key string, id int64) string {
 	return fmt.Sprintf("%s-%d", key, id)
 }
 
-func (a *Adapter) getBlock(key string, id int64) ([]byte, error) {
-	blockData, ok := a.cache.Get(key, uint(id))
+func (a *Adapter) getBlock(ctx context.Context, key string, id int64) ([]byte, error) {
+	ckey := blockCacheKey(ctx, key)
+	blockData, ok := a.cache.Get(ckey, uint(id))
 	if ok {
 		return blockData, nil
 	}
-	blockID := a.blockKey(key, id)
-	if a.blmu.Lock(blockID) {
+	blockID := a.blockKey(ckey, id)
+	st := time.Now()
+	locked, err := a.blmu.LockContext(ctx, blockID)
+	if !locked {
+		wait := time.Since(st)
+		trace.SpanFromContext(ctx).AddEvent("lock wait", trace.WithAttributes(
+			AttrBlock.Int64(id), attribute.Int64("osio.wait_us", wait.Microseconds())))
+		a.count(key, func(c *statCounters) {
+			atomic.AddUint64(&c.lockWaits, 1)
+			atomic.AddUint64(&c.lockWaitNanos, uint64(wait))
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+	if locked {
 		buf := make([]byte, a.blockSize)
-		n, err := a.srcReadAt(key, buf, int64(id)*a.blockSize)
+		n, version, err := a.srcReadAt(ctx, key, buf, int64(id)*a.blockSize)
 		if err != nil && !errors.Is(err, io.EOF) {
 			a.blmu.Unlock(blockID)
 			return nil, err
 		}
 		if n > 0 {
-			buf = buf[0:n]
-			a.cache.Add(key, uint(id), buf)
+			if n != int(a.blockSize) {
+				//if smaller than block size, store smaller block to cache
+				smallbuf := make([]byte, n)
+				copy(smallbuf, buf)
+				buf = smallbuf
+			}
+			a.cacheBlock(ckey, key, version, id, buf)
 		} else {
 			buf = nil
-			a.cache.Add(key, uint(id), buf)
+			a.cacheBlock(ckey, key, version, id, buf)
 		}
 		a.blmu.Unlock(blockID)
 		return buf, nil
 	}
 	//else (lock not acquired, recheck from cache)
-	return a.getBlock(key, id)
+	return a.getBlock(ctx, key, id)
 }
 
+// Reader is an io.ReadSeeker and io.ReaderAt on an object served by an Adapter.
+//
+// If the underlying KeyStreamerAt implements KeyVersionStreamerAt, all the data read through a
+// Reader comes from the version of the object that existed when the Reader was created, and
+// reads fail with ErrObjectChanged once the object has been modified.
 type Reader struct {
-	a    *Adapter
-	key  string
-	size int64
-	off  int64
+	a       *Adapter
+	ctx     context.Context
+	key     string
+	size    int64
+	version string
+	off     int64
+	ra      readAhead
 }
 
 func (r *Reader) Read(buf []byte) (int, error) {
 	if r.off >= r.size {
 		return 0, io.EOF
 	}
-	n, err := r.a.ReadAt(r.key, buf, r.off)
+	start := r.off
+	n, err := r.a.ReadAtContext(r.ctx, r.key, buf, r.off)
 	r.off += int64(n)
+	if err == nil {
+		r.readAhead(start)
+	}
 	return n, err
 }
 
@@ -723,7 +1064,7 @@ func (r *Reader) ReadAt(buf []byte, off int64) (int, error) {
 	if off >= r.size {
 		return 0, io.EOF
 	}
-	return r.a.ReadAt(r.key, buf, off)
+	return r.a.ReadAtContext(r.ctx, r.key, buf, off)
 }
 
 func (r *Reader) ReadAtMulti(bufs [][]byte, offs []int64) ([]int, error) {
@@ -732,7 +1073,7 @@ func (r *Reader) ReadAtMulti(bufs [][]byte, offs []int64) ([]int, error) {
 			return nil, io.EOF
 		}
 	}
-	return r.a.ReadAtMulti(r.key, bufs, offs)
+	return r.a.ReadAtMultiContext(r.ctx, r.key, bufs, offs)
 }
 
 func (r *Reader) Seek(off int64, nWhence int) (int64, error) {
@@ -758,15 +1099,32 @@ func (r *Reader) Size() int64 {
 	return r.size
 }
 
+// Version returns the version of the object the Reader is bound to, or an empty string if
+// the KeyStreamerAt does not report object versions
+func (r *Reader) Version() string {
+	return r.version
+}
+
+// Reader returns a Reader on the object identified by key.
 func (a *Adapter) Reader(key string) (*Reader, error) {
-	size, err := a.Size(key)
+	return a.ReaderContext(context.Background(), key)
+}
+
+// ReaderContext returns a Reader on the object identified by key. All the reads made through the
+// returned Reader are bound to ctx, i.e. they are aborted once ctx is done.
+func (a *Adapter) ReaderContext(ctx context.Context, key string) (*Reader, error) {
+	size, err := a.SizeContext(ctx, key)
 	if err != nil {
 		return nil, err
 	}
+	oi, _ := a.objectInfo(key)
 	return &Reader{
-		a:    a,
-		key:  key,
-		size: size,
-		off:  0,
+		a:       a,
+		ctx:     withVersion(ctx, oi.version),
+		key:     key,
+		size:    size,
+		version: oi.version,
+		off:     0,
+		ra:      readAhead{max: a.readAhead},
 	}, nil
 }
@@ -16,6 +16,7 @@ package osio
 
 import (
 	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -23,6 +24,7 @@ import (
 	"log"
 	"os"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"testing"
 	"time"
@@ -71,6 +73,47 @@ func TestAdapterOptions(t *testing.T) {
 	assert.Error(t, err)
 	_, err = NewAdapter(kr, SizeCache(100))
 	assert.NoError(t, err)
+	_, err = NewAdapter(kr, BlockSize("0.0001k"))
+	assert.Error(t, err)
+	_, err = NewAdapter(kr, BlockCacheSize("2GB"))
+	assert.NoError(t, err)
+	_, err = NewAdapter(kr, BlockCacheSize("1t"))
+	assert.NoError(t, err)
+	_, err = NewAdapter(kr, BlockCacheSize("1PB"))
+	assert.Error(t, err)
+	_, err = NewAdapter(kr, BlockCacheSize("0"))
+	assert.Error(t, err)
+	_, err = NewAdapter(kr, BlockCacheSize("1g"), NumCachedBlocks(10))
+	assert.Error(t, err)
+	_, err = NewAdapter(kr, BlockCacheSize("1g"), BlockCache(ccache))
+	assert.Error(t, err)
+}
+
+func TestBlockCacheSize(t *testing.T) {
+	bc, _ := NewAdapter(rr, BlockSize("1k"), BlockCacheSize("1.5k"))
+	cache := bc.cache.(*ByteLRUCache)
+	buf := make([]byte, 4)
+	_, err := bc.ReadAt("a", buf, 0)
+	assert.NoError(t, err)
+	_, err = bc.ReadAt("b", buf, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1024), cache.Usage())
+	//last block of 1024 byte files is 0 bytes long
+	_, err = bc.ReadAt("a", buf, 1022)
+	assert.ErrorIs(t, err, io.EOF)
+	assert.Equal(t, int64(1024), cache.Usage())
+	assert.Equal(t, 2, cache.Len())
+
+	bc, _ = NewAdapter(TReader{make([]byte, 1100)}, BlockSize("1k"), BlockCacheSize("1.5k"))
+	cache = bc.cache.(*ByteLRUCache)
+	buf = make([]byte, 1100)
+	_, err = bc.ReadAt("a", buf, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1100), cache.Usage())
+	_, err = bc.ReadAt("b", buf[:100], 1000)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1176), cache.Usage()) //a/0 evicted, a/1 and b/0,b/1 cached
+	assert.Equal(t, 3, cache.Len())
 }
 
 type TReader struct {
@@ -475,3 +518,217 @@ func TestSizeCacheEviction(t *testing.T) {
 	_, err = bc.Size("thekey")
 	assert.NoError(t, err)
 }
+
+// CReader is a KeyStreamerAtContext whose requests take delay to complete, unless their
+// context is done before.
+type CReader struct {
+	TReader
+	delay time.Duration
+	calls *int32
+}
+
+func (r CReader) StreamAtContext(ctx context.Context, key string, off int64, n int64) (io.ReadCloser, int64, error) {
+	atomic.AddInt32(r.calls, 1)
+	select {
+	case <-time.After(r.delay):
+	case <-ctx.Done():
+		return nil, 0, ctx.Err()
+	}
+	return r.TReader.StreamAt(key, off, n)
+}
+
+func TestContext(t *testing.T) {
+	calls := int32(0)
+	cr := CReader{TReader: rr, delay: 100 * time.Millisecond, calls: &calls}
+	bc, _ := NewAdapter(cr, BlockSize("4"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	buf := make([]byte, 4)
+	_, err := bc.ReadAtContext(ctx, "", buf, 0)
+	assert.ErrorIs(t, err, context.Canceled)
+	_, err = bc.SizeContext(ctx, "")
+	assert.ErrorIs(t, err, context.Canceled)
+	_, err = bc.ReaderContext(ctx, "")
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, int32(0), atomic.LoadInt32(&calls))
+
+	//the fetch is aborted
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	st := time.Now()
+	_, err = bc.ReadAtContext(ctx, "", buf, 0)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, int64(time.Since(st)), int64(50*time.Millisecond))
+
+	//a waiter giving up does not cancel the fetch other callers are waiting on
+	atomic.StoreInt32(&calls, 0)
+	wg := sync.WaitGroup{}
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		buf := make([]byte, 4)
+		n, err := bc.ReadAtContext(context.Background(), "", buf, 8)
+		assert.NoError(t, err)
+		assert.Equal(t, 4, n)
+		assert.Equal(t, []byte{2, 2, 2, 2}, buf)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	go func() {
+		defer wg.Done()
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		buf := make([]byte, 8)
+		_, err := bc.ReadAtContext(ctx, "", buf, 4)
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+	}()
+	wg.Wait()
+	buf = make([]byte, 4)
+	_, err = bc.ReadAt("", buf, 8)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{2, 2, 2, 2}, buf)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls)) //served from cache
+
+	//reader bound to a context
+	ctx, cancel = context.WithCancel(context.Background())
+	r, err := bc.ReaderContext(ctx, "")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1024), r.Size())
+	cancel()
+	_, err = r.ReadAt(buf, 100)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+// VReader is a KeyVersionStreamerAt serving a single object that can be overwritten
+type VReader struct {
+	mu      sync.Mutex
+	data    []byte
+	version int
+}
+
+func (r *VReader) put(data []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.data = data
+	r.version++
+}
+
+func (r *VReader) StreamAt(key string, off int64, n int64) (io.ReadCloser, int64, error) {
+	return r.StreamAtContext(context.Background(), key, off, n)
+}
+
+func (r *VReader) StreamAtContext(ctx context.Context, key string, off int64, n int64) (io.ReadCloser, int64, error) {
+	rc, size, _, err := r.StreamAtVersion(ctx, key, off, n, "")
+	return rc, size, err
+}
+
+func (r *VReader) StreamAtVersion(ctx context.Context, key string, off int64, n int64, version string) (io.ReadCloser, int64, string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	cur := fmt.Sprintf("v%d", r.version)
+	if version != "" && version != cur {
+		return nil, 0, "", fmt.Errorf("version %s: %w", version, ErrObjectChanged)
+	}
+	rc, size, err := TReader{r.data}.StreamAt(key, off, n)
+	return rc, size, cur, err
+}
+
+func TestObjectVersion(t *testing.T) {
+	vr := &VReader{}
+	vr.put([]byte("aaaabbbbcccc"))
+	ll := &logger{}
+	bc, _ := NewAdapter(vr, BlockSize("4"), WithLogger(ll))
+
+	r, err := bc.Reader("key")
+	assert.NoError(t, err)
+	assert.Equal(t, "v1", r.Version())
+	buf := make([]byte, 4)
+	_, err = r.ReadAt(buf, 4)
+	assert.NoError(t, err)
+	assert.Equal(t, "bbbb", string(buf))
+
+	vr.put([]byte("ddddeeeeffffgggg"))
+
+	//cached blocks are still served
+	_, err = r.ReadAt(buf, 4)
+	assert.NoError(t, err)
+	//new blocks must be fetched from the recorded version
+	_, err = r.ReadAt(buf, 8)
+	assert.ErrorIs(t, err, ErrObjectChanged)
+	//cached blocks and size have been invalidated
+	ll.last = ""
+	_, err = r.ReadAt(buf, 4)
+	assert.ErrorIs(t, err, ErrObjectChanged)
+	assert.Equal(t, "key: 4-4", ll.last)
+
+	//a new reader sees the new version, while the old one keeps failing
+	r2, err := bc.Reader("key")
+	assert.NoError(t, err)
+	assert.Equal(t, "v2", r2.Version())
+	assert.Equal(t, int64(16), r2.Size())
+	data, err := io.ReadAll(r2)
+	assert.NoError(t, err)
+	assert.Equal(t, "ddddeeeeffffgggg", string(data))
+	_, err = r.ReadAt(buf, 4)
+	assert.ErrorIs(t, err, ErrObjectChanged)
+
+	//blocks of another version are not served once the size cache entry is gone
+	vr = &VReader{}
+	vr.put([]byte("aaaabbbbcccc"))
+	bc, _ = NewAdapter(vr, BlockSize("4"))
+	r, _ = bc.Reader("key")
+	vr.put([]byte("ddddeeeeffff"))
+	bc.sizeCache.Remove("key")
+	_, err = bc.ReadAt("key", buf, 4)
+	assert.NoError(t, err)
+	assert.Equal(t, "eeee", string(buf))
+	_, err = r.ReadAt(buf, 4)
+	assert.ErrorIs(t, err, ErrObjectChanged)
+
+	//unversioned handlers
+	bc, _ = NewAdapter(rr)
+	r, _ = bc.Reader("key")
+	assert.Equal(t, "", r.Version())
+	_, err = r.ReadAt(buf, 4)
+	assert.NoError(t, err)
+}
+
+// sizelessVReader is a VReader that does not report object sizes for reads at off>0, like the
+// S3, HTTP and Azure handlers, and counts the requests it serves
+type sizelessVReader struct {
+	VReader
+	calls int32
+}
+
+func (r *sizelessVReader) StreamAtVersion(ctx context.Context, key string, off int64, n int64, version string) (io.ReadCloser, int64, string, error) {
+	atomic.AddInt32(&r.calls, 1)
+	rc, size, cur, err := r.VReader.StreamAtVersion(ctx, key, off, n, version)
+	if off > 0 {
+		size = 0
+	}
+	return rc, size, cur, err
+}
+
+func TestObjectVersionUnknownSize(t *testing.T) {
+	vr := &sizelessVReader{}
+	vr.put([]byte("aaaabbbbcccc"))
+	bc, _ := NewAdapter(vr, BlockSize("4"))
+
+	buf := make([]byte, 4)
+	for i := 0; i < 3; i++ {
+		_, err := bc.ReadAt("key", buf, 4)
+		assert.NoError(t, err)
+		assert.Equal(t, "bbbb", string(buf))
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&vr.calls))
+
+	//readers pinned to the version later on are served the same blocks
+	r, err := bc.Reader("key")
+	assert.NoError(t, err)
+	assert.Equal(t, "v1", r.Version())
+	calls := atomic.LoadInt32(&vr.calls)
+	_, err = r.ReadAt(buf, 4)
+	assert.NoError(t, err)
+	assert.Equal(t, "bbbb", string(buf))
+	assert.Equal(t, calls, atomic.LoadInt32(&vr.calls))
+}
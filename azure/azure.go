@@ -0,0 +1,331 @@
+// Copyright 2021 Airbus Defence and Space
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azure
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/airbusgeo/errs"
+	"github.com/airbusgeo/osio"
+	"github.com/airbusgeo/osio/internal"
+)
+
+// apiVersion is the version of the blob service REST API used by the handler
+const apiVersion = "2020-10-02"
+
+// Client is the interface used to send requests to the blob service. It is
+// implemented by *http.Client
+type Client interface {
+	Do(*http.Request) (*http.Response, error)
+}
+
+var _ osio.KeyVersionStreamerAt = &Handler{}
+
+type Handler struct {
+	ctx        context.Context
+	client     Client
+	account    string
+	key        string
+	sharedKey  []byte
+	sasToken   string
+	serviceURL string
+}
+
+// AzureOption is an option that can be passed to Handle
+type AzureOption func(o *Handler)
+
+// AzureClient sets the http client that will be used by the handler
+func AzureClient(cl Client) AzureOption {
+	return func(o *Handler) {
+		o.client = cl
+	}
+}
+
+// AzureAccount sets the storage account used to resolve az://container/blob keys
+func AzureAccount(account string) AzureOption {
+	return func(o *Handler) {
+		o.account = account
+	}
+}
+
+// AzureSharedKey authenticates requests with the base64 encoded access key of
+// the given storage account. Requests to blobs of other accounts are not authenticated.
+func AzureSharedKey(account, key string) AzureOption {
+	return func(o *Handler) {
+		o.account = account
+		o.key = key
+	}
+}
+
+// AzureSASToken authenticates requests with a shared access signature
+func AzureSASToken(token string) AzureOption {
+	return func(o *Handler) {
+		o.sasToken = strings.TrimPrefix(token, "?")
+	}
+}
+
+// AzureServiceURL sets the url of the blob service used to resolve az://container/blob
+// keys, e.g. http://127.0.0.1:10000/devstoreaccount1 for a local Azurite emulator.
+// If not provided, https://<account>.blob.core.windows.net is used.
+func AzureServiceURL(serviceURL string) AzureOption {
+	return func(o *Handler) {
+		o.serviceURL = strings.TrimSuffix(serviceURL, "/")
+	}
+}
+
+// Handle creates a KeyStreamerAt suitable for constructing an Adapter
+// that accesses objects on Azure Blob Storage. Keys are either of the form
+// az://container/blob, https://<account>.blob.core.windows.net/container/blob or, for
+// emulators, path-style http://host/<account>/container/blob
+func Handle(ctx context.Context, opts ...AzureOption) (*Handler, error) {
+	handler := &Handler{
+		ctx: ctx,
+	}
+	for _, o := range opts {
+		o(handler)
+	}
+	if handler.key != "" {
+		k, err := base64.StdEncoding.DecodeString(handler.key)
+		if err != nil {
+			return nil, fmt.Errorf("decode shared key: %w", err)
+		}
+		handler.sharedKey = k
+	}
+	if handler.client == nil {
+		handler.client = &http.Client{}
+	}
+	return handler, nil
+}
+
+type readWrapper struct {
+	io.ReadCloser
+}
+
+func (r readWrapper) Read(buf []byte) (int, error) {
+	n, err := r.ReadCloser.Read(buf)
+	if err != nil {
+		return n, errs.AddTemporaryCheck(err)
+	}
+	return n, nil
+}
+func (r readWrapper) Close() error {
+	err := r.ReadCloser.Close()
+	if err != nil {
+		return errs.AddTemporaryCheck(err)
+	}
+	return nil
+}
+
+// blobURL returns the url of the blob identified by key and the storage account it belongs to,
+// along with its canonical az://container/blob name. The account of https:// keys is taken from
+// their <account>.blob.core.windows.net host, or from the first element of their path for
+// path-style urls (e.g. http://127.0.0.1:10000/devstoreaccount1/container/blob) that are not
+// under the configured service url.
+func (h *Handler) blobURL(key string) (*url.URL, string, string, error) {
+	if strings.HasPrefix(key, "https://") || strings.HasPrefix(key, "http://") {
+		u, err := url.Parse(key)
+		if err != nil {
+			return nil, "", "", err
+		}
+		account, path := h.account, u.Path
+		switch {
+		case h.serviceURL != "" && strings.HasPrefix(key, h.serviceURL+"/"):
+			path = key[len(h.serviceURL):]
+		case strings.Contains(u.Hostname(), ".blob."):
+			account = u.Hostname()[:strings.Index(u.Hostname(), ".blob.")]
+		default:
+			path = strings.TrimPrefix(path, "/")
+			idx := strings.Index(path, "/")
+			if idx <= 0 {
+				return nil, "", "", fmt.Errorf("cannot resolve %s: no storage account", key)
+			}
+			account, path = path[:idx], path[idx:]
+		}
+		container, blob, err := internal.BucketObject(path)
+		if err != nil {
+			return nil, "", "", err
+		}
+		return u, "az://" + container + "/" + blob, account, nil
+	}
+	container, blob, err := internal.BucketObject(key)
+	if err != nil {
+		return nil, "", "", err
+	}
+	service := h.serviceURL
+	if service == "" {
+		if h.account == "" {
+			return nil, "", "", fmt.Errorf("cannot resolve %s: no storage account configured", key)
+		}
+		service = "https://" + h.account + ".blob.core.windows.net"
+	}
+	u, err := url.Parse(service + "/" + container + "/" + blob)
+	if err != nil {
+		return nil, "", "", err
+	}
+	return u, "az://" + container + "/" + blob, h.account, nil
+}
+
+// sign adds a SharedKey authorization header for account to req
+// (c.f. https://docs.microsoft.com/en-us/rest/api/storageservices/authorize-with-shared-key)
+func (h *Handler) sign(req *http.Request, account string) {
+	xms := []string{}
+	for k := range req.Header {
+		if lk := strings.ToLower(k); strings.HasPrefix(lk, "x-ms-") {
+			xms = append(xms, lk)
+		}
+	}
+	sort.Strings(xms)
+	canonicalHeaders := ""
+	for _, k := range xms {
+		canonicalHeaders += k + ":" + strings.TrimSpace(req.Header.Get(k)) + "\n"
+	}
+	canonicalResource := "/" + account + req.URL.EscapedPath()
+	query := map[string][]string{}
+	params := []string{}
+	for k, v := range req.URL.Query() {
+		lk := strings.ToLower(k)
+		if _, ok := query[lk]; !ok {
+			params = append(params, lk)
+		}
+		query[lk] = append(query[lk], v...)
+	}
+	sort.Strings(params)
+	for _, k := range params {
+		values := query[k]
+		sort.Strings(values)
+		canonicalResource += "\n" + k + ":" + strings.Join(values, ",")
+	}
+	toSign := strings.Join([]string{
+		req.Method,
+		req.Header.Get("Content-Encoding"),
+		req.Header.Get("Content-Language"),
+		"", //Content-Length, empty as we only send requests without body
+		req.Header.Get("Content-MD5"),
+		req.Header.Get("Content-Type"),
+		"", //Date, superseded by x-ms-date
+		req.Header.Get("If-Modified-Since"),
+		req.Header.Get("If-Match"),
+		req.Header.Get("If-None-Match"),
+		req.Header.Get("If-Unmodified-Since"),
+		req.Header.Get("Range"),
+		canonicalHeaders + canonicalResource,
+	}, "\n")
+	mac := hmac.New(sha256.New, h.sharedKey)
+	mac.Write([]byte(toSign))
+	signature := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	req.Header.Set("Authorization", "SharedKey "+account+":"+signature)
+}
+
+// totalSize extracts the total object size from a "bytes 0-99/1234" content range
+func totalSize(r *http.Response) (int64, error) {
+	cr := r.Header.Get("Content-Range")
+	if cr == "" {
+		return r.ContentLength, nil
+	}
+	idx := strings.LastIndex(cr, "/")
+	if idx == -1 {
+		return 0, fmt.Errorf("invalid content-range %s", cr)
+	}
+	return strconv.ParseInt(cr[idx+1:], 10, 64)
+}
+
+// StreamAt implements osio.KeyStreamerAt, using the context the handler was created with
+func (h *Handler) StreamAt(key string, off int64, n int64) (io.ReadCloser, int64, error) {
+	return h.StreamAtContext(h.ctx, key, off, n)
+}
+
+// StreamAtContext implements osio.KeyStreamerAtContext
+func (h *Handler) StreamAtContext(ctx context.Context, key string, off int64, n int64) (io.ReadCloser, int64, error) {
+	r, size, _, err := h.StreamAtVersion(ctx, key, off, n, "")
+	return r, size, err
+}
+
+// StreamAtVersion implements osio.KeyVersionStreamerAt. The version of a blob is its ETag
+func (h *Handler) StreamAtVersion(ctx context.Context, key string, off int64, n int64, version string) (io.ReadCloser, int64, string, error) {
+	//requests are also canceled with the context the handler was created with
+	ctx, cancel := internal.MergeContext(ctx, h.ctx)
+	rc, size, version, err := h.streamAtVersion(ctx, key, off, n, version)
+	return internal.CancelOnClose(rc, cancel), size, version, err
+}
+
+func (h *Handler) streamAtVersion(ctx context.Context, key string, off int64, n int64, version string) (io.ReadCloser, int64, string, error) {
+	u, name, account, err := h.blobURL(key)
+	if err != nil {
+		return nil, 0, "", err
+	}
+	if h.sasToken != "" {
+		if u.RawQuery != "" {
+			u.RawQuery += "&"
+		}
+		u.RawQuery += h.sasToken
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+	if err != nil {
+		return nil, 0, "", fmt.Errorf("new reader for %s: %w", name, err)
+	}
+	req.Header.Set("x-ms-version", apiVersion)
+	req.Header.Set("x-ms-date", time.Now().UTC().Format(http.TimeFormat))
+	req.Header.Set("x-ms-range", fmt.Sprintf("bytes=%d-%d", off, off+n-1))
+	if version != "" {
+		req.Header.Set("If-Match", version)
+	}
+	//the shared key only authenticates the requests to its own account
+	if h.sharedKey != nil && account == h.account {
+		h.sign(req, account)
+	}
+	r, err := h.client.Do(req)
+	if err != nil {
+		return nil, 0, "", errs.AddTemporaryCheck(fmt.Errorf("new reader for %s: %w", name, err))
+	}
+	switch r.StatusCode {
+	case 200, 206:
+	case 404:
+		r.Body.Close()
+		return nil, -1, "", syscall.ENOENT
+	case 412:
+		r.Body.Close()
+		return nil, 0, "", fmt.Errorf("new reader for %s: %w", name, osio.ErrObjectChanged)
+	case 416:
+		r.Body.Close()
+		return nil, 0, r.Header.Get("ETag"), io.EOF
+	default:
+		r.Body.Close()
+		err = fmt.Errorf("new reader for %s: status code %d %s", name, r.StatusCode, r.Header.Get("x-ms-error-code"))
+		if r.StatusCode == 429 || r.StatusCode >= 500 {
+			err = errs.MakeTemporary(err)
+		}
+		return nil, 0, "", err
+	}
+	var size int64
+	if off == 0 {
+		if size, err = totalSize(r); err != nil {
+			r.Body.Close()
+			return nil, 0, "", fmt.Errorf("new reader for %s: %w", name, err)
+		}
+	}
+	return readWrapper{r.Body}, size, r.Header.Get("ETag"), nil
+}
@@ -0,0 +1,308 @@
+// Copyright 2021 Airbus Defence and Space
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package azure
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/airbusgeo/osio"
+	"github.com/stretchr/testify/assert"
+)
+
+// blobService is an azurite-like stand-in serving path-style /account/container/blob urls,
+// and /container/blob urls on <account>.blob.core.windows.net hosts. All the accounts serve
+// the same blobs.
+func blobService(t *testing.T, blobs map[string]string, checkAuth func(account string, r *http.Request) bool) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("x-ms-version") == "" {
+			w.WriteHeader(400)
+			return
+		}
+		var account, path string
+		if idx := strings.Index(r.Host, ".blob.core.windows.net"); idx > 0 {
+			account, path = r.Host[:idx], strings.TrimPrefix(r.URL.Path, "/")
+		} else {
+			parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)
+			if len(parts) != 2 {
+				w.WriteHeader(400)
+				return
+			}
+			account, path = parts[0], parts[1]
+		}
+		if !checkAuth(account, r) {
+			w.Header().Set("x-ms-error-code", "AuthenticationFailed")
+			w.WriteHeader(403)
+			return
+		}
+		if strings.HasPrefix(path, "unavailable/") {
+			w.Header().Set("x-ms-error-code", "ServerBusy")
+			w.WriteHeader(503)
+			return
+		}
+		blob, ok := blobs[path]
+		if !ok {
+			if strings.HasPrefix(path, "container/") {
+				w.Header().Set("x-ms-error-code", "BlobNotFound")
+			} else {
+				w.Header().Set("x-ms-error-code", "ContainerNotFound")
+			}
+			w.WriteHeader(404)
+			return
+		}
+		etag := fmt.Sprintf(`"%x"`, sha256.Sum256([]byte(blob)))
+		w.Header().Set("ETag", etag)
+		if im := r.Header.Get("If-Match"); im != "" && im != etag {
+			w.Header().Set("x-ms-error-code", "ConditionNotMet")
+			w.WriteHeader(412)
+			return
+		}
+		var start, end int64
+		if _, err := fmt.Sscanf(r.Header.Get("x-ms-range"), "bytes=%d-%d", &start, &end); err != nil {
+			w.WriteHeader(400)
+			return
+		}
+		size := int64(len(blob))
+		if start >= size {
+			w.Header().Set("x-ms-error-code", "InvalidRange")
+			w.WriteHeader(416)
+			return
+		}
+		if end >= size {
+			end = size - 1
+		}
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, end, size))
+		w.WriteHeader(206)
+		_, _ = io.WriteString(w, blob[start:end+1])
+	}))
+}
+
+// sharedKeyAuth checks that requests to account are signed with key, and that requests to
+// other accounts are anonymous
+func sharedKeyAuth(account string, key []byte) func(string, *http.Request) bool {
+	return func(reqAccount string, r *http.Request) bool {
+		auth := r.Header.Get("Authorization")
+		if reqAccount != account {
+			return auth == ""
+		}
+		headers := []string{}
+		for k := range r.Header {
+			if lk := strings.ToLower(k); strings.HasPrefix(lk, "x-ms-") {
+				headers = append(headers, lk+":"+strings.TrimSpace(r.Header.Get(k))+"\n")
+			}
+		}
+		sort.Strings(headers)
+		resource := "/" + account + r.URL.EscapedPath()
+		query := r.URL.Query()
+		params := []string{}
+		for k := range query {
+			params = append(params, k)
+		}
+		sort.Strings(params)
+		for _, k := range params {
+			resource += "\n" + strings.ToLower(k) + ":" + strings.Join(query[k], ",")
+		}
+		toSign := r.Method + "\n\n\n\n\n\n\n\n" + r.Header.Get("If-Match") + "\n\n\n\n" +
+			strings.Join(headers, "") + resource
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(toSign))
+		return auth == "SharedKey "+account+":"+base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	}
+}
+
+// hostClient sends all the requests to addr, keeping their original Host header
+type hostClient struct {
+	cl   *http.Client
+	addr string
+}
+
+func (hc hostClient) Do(req *http.Request) (*http.Response, error) {
+	req.Host = req.URL.Host
+	req.URL.Scheme = "http"
+	req.URL.Host = hc.addr
+	return hc.cl.Do(req)
+}
+
+func TestAzure(t *testing.T) {
+	ctx := context.Background()
+	blobs := map[string]string{
+		"container/test.txt":      "0123456789",
+		"container/dir/file.txt":  "abc",
+		"container/versioned.txt": "aaaabbbbcccc",
+	}
+	srv := blobService(t, blobs, sharedKeyAuth("devstoreaccount1", []byte("foobar")))
+	defer srv.Close()
+
+	_, err := Handle(ctx, AzureSharedKey("devstoreaccount1", "not base64"))
+	assert.Error(t, err)
+
+	az, err := Handle(ctx, AzureClient(srv.Client()), AzureServiceURL(srv.URL+"/devstoreaccount1/"),
+		AzureSharedKey("devstoreaccount1", "Zm9vYmFy"))
+	assert.NoError(t, err)
+	aza, _ := osio.NewAdapter(az, osio.BlockSize("4"))
+
+	r, err := aza.Reader("az://container/test.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(10), r.Size())
+	buf := make([]byte, 5)
+	n, err := r.ReadAt(buf, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, "34567", string(buf[:n]))
+	n, err = r.ReadAt(buf, 8)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, "89", string(buf[:n]))
+
+	r, err = aza.Reader("container/dir/file.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), r.Size())
+
+	r, err = aza.Reader(srv.URL + "/devstoreaccount1/container/test.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(10), r.Size())
+
+	_, err = aza.Reader("az://container/doesnotexist.txt")
+	assert.Equal(t, syscall.ENOENT, err)
+	_, err = aza.Reader("az://nocontainer/test.txt")
+	assert.Equal(t, syscall.ENOENT, err)
+
+	//overwritten blob
+	r, err = aza.Reader("az://container/versioned.txt")
+	assert.NoError(t, err)
+	assert.NotEmpty(t, r.Version())
+	blobs["container/versioned.txt"] = "ddddeeeeffff"
+	_, err = r.ReadAt(buf[:4], 0) //cached
+	assert.NoError(t, err)
+	_, err = r.ReadAt(buf[:4], 4)
+	assert.ErrorIs(t, err, osio.ErrObjectChanged)
+	r, err = aza.Reader("az://container/versioned.txt")
+	assert.NoError(t, err)
+	n, err = r.ReadAt(buf[:4], 4)
+	assert.NoError(t, err)
+	assert.Equal(t, "eeee", string(buf[:n]))
+
+	//invalid container/blob
+	_, err = aza.Reader("az://container")
+	assert.Error(t, err)
+	_, err = aza.Reader("container/")
+	assert.Error(t, err)
+
+	//server errors are temporary
+	_, _, err = az.StreamAt("az://unavailable/test.txt", 0, 10)
+	assert.Contains(t, err.Error(), "503 ServerBusy")
+	tmp, ok := err.(interface{ Temporary() bool })
+	assert.True(t, ok && tmp.Temporary())
+
+	//wrong key
+	az, _ = Handle(ctx, AzureClient(srv.Client()), AzureServiceURL(srv.URL+"/devstoreaccount1/"),
+		AzureSharedKey("devstoreaccount1", "Zm9vYmF6"))
+	_, _, err = az.StreamAt("az://container/test.txt", 0, 10)
+	assert.Contains(t, err.Error(), "403 AuthenticationFailed")
+
+	//no account
+	az, _ = Handle(ctx)
+	_, _, err = az.StreamAt("az://container/test.txt", 0, 10)
+	assert.Error(t, err)
+}
+
+func TestAzureURLKeys(t *testing.T) {
+	ctx := context.Background()
+	blobs := map[string]string{
+		"container/dir/test.txt": "0123456789",
+	}
+	srv := blobService(t, blobs, sharedKeyAuth("devstoreaccount1", []byte("foobar")))
+	defer srv.Close()
+
+	az, _ := Handle(ctx, AzureClient(srv.Client()), AzureServiceURL(srv.URL+"/devstoreaccount1"),
+		AzureSharedKey("devstoreaccount1", "Zm9vYmFy"))
+	for _, key := range []string{
+		srv.URL + "/devstoreaccount1/container/dir/test.txt",
+		"az://container/dir/test.txt",
+	} {
+		u, name, account, err := az.blobURL(key)
+		assert.NoError(t, err)
+		assert.Equal(t, srv.URL+"/devstoreaccount1/container/dir/test.txt", u.String())
+		assert.Equal(t, "az://container/dir/test.txt", name)
+		assert.Equal(t, "devstoreaccount1", account)
+	}
+	_, name, account, err := az.blobURL("https://other.blob.core.windows.net/container/dir/test.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, "az://container/dir/test.txt", name)
+	assert.Equal(t, "other", account)
+	_, name, account, err = az.blobURL("http://127.0.0.1:10000/other/container/dir/test.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, "az://container/dir/test.txt", name)
+	assert.Equal(t, "other", account)
+	_, _, _, err = az.blobURL("http://127.0.0.1:10000/other")
+	assert.Error(t, err)
+
+	//path-style keys of the account of the shared key are signed, other accounts are not
+	aza, _ := osio.NewAdapter(az)
+	r, err := aza.Reader(srv.URL + "/devstoreaccount1/container/dir/test.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(10), r.Size())
+	r, err = aza.Reader(srv.URL + "/other/container/dir/test.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(10), r.Size())
+
+	//and so are the keys on <account>.blob.core.windows.net hosts
+	az, _ = Handle(ctx, AzureClient(hostClient{srv.Client(), srv.Listener.Addr().String()}),
+		AzureSharedKey("devstoreaccount1", "Zm9vYmFy"))
+	aza, _ = osio.NewAdapter(az)
+	for _, key := range []string{
+		"az://container/dir/test.txt",
+		"https://devstoreaccount1.blob.core.windows.net/container/dir/test.txt",
+		"https://other.blob.core.windows.net/container/dir/test.txt",
+	} {
+		r, err = aza.Reader(key)
+		assert.NoError(t, err, key)
+		assert.Equal(t, int64(10), r.Size())
+	}
+	_, err = aza.Reader("https://devstoreaccount1.blob.core.windows.net/container/missing.txt")
+	assert.Equal(t, syscall.ENOENT, err)
+}
+
+func TestAzureSAS(t *testing.T) {
+	ctx := context.Background()
+	blobs := map[string]string{
+		"container/test.txt": "0123456789",
+	}
+	srv := blobService(t, blobs, func(account string, r *http.Request) bool {
+		return r.URL.Query().Get("sig") == "secret" && r.Header.Get("Authorization") == ""
+	})
+	defer srv.Close()
+
+	az, _ := Handle(ctx, AzureClient(srv.Client()), AzureServiceURL(srv.URL+"/devstoreaccount1"),
+		AzureSASToken("?sv=2020-10-02&sig=secret"))
+	aza, _ := osio.NewAdapter(az)
+	r, err := aza.Reader("az://container/test.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(10), r.Size())
+
+	az, _ = Handle(ctx, AzureClient(srv.Client()), AzureServiceURL(srv.URL+"/devstoreaccount1"),
+		AzureSASToken("sv=2020-10-02&sig=wrong"))
+	aza, _ = osio.NewAdapter(az)
+	_, err = aza.Reader("az://container/test.txt")
+	assert.Contains(t, err.Error(), "403 AuthenticationFailed")
+}
@@ -0,0 +1,285 @@
+// Copyright 2021 Airbus Defence and Space
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package osio
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is matched by the errors returned by a CircuitBreaker while the circuit of
+// the requested host is open
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// CircuitOpenError is the error returned by a CircuitBreaker for the requests it rejects
+type CircuitOpenError struct {
+	// Host is the host or bucket whose circuit is open
+	Host string
+}
+
+func (e *CircuitOpenError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Host, ErrCircuitOpen)
+}
+
+// Is makes errors.Is(err, ErrCircuitOpen) true
+func (e *CircuitOpenError) Is(target error) bool {
+	return target == ErrCircuitOpen
+}
+
+// Temporary returns false, so that rejected requests are not retried by an Adapter
+func (e *CircuitOpenError) Temporary() bool {
+	return false
+}
+
+// CircuitState is the state of the circuit of a host
+type CircuitState int
+
+const (
+	// CircuitClosed lets all requests through
+	CircuitClosed CircuitState = iota
+	// CircuitOpen rejects all requests
+	CircuitOpen
+	// CircuitHalfOpen lets a single probe request through
+	CircuitHalfOpen
+)
+
+func (s CircuitState) String() string {
+	switch s {
+	case CircuitClosed:
+		return "closed"
+	case CircuitOpen:
+		return "open"
+	case CircuitHalfOpen:
+		return "half-open"
+	default:
+		return fmt.Sprintf("CircuitState(%d)", int(s))
+	}
+}
+
+type circuit struct {
+	state    CircuitState
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// CircuitBreaker is a KeyStreamerAt that stops sending requests to a host (or bucket) of
+// another KeyStreamerAt once it has failed FailureThreshold times in a row. While the circuit
+// of a host is open, requests fail immediately with a *CircuitOpenError. After OpenDuration, a
+// single probe request is let through: the circuit is closed if it succeeds, and opened again
+// if it fails.
+//
+// Only the errors returned by the StreamAt calls are considered, not the errors encountered
+// while reading the returned streams.
+type CircuitBreaker struct {
+	ks           KeyStreamerAt
+	threshold    int
+	openDuration time.Duration
+	isFailure    func(error) bool
+	onChange     func(host string, from, to CircuitState)
+	now          func() time.Time
+	mu           sync.Mutex
+	circuits     map[string]*circuit
+}
+
+var (
+	_ KeyVersionStreamerAt = &CircuitBreaker{}
+	_ KeyLister            = &CircuitBreaker{}
+	_ ListingChecker       = &CircuitBreaker{}
+)
+
+// CircuitBreakerOption is an option that can be passed to NewCircuitBreaker
+type CircuitBreakerOption func(cb *CircuitBreaker) error
+
+// FailureThreshold sets the number of consecutive failures after which the circuit of a host
+// is opened. Defaults to 5
+func FailureThreshold(n int) CircuitBreakerOption {
+	return func(cb *CircuitBreaker) error {
+		if n <= 0 {
+			return fmt.Errorf("failure threshold must be > 0")
+		}
+		cb.threshold = n
+		return nil
+	}
+}
+
+// OpenDuration sets the time during which requests are rejected before a probe request is
+// sent. Defaults to 30s
+func OpenDuration(d time.Duration) CircuitBreakerOption {
+	return func(cb *CircuitBreaker) error {
+		if d <= 0 {
+			return fmt.Errorf("open duration must be > 0")
+		}
+		cb.openDuration = d
+		return nil
+	}
+}
+
+// FailureClassifier sets the function telling whether an error returned by the KeyStreamerAt
+// is a failure of the host. Defaults to DefaultRetryable, i.e. non existing objects do not count
+// as failures. Regardless of the classifier, requests whose context deadline expired count as
+// failures, and cancelled requests are ignored.
+func FailureClassifier(isFailure func(error) bool) CircuitBreakerOption {
+	return func(cb *CircuitBreaker) error {
+		if isFailure == nil {
+			return fmt.Errorf("classifier must not be nil")
+		}
+		cb.isFailure = isFailure
+		return nil
+	}
+}
+
+// OnStateChange sets a callback called whenever the circuit of a host changes state. It is
+// called synchronously and must not call the CircuitBreaker.
+func OnStateChange(cb func(host string, from, to CircuitState)) CircuitBreakerOption {
+	return func(b *CircuitBreaker) error {
+		b.onChange = cb
+		return nil
+	}
+}
+
+// NewCircuitBreaker creates a CircuitBreaker around ks
+func NewCircuitBreaker(ks KeyStreamerAt, opts ...CircuitBreakerOption) (*CircuitBreaker, error) {
+	cb := &CircuitBreaker{
+		ks:           ks,
+		threshold:    5,
+		openDuration: 30 * time.Second,
+		isFailure:    DefaultRetryable,
+		now:          time.Now,
+		circuits:     make(map[string]*circuit),
+	}
+	for _, o := range opts {
+		if err := o(cb); err != nil {
+			return nil, err
+		}
+	}
+	return cb, nil
+}
+
+// State returns the state of the circuit of host
+func (cb *CircuitBreaker) State(host string) CircuitState {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if c, ok := cb.circuits[host]; ok {
+		return c.state
+	}
+	return CircuitClosed
+}
+
+// must be called with cb.mu held
+func (cb *CircuitBreaker) setState(host string, c *circuit, state CircuitState) {
+	if c.state == state {
+		return
+	}
+	from := c.state
+	c.state = state
+	if cb.onChange != nil {
+		cb.onChange(host, from, state)
+	}
+}
+
+// allow returns whether a request to host may be sent, and if it is the probe request
+func (cb *CircuitBreaker) allow(host string) (bool, bool) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	c, ok := cb.circuits[host]
+	if !ok {
+		c = &circuit{}
+		cb.circuits[host] = c
+	}
+	switch c.state {
+	case CircuitOpen:
+		if cb.now().Sub(c.openedAt) < cb.openDuration {
+			return false, false
+		}
+		cb.setState(host, c, CircuitHalfOpen)
+		c.probing = true
+		return true, true
+	case CircuitHalfOpen:
+		if c.probing {
+			return false, false
+		}
+		c.probing = true
+		return true, true
+	default:
+		return true, false
+	}
+}
+
+// done records the outcome of a request to host
+func (cb *CircuitBreaker) done(host string, probe bool, err error) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	c := cb.circuits[host]
+	if probe {
+		c.probing = false
+	}
+	if errors.Is(err, context.Canceled) {
+		//inconclusive, and if probing let another request probe
+		return
+	}
+	if err == nil || (!errors.Is(err, context.DeadlineExceeded) && !cb.isFailure(err)) {
+		c.failures = 0
+		cb.setState(host, c, CircuitClosed)
+		return
+	}
+	c.failures++
+	if probe || c.failures >= cb.threshold {
+		c.openedAt = cb.now()
+		cb.setState(host, c, CircuitOpen)
+	}
+}
+
+// StreamAt implements KeyStreamerAt
+func (cb *CircuitBreaker) StreamAt(key string, off int64, n int64) (io.ReadCloser, int64, error) {
+	return cb.StreamAtContext(context.Background(), key, off, n)
+}
+
+// StreamAtContext implements KeyStreamerAtContext
+func (cb *CircuitBreaker) StreamAtContext(ctx context.Context, key string, off int64, n int64) (io.ReadCloser, int64, error) {
+	r, size, _, err := cb.StreamAtVersion(ctx, key, off, n, "")
+	return r, size, err
+}
+
+// StreamAtVersion implements KeyVersionStreamerAt
+func (cb *CircuitBreaker) StreamAtVersion(ctx context.Context, key string, off int64, n int64, version string) (io.ReadCloser, int64, string, error) {
+	host := keyHost(key)
+	ok, probe := cb.allow(host)
+	if !ok {
+		return nil, 0, "", &CircuitOpenError{Host: host}
+	}
+	r, size, v, err := StreamAtVersion(ctx, cb.ks, key, off, n, version)
+	cb.done(host, probe, err)
+	return r, size, v, err
+}
+
+// ListObjects implements KeyLister. Listings fail immediately with a *CircuitOpenError unless the
+// circuit of the host of prefix is closed. Their outcome does not change the state of the circuit.
+func (cb *CircuitBreaker) ListObjects(ctx context.Context, prefix, delimiter string) ObjectIterator {
+	host := keyHost(prefix)
+	if cb.State(host) != CircuitClosed {
+		return ErrorIterator(&CircuitOpenError{Host: host})
+	}
+	return ListObjects(ctx, cb.ks, prefix, delimiter)
+}
+
+// CanList implements ListingChecker
+func (cb *CircuitBreaker) CanList(prefix string) bool {
+	return CanList(cb.ks, prefix)
+}
@@ -0,0 +1,175 @@
+// Copyright 2021 Airbus Defence and Space
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package osio
+
+import (
+	"context"
+	"errors"
+	"io"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type failingStreamer struct {
+	err   error
+	calls int
+}
+
+func (f *failingStreamer) StreamAt(key string, off int64, n int64) (io.ReadCloser, int64, error) {
+	f.calls++
+	if f.err != nil {
+		return nil, 0, f.err
+	}
+	return eofReader{}, 10, nil
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	_, err := NewCircuitBreaker(rr, FailureThreshold(0))
+	assert.Error(t, err)
+	_, err = NewCircuitBreaker(rr, OpenDuration(0))
+	assert.Error(t, err)
+	_, err = NewCircuitBreaker(rr, FailureClassifier(nil))
+	assert.Error(t, err)
+
+	src := &failingStreamer{err: tempErr{}}
+	type change struct {
+		host     string
+		from, to CircuitState
+	}
+	changes := []change{}
+	now := time.Now()
+	cb, _ := NewCircuitBreaker(src, FailureThreshold(3), OpenDuration(time.Minute),
+		OnStateChange(func(host string, from, to CircuitState) {
+			changes = append(changes, change{host, from, to})
+		}))
+	cb.now = func() time.Time { return now }
+
+	//non failures do not open the circuit
+	src.err = syscall.ENOENT
+	for i := 0; i < 5; i++ {
+		_, _, err = cb.StreamAt("gs://bucket/a", 0, 10)
+		assert.ErrorIs(t, err, syscall.ENOENT)
+	}
+	assert.Equal(t, CircuitClosed, cb.State("bucket"))
+
+	src.err = tempErr{}
+	for i := 0; i < 3; i++ {
+		_, _, err = cb.StreamAt("gs://bucket/a", 0, 10)
+		assert.Equal(t, tempErr{}, err)
+	}
+	assert.Equal(t, CircuitOpen, cb.State("bucket"))
+	assert.Equal(t, []change{{"bucket", CircuitClosed, CircuitOpen}}, changes)
+
+	//fail fast while open, other hosts unaffected
+	calls := src.calls
+	_, _, err = cb.StreamAt("gs://bucket/b", 0, 10)
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+	var coe *CircuitOpenError
+	assert.True(t, errors.As(err, &coe))
+	assert.Equal(t, "bucket", coe.Host)
+	assert.False(t, DefaultRetryable(err))
+	assert.Equal(t, calls, src.calls)
+	_, _, err = cb.StreamAt("gs://other/b", 0, 10)
+	assert.Equal(t, tempErr{}, err)
+	assert.Equal(t, CircuitClosed, cb.State("other"))
+
+	//failed probe reopens the circuit
+	now = now.Add(time.Minute)
+	_, _, err = cb.StreamAt("gs://bucket/a", 0, 10)
+	assert.Equal(t, tempErr{}, err)
+	assert.Equal(t, CircuitOpen, cb.State("bucket"))
+	_, _, err = cb.StreamAt("gs://bucket/a", 0, 10)
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+
+	//only a single probe is let through
+	now = now.Add(time.Minute)
+	ok, probe := cb.allow("bucket")
+	assert.True(t, ok)
+	assert.True(t, probe)
+	assert.Equal(t, CircuitHalfOpen, cb.State("bucket"))
+	_, _, err = cb.StreamAt("gs://bucket/a", 0, 10)
+	assert.ErrorIs(t, err, ErrCircuitOpen)
+	cb.done("bucket", true, nil)
+	assert.Equal(t, CircuitClosed, cb.State("bucket"))
+
+	src.err = nil
+	r, _, err := cb.StreamAt("gs://bucket/a", 0, 10)
+	assert.NoError(t, err)
+	_ = r.Close()
+
+	assert.Equal(t, []change{
+		{"bucket", CircuitClosed, CircuitOpen},
+		{"bucket", CircuitOpen, CircuitHalfOpen},
+		{"bucket", CircuitHalfOpen, CircuitOpen},
+		{"bucket", CircuitOpen, CircuitHalfOpen},
+		{"bucket", CircuitHalfOpen, CircuitClosed},
+	}, changes)
+	assert.Equal(t, "half-open", CircuitHalfOpen.String())
+}
+
+// hangingStreamer never answers before the context of the request is done
+type hangingStreamer struct{}
+
+func (hangingStreamer) StreamAt(key string, off int64, n int64) (io.ReadCloser, int64, error) {
+	return hangingStreamer{}.StreamAtContext(context.Background(), key, off, n)
+}
+
+func (hangingStreamer) StreamAtContext(ctx context.Context, key string, off int64, n int64) (io.ReadCloser, int64, error) {
+	<-ctx.Done()
+	return nil, 0, ctx.Err()
+}
+
+func TestCircuitBreakerTimeouts(t *testing.T) {
+	now := time.Now()
+	cb, _ := NewCircuitBreaker(hangingStreamer{}, FailureThreshold(2), OpenDuration(time.Minute))
+	cb.now = func() time.Time { return now }
+	timeout := func() error {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		_, _, err := cb.StreamAtContext(ctx, "gs://bucket/a", 0, 10)
+		return err
+	}
+	cancelled := func() error {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, _, err := cb.StreamAtContext(ctx, "gs://bucket/a", 0, 10)
+		return err
+	}
+
+	//cancelled requests leave the failure count untouched
+	assert.ErrorIs(t, timeout(), context.DeadlineExceeded)
+	assert.ErrorIs(t, cancelled(), context.Canceled)
+	assert.Equal(t, 1, cb.circuits["bucket"].failures)
+	assert.Equal(t, CircuitClosed, cb.State("bucket"))
+
+	//a hanging host opens the circuit
+	assert.ErrorIs(t, timeout(), context.DeadlineExceeded)
+	assert.Equal(t, CircuitOpen, cb.State("bucket"))
+	assert.ErrorIs(t, timeout(), ErrCircuitOpen)
+
+	//a cancelled probe is inconclusive
+	now = now.Add(time.Minute)
+	assert.ErrorIs(t, cancelled(), context.Canceled)
+	assert.Equal(t, CircuitHalfOpen, cb.State("bucket"))
+	assert.False(t, cb.circuits["bucket"].probing)
+
+	//a timed out probe reopens the circuit
+	assert.ErrorIs(t, timeout(), context.DeadlineExceeded)
+	assert.Equal(t, CircuitOpen, cb.State("bucket"))
+	assert.ErrorIs(t, timeout(), ErrCircuitOpen)
+}
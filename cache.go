@@ -15,9 +15,11 @@
 package osio
 
 import (
+	"container/list"
 	"fmt"
 	"math/rand"
 	"strings"
+	"sync"
 	"time"
 
 	lru "github.com/hashicorp/golang-lru"
@@ -74,3 +76,111 @@ func (cg *LRUCache) Purge() {
 func skey(key string, random string, id uint) string {
 	return fmt.Sprintf("%s-%s-%d", key, random, id)
 }
+
+type blockID struct {
+	key string
+	id  uint
+}
+
+type sizedBlock struct {
+	blockID
+	data []byte
+}
+
+// ByteLRUCache is a BlockCacher whose capacity is expressed as a maximum number of bytes
+// instead of a number of blocks. The memory used by each block is accounted for by its actual
+// length, and least recently used blocks are evicted until the cache fits in its budget.
+type ByteLRUCache struct {
+	mu       sync.Mutex
+	maxBytes int64
+	used     int64
+	ll       *list.List
+	items    map[blockID]*list.Element
+}
+
+var _ BlockCacher = &ByteLRUCache{}
+
+// NewByteLRUCache creates a ByteLRUCache holding up to maxBytes bytes of block data
+func NewByteLRUCache(maxBytes int64) (*ByteLRUCache, error) {
+	if maxBytes <= 0 {
+		return nil, fmt.Errorf("maxBytes must be > 0")
+	}
+	return &ByteLRUCache{
+		maxBytes: maxBytes,
+		ll:       list.New(),
+		items:    make(map[blockID]*list.Element),
+	}, nil
+}
+
+// Add inserts data in the cache, evicting the least recently used blocks if needed. Blocks
+// larger than the cache capacity are not cached.
+func (c *ByteLRUCache) Add(key string, id uint, data []byte) {
+	size := int64(len(data))
+	bid := blockID{key, id}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[bid]; ok {
+		c.remove(el)
+	}
+	if size > c.maxBytes {
+		return
+	}
+	c.items[bid] = c.ll.PushFront(&sizedBlock{blockID: bid, data: data})
+	c.used += size
+	for c.used > c.maxBytes {
+		c.remove(c.ll.Back())
+	}
+}
+
+// Get returns the cached data for the given key and block, and marks it as recently used
+func (c *ByteLRUCache) Get(key string, id uint) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.items[blockID{key, id}]
+	if !ok {
+		return nil, false
+	}
+	c.ll.MoveToFront(el)
+	return el.Value.(*sizedBlock).data, true
+}
+
+// must be called with c.mu held
+func (c *ByteLRUCache) remove(el *list.Element) {
+	sb := c.ll.Remove(el).(*sizedBlock)
+	delete(c.items, sb.blockID)
+	c.used -= int64(len(sb.data))
+}
+
+// PurgeKey removes all the cached blocks of the given key
+func (c *ByteLRUCache) PurgeKey(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for bid, el := range c.items {
+		if bid.key == key {
+			c.remove(el)
+		}
+	}
+}
+
+// Purge empties the cache
+func (c *ByteLRUCache) Purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ll.Init()
+	c.items = make(map[blockID]*list.Element)
+	c.used = 0
+}
+
+// Usage returns the number of bytes currently held by the cache
+func (c *ByteLRUCache) Usage() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.used
+}
+
+// Len returns the number of blocks currently held by the cache
+func (c *ByteLRUCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ll.Len()
+}
@@ -77,3 +77,55 @@ func TestCache(t *testing.T) {
 		t.Error("foobar 1 purged")
 	}
 }
+
+func TestByteLRUCache(t *testing.T) {
+	_, err := NewByteLRUCache(0)
+	assert.Error(t, err)
+	cache, _ := NewByteLRUCache(10)
+	cache.Add("foo", 0, make([]byte, 4))
+	cache.Add("foo", 1, make([]byte, 4))
+	assert.Equal(t, int64(8), cache.Usage())
+	assert.Equal(t, 2, cache.Len())
+
+	//block 0 becomes most recently used, block 1 gets evicted
+	_, ok := cache.Get("foo", 0)
+	assert.True(t, ok)
+	cache.Add("foo", 2, make([]byte, 4))
+	assert.Equal(t, int64(8), cache.Usage())
+	_, ok = cache.Get("foo", 1)
+	assert.False(t, ok)
+	_, ok = cache.Get("foo", 0)
+	assert.True(t, ok)
+
+	//small blocks only account for their length
+	cache.Add("foo", 3, bytea(3))
+	cache.Add("foo", 4, bytea(4))
+	assert.Equal(t, int64(10), cache.Usage())
+	assert.Equal(t, 4, cache.Len())
+
+	//replacing a block
+	cache.Add("foo", 3, make([]byte, 2))
+	assert.Equal(t, int64(7), cache.Usage())
+	b, _ := cache.Get("foo", 3)
+	assert.Len(t, b, 2)
+	assert.Equal(t, 3, cache.Len()) //block 2 evicted
+
+	//blocks larger than the capacity are not cached
+	cache.Add("foo", 5, make([]byte, 11))
+	_, ok = cache.Get("foo", 5)
+	assert.False(t, ok)
+	assert.LessOrEqual(t, cache.Usage(), int64(10))
+
+	cache.Add("foobar", 0, []byte("bar"))
+	cache.PurgeKey("foo")
+	for i := 0; i < 5; i++ {
+		_, ok := cache.Get("foo", uint(i))
+		assert.False(t, ok)
+	}
+	bar, _ := cache.Get("foobar", 0)
+	assert.Equal(t, []byte("bar"), bar)
+	assert.Equal(t, int64(3), cache.Usage())
+	cache.Purge()
+	assert.Equal(t, int64(0), cache.Usage())
+	assert.Equal(t, 0, cache.Len())
+}
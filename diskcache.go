@@ -0,0 +1,274 @@
+// Copyright 2021 Airbus Defence and Space
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package osio
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const diskCacheTmpPrefix = ".tmp-"
+
+type diskBlock struct {
+	dir string
+	id  uint
+}
+
+type diskEntry struct {
+	diskBlock
+	size int64
+}
+
+// DiskCache is a BlockCacher that persists blocks as individual files under a local
+// directory, so that they survive a restart of the process. The total size of the
+// stored blocks is bounded, and least recently used blocks are evicted first.
+//
+// Blocks are written and synced to a temporary file which is then renamed, so that a crash never
+// leaves a partially written block behind. Errors encountered while writing or reading
+// blocks are not reported, and result in cache misses.
+//
+// As blocks are identified by their index, a cache directory must only be shared by
+// Adapters using the same BlockSize.
+type DiskCache struct {
+	mu       sync.Mutex
+	root     string
+	maxBytes int64
+	used     int64
+	ll       *list.List
+	items    map[diskBlock]*list.Element
+}
+
+var _ BlockCacher = &DiskCache{}
+
+// NewDiskCache creates a DiskCache storing up to maxBytes bytes of block data under the
+// dir directory, which is created if needed. Blocks that were stored in dir by a previous
+// DiskCache are reused.
+func NewDiskCache(dir string, maxBytes int64) (*DiskCache, error) {
+	if maxBytes <= 0 {
+		return nil, fmt.Errorf("maxBytes must be > 0")
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create cache directory: %w", err)
+	}
+	dc := &DiskCache{
+		root:     dir,
+		maxBytes: maxBytes,
+		ll:       list.New(),
+		items:    make(map[diskBlock]*list.Element),
+	}
+	if err := dc.load(); err != nil {
+		return nil, err
+	}
+	return dc, nil
+}
+
+// load populates the index from the blocks present on disk, ordered by their last
+// access time
+func (dc *DiskCache) load() error {
+	type loaded struct {
+		diskEntry
+		atime time.Time
+	}
+	entries := []loaded{}
+	dirs, err := ioutil.ReadDir(dc.root)
+	if err != nil {
+		return fmt.Errorf("read cache directory: %w", err)
+	}
+	for _, d := range dirs {
+		if !d.IsDir() || len(d.Name()) != 2*sha256.Size {
+			continue
+		}
+		files, err := ioutil.ReadDir(filepath.Join(dc.root, d.Name()))
+		if err != nil {
+			return fmt.Errorf("read cache directory: %w", err)
+		}
+		for _, f := range files {
+			if strings.HasPrefix(f.Name(), diskCacheTmpPrefix) {
+				//leftover from an interrupted write
+				_ = os.Remove(filepath.Join(dc.root, d.Name(), f.Name()))
+				continue
+			}
+			id, err := strconv.ParseUint(f.Name(), 10, 64)
+			if err != nil || f.IsDir() {
+				continue
+			}
+			entries = append(entries, loaded{
+				diskEntry: diskEntry{diskBlock: diskBlock{dir: d.Name(), id: uint(id)}, size: f.Size()},
+				atime:     f.ModTime(),
+			})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].atime.After(entries[j].atime)
+	})
+	for i := range entries {
+		e := entries[i].diskEntry
+		dc.items[e.diskBlock] = dc.ll.PushBack(&e)
+		dc.used += e.size
+	}
+	for dc.used > dc.maxBytes {
+		dc.remove(dc.ll.Back())
+	}
+	return nil
+}
+
+func keyDir(key string) string {
+	h := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(h[:])
+}
+
+func (dc *DiskCache) path(b diskBlock) string {
+	return filepath.Join(dc.root, b.dir, strconv.FormatUint(uint64(b.id), 10))
+}
+
+// Add stores data on disk, evicting the least recently used blocks if needed. Blocks
+// larger than the cache capacity are not cached.
+func (dc *DiskCache) Add(key string, id uint, data []byte) {
+	size := int64(len(data))
+	if size > dc.maxBytes {
+		return
+	}
+	b := diskBlock{dir: keyDir(key), id: id}
+	dir := filepath.Join(dc.root, b.dir)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return
+	}
+	tmp, err := ioutil.TempFile(dir, diskCacheTmpPrefix)
+	if err != nil {
+		return
+	}
+	_, err = tmp.Write(data)
+	if err == nil {
+		//make sure the data is on disk before the block becomes visible
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return
+	}
+
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	if err := os.Rename(tmp.Name(), dc.path(b)); err != nil {
+		_ = os.Remove(tmp.Name())
+		return
+	}
+	if el, ok := dc.items[b]; ok {
+		dc.used -= el.Value.(*diskEntry).size
+		el.Value.(*diskEntry).size = size
+		dc.ll.MoveToFront(el)
+	} else {
+		dc.items[b] = dc.ll.PushFront(&diskEntry{diskBlock: b, size: size})
+	}
+	dc.used += size
+	for dc.used > dc.maxBytes {
+		dc.remove(dc.ll.Back())
+	}
+}
+
+// Get reads the block from disk, and marks it as recently used. Blocks whose file cannot
+// be read or does not have the expected length are removed.
+func (dc *DiskCache) Get(key string, id uint) ([]byte, bool) {
+	b := diskBlock{dir: keyDir(key), id: id}
+	dc.mu.Lock()
+	el, ok := dc.items[b]
+	var size int64
+	if ok {
+		dc.ll.MoveToFront(el)
+		size = el.Value.(*diskEntry).size
+	}
+	dc.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+	path := dc.path(b)
+	data, err := ioutil.ReadFile(path)
+	if err != nil || int64(len(data)) != size {
+		dc.mu.Lock()
+		//the block may have been rewritten in the meantime
+		if el, ok := dc.items[b]; ok && (err != nil || el.Value.(*diskEntry).size == size) {
+			dc.remove(el)
+		}
+		dc.mu.Unlock()
+		return nil, false
+	}
+	//persist the access time so that the lru order survives restarts
+	now := time.Now()
+	_ = os.Chtimes(path, now, now)
+	return data, true
+}
+
+// must be called with dc.mu held
+func (dc *DiskCache) remove(el *list.Element) {
+	e := dc.ll.Remove(el).(*diskEntry)
+	delete(dc.items, e.diskBlock)
+	dc.used -= e.size
+	_ = os.Remove(dc.path(e.diskBlock))
+}
+
+// PurgeKey removes all the cached blocks of the given key
+func (dc *DiskCache) PurgeKey(key string) {
+	dir := keyDir(key)
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	for b, el := range dc.items {
+		if b.dir == dir {
+			dc.remove(el)
+		}
+	}
+	_ = os.RemoveAll(filepath.Join(dc.root, dir))
+}
+
+// Purge removes all the cached blocks
+func (dc *DiskCache) Purge() {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	for _, el := range dc.items {
+		dc.remove(el)
+	}
+	dirs, _ := ioutil.ReadDir(dc.root)
+	for _, d := range dirs {
+		if d.IsDir() && len(d.Name()) == 2*sha256.Size {
+			_ = os.RemoveAll(filepath.Join(dc.root, d.Name()))
+		}
+	}
+}
+
+// Usage returns the number of bytes of block data currently stored on disk
+func (dc *DiskCache) Usage() int64 {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	return dc.used
+}
+
+// Len returns the number of blocks currently stored on disk
+func (dc *DiskCache) Len() int {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+	return dc.ll.Len()
+}
@@ -0,0 +1,152 @@
+// Copyright 2021 Airbus Defence and Space
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package osio
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiskCache(t *testing.T) {
+	dir := t.TempDir()
+	_, err := NewDiskCache(dir, 0)
+	assert.Error(t, err)
+	_, err = NewDiskCache("/dev/null/foo", 10)
+	assert.Error(t, err)
+
+	dc, err := NewDiskCache(filepath.Join(dir, "sub"), 10)
+	assert.NoError(t, err)
+	_, ok := dc.Get("foo", 0)
+	assert.False(t, ok)
+
+	dc.Add("foo", 0, []byte("0000"))
+	dc.Add("foo", 1, []byte("1111"))
+	dc.Add("foo", 2, []byte{})
+	assert.Equal(t, int64(8), dc.Usage())
+	assert.Equal(t, 3, dc.Len())
+	b, ok := dc.Get("foo", 0)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("0000"), b)
+	b, ok = dc.Get("foo", 2)
+	assert.True(t, ok)
+	assert.Len(t, b, 0)
+
+	//block 1 is the least recently used
+	dc.Add("bar", 0, []byte("bar0"))
+	_, ok = dc.Get("foo", 1)
+	assert.False(t, ok)
+	assert.Equal(t, int64(8), dc.Usage())
+
+	//too large
+	dc.Add("foo", 3, make([]byte, 11))
+	_, ok = dc.Get("foo", 3)
+	assert.False(t, ok)
+
+	//replace
+	dc.Add("bar", 0, []byte("bar"))
+	b, _ = dc.Get("bar", 0)
+	assert.Equal(t, []byte("bar"), b)
+	assert.Equal(t, int64(7), dc.Usage())
+
+	//block removed behind our back
+	assert.NoError(t, os.Remove(dc.path(diskBlock{keyDir("foo"), 2})))
+	_, ok = dc.Get("foo", 2)
+	assert.False(t, ok)
+	assert.Equal(t, 2, dc.Len())
+
+	//truncated block is purged
+	assert.NoError(t, os.Truncate(dc.path(diskBlock{keyDir("foo"), 0}), 2))
+	_, ok = dc.Get("foo", 0)
+	assert.False(t, ok)
+	assert.Equal(t, 1, dc.Len())
+	assert.Equal(t, int64(3), dc.Usage())
+	_, err = os.Stat(dc.path(diskBlock{keyDir("foo"), 0}))
+	assert.True(t, os.IsNotExist(err))
+	dc.Add("foo", 0, []byte("0000"))
+
+	dc.PurgeKey("foo")
+	_, ok = dc.Get("foo", 0)
+	assert.False(t, ok)
+	_, ok = dc.Get("bar", 0)
+	assert.True(t, ok)
+	assert.Equal(t, int64(3), dc.Usage())
+
+	dc.Purge()
+	assert.Equal(t, int64(0), dc.Usage())
+	_, ok = dc.Get("bar", 0)
+	assert.False(t, ok)
+}
+
+func TestDiskCacheRestart(t *testing.T) {
+	dir := t.TempDir()
+	dc, _ := NewDiskCache(dir, 100)
+	dc.Add("foo", 0, []byte("0000"))
+	dc.Add("foo", 1, []byte("1111"))
+	dc.Add("bar", 0, []byte("bar0"))
+	//make sure access times differ
+	old := time.Now().Add(-time.Hour)
+	_ = os.Chtimes(dc.path(diskBlock{keyDir("foo"), 1}), old, old)
+	_ = os.Chtimes(dc.path(diskBlock{keyDir("foo"), 0}), old.Add(time.Minute), old.Add(time.Minute))
+
+	//interrupted write
+	tmp := filepath.Join(dir, keyDir("foo"), diskCacheTmpPrefix+"123")
+	assert.NoError(t, ioutil.WriteFile(tmp, []byte("00"), 0o644))
+	//unrelated files
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "README"), []byte("00"), 0o644))
+
+	dc, err := NewDiskCache(dir, 100)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(12), dc.Usage())
+	b, ok := dc.Get("foo", 1)
+	assert.True(t, ok)
+	assert.Equal(t, []byte("1111"), b)
+	_, err = os.Stat(tmp)
+	assert.True(t, os.IsNotExist(err))
+
+	//reopening with a smaller capacity evicts the least recently used blocks (foo/0)
+	dc, _ = NewDiskCache(dir, 8)
+	assert.Equal(t, int64(8), dc.Usage())
+	_, ok = dc.Get("foo", 0)
+	assert.False(t, ok)
+	_, ok = dc.Get("foo", 1)
+	assert.True(t, ok)
+	_, ok = dc.Get("bar", 0)
+	assert.True(t, ok)
+
+	dc.Purge()
+	_, err = os.Stat(filepath.Join(dir, "README"))
+	assert.NoError(t, err)
+}
+
+func TestDiskCacheAdapter(t *testing.T) {
+	dir := t.TempDir()
+	dc, _ := NewDiskCache(dir, 1<<20)
+	ll := &logger{}
+	bc, _ := NewAdapter(rr, BlockSize("16"), BlockCache(dc), WithLogger(ll))
+	buf := make([]byte, 8)
+	test(t, bc, buf, 16, 8, []byte{4, 4, 4, 4, 5, 5, 5, 5}, nil)
+
+	//new process
+	dc, _ = NewDiskCache(dir, 1<<20)
+	ll.last = ""
+	bc, _ = NewAdapter(rr, BlockSize("16"), BlockCache(dc), WithLogger(ll))
+	test(t, bc, buf, 20, 8, []byte{5, 5, 5, 5, 6, 6, 6, 6}, nil)
+	assert.Equal(t, "", ll.last)
+}
@@ -0,0 +1,343 @@
+// Copyright 2021 Airbus Defence and Space
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package osio
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// FaultKind is a kind of fault injected by a FaultInjector
+type FaultKind int
+
+const (
+	// FaultNone does not alter the request
+	FaultNone FaultKind = iota
+	// FaultTemporaryError fails the request with a temporary error before streaming
+	FaultTemporaryError
+	// FaultPermanentError fails the request with a non temporary error before streaming
+	FaultPermanentError
+	// FaultTruncate ends the body with io.ErrUnexpectedEOF after Fault.Bytes bytes
+	FaultTruncate
+	// FaultCorrupt flips the bits of the byte at position Fault.Bytes of the body
+	FaultCorrupt
+	// FaultShortReads makes each Read of the body return at most Fault.Bytes bytes (1 by default)
+	FaultShortReads
+	// FaultDelay delays the first byte of the body by Fault.Delay
+	FaultDelay
+	// FaultWrongSize adds Fault.Bytes (1 by default) to the object size returned for offset 0
+	FaultWrongSize
+)
+
+func (k FaultKind) String() string {
+	switch k {
+	case FaultNone:
+		return "none"
+	case FaultTemporaryError:
+		return "temporary error"
+	case FaultPermanentError:
+		return "permanent error"
+	case FaultTruncate:
+		return "truncate"
+	case FaultCorrupt:
+		return "corrupt"
+	case FaultShortReads:
+		return "short reads"
+	case FaultDelay:
+		return "delay"
+	case FaultWrongSize:
+		return "wrong size"
+	default:
+		return fmt.Sprintf("FaultKind(%d)", int(k))
+	}
+}
+
+// Fault describes a fault to inject in a request
+type Fault struct {
+	Kind FaultKind
+	// Bytes is the position in the body of a FaultTruncate or FaultCorrupt, the maximum
+	// size of the reads of a FaultShortReads, or the size error of a FaultWrongSize
+	Bytes int64
+	// Delay is the delay of a FaultDelay
+	Delay time.Duration
+	// Err replaces the error returned by a FaultTemporaryError or FaultPermanentError
+	Err error
+}
+
+// FaultRates are the probabilities of each kind of fault being injected in a request. Their
+// sum must not exceed 1.
+type FaultRates struct {
+	TemporaryError float64
+	PermanentError float64
+	Truncate       float64
+	Corrupt        float64
+	ShortReads     float64
+	Delay          float64
+	WrongSize      float64
+	// MaxDelay is the maximum delay of the FaultDelay faults. Defaults to 100ms
+	MaxDelay time.Duration
+}
+
+// InjectedError is the error returned by the FaultTemporaryError and FaultPermanentError faults
+type InjectedError struct {
+	Key       string
+	Offset    int64
+	temporary bool
+}
+
+func (e *InjectedError) Error() string {
+	kind := "permanent"
+	if e.temporary {
+		kind = "temporary"
+	}
+	return fmt.Sprintf("injected %s error for %s at offset %d", kind, e.Key, e.Offset)
+}
+
+// Temporary tells whether the error is retried by an Adapter
+func (e *InjectedError) Temporary() bool {
+	return e.temporary
+}
+
+// FaultInjector is a KeyStreamerAt wrapper that injects faults in the requests sent to another
+// KeyStreamerAt, in order to test the behavior of an Adapter and of its callers on unreliable
+// networks. Faults are either drawn at random with a seeded generator, or scripted.
+type FaultInjector struct {
+	ks     KeyStreamerAt
+	mu     sync.Mutex
+	rng    *rand.Rand
+	rates  FaultRates
+	script []Fault
+	fn     func(key string, off, n int64) Fault
+	counts map[FaultKind]int
+}
+
+var (
+	_ KeyVersionStreamerAt = &FaultInjector{}
+	_ KeyLister            = &FaultInjector{}
+	_ ListingChecker       = &FaultInjector{}
+)
+
+// FaultOption is an option that can be passed to NewFaultInjector
+type FaultOption func(fi *FaultInjector) error
+
+// RandomFaults injects faults at random, with the given rates, using a generator seeded with seed
+func RandomFaults(seed int64, rates FaultRates) FaultOption {
+	return func(fi *FaultInjector) error {
+		sum := 0.0
+		for _, rate := range []float64{rates.TemporaryError, rates.PermanentError, rates.Truncate,
+			rates.Corrupt, rates.ShortReads, rates.Delay, rates.WrongSize} {
+			if rate < 0 {
+				return fmt.Errorf("fault rates must not be negative")
+			}
+			sum += rate
+		}
+		if sum > 1 {
+			return fmt.Errorf("sum of fault rates must not exceed 1")
+		}
+		if rates.MaxDelay < 0 {
+			return fmt.Errorf("maximum delay must not be negative")
+		}
+		if rates.MaxDelay == 0 {
+			rates.MaxDelay = 100 * time.Millisecond
+		}
+		fi.rng = rand.New(rand.NewSource(seed))
+		fi.rates = rates
+		return nil
+	}
+}
+
+// FaultScript injects the given faults in the successive requests, in order. Once the script is
+// exhausted, the requests are handled by the RandomFaults or FaultFunc options if any, or are
+// left untouched.
+func FaultScript(faults ...Fault) FaultOption {
+	return func(fi *FaultInjector) error {
+		fi.script = append(fi.script, faults...)
+		return nil
+	}
+}
+
+// FaultFunc calls fn to choose the fault to inject in each request
+func FaultFunc(fn func(key string, off, n int64) Fault) FaultOption {
+	return func(fi *FaultInjector) error {
+		fi.fn = fn
+		return nil
+	}
+}
+
+// NewFaultInjector creates a FaultInjector around ks
+func NewFaultInjector(ks KeyStreamerAt, opts ...FaultOption) (*FaultInjector, error) {
+	fi := &FaultInjector{
+		ks:     ks,
+		counts: make(map[FaultKind]int),
+	}
+	for _, o := range opts {
+		if err := o(fi); err != nil {
+			return nil, err
+		}
+	}
+	return fi, nil
+}
+
+// Injected returns the number of faults of the given kind that have been injected
+func (fi *FaultInjector) Injected(kind FaultKind) int {
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+	return fi.counts[kind]
+}
+
+func (fi *FaultInjector) random() Fault {
+	r := fi.rates
+	p := fi.rng.Float64()
+	for _, c := range []struct {
+		rate float64
+		kind FaultKind
+	}{
+		{r.TemporaryError, FaultTemporaryError},
+		{r.PermanentError, FaultPermanentError},
+		{r.Truncate, FaultTruncate},
+		{r.Corrupt, FaultCorrupt},
+		{r.ShortReads, FaultShortReads},
+		{r.Delay, FaultDelay},
+		{r.WrongSize, FaultWrongSize},
+	} {
+		if p < c.rate {
+			f := Fault{Kind: c.kind}
+			switch c.kind {
+			case FaultTruncate, FaultCorrupt:
+				f.Bytes = fi.rng.Int63n(1024)
+			case FaultShortReads:
+				f.Bytes = 1 + fi.rng.Int63n(16)
+			case FaultDelay:
+				f.Delay = time.Duration(fi.rng.Int63n(int64(r.MaxDelay)))
+			}
+			return f
+		}
+		p -= c.rate
+	}
+	return Fault{}
+}
+
+// fault returns the fault to inject in a request
+func (fi *FaultInjector) fault(key string, off, n int64) Fault {
+	fi.mu.Lock()
+	defer fi.mu.Unlock()
+	var f Fault
+	switch {
+	case len(fi.script) > 0:
+		f = fi.script[0]
+		fi.script = fi.script[1:]
+	case fi.fn != nil:
+		f = fi.fn(key, off, n)
+	case fi.rng != nil:
+		f = fi.random()
+	}
+	if f.Kind == FaultWrongSize && off != 0 {
+		f.Kind = FaultNone
+	}
+	fi.counts[f.Kind]++
+	return f
+}
+
+// StreamAt implements KeyStreamerAt
+func (fi *FaultInjector) StreamAt(key string, off int64, n int64) (io.ReadCloser, int64, error) {
+	return fi.StreamAtContext(context.Background(), key, off, n)
+}
+
+// StreamAtContext implements KeyStreamerAtContext
+func (fi *FaultInjector) StreamAtContext(ctx context.Context, key string, off int64, n int64) (io.ReadCloser, int64, error) {
+	r, size, _, err := fi.StreamAtVersion(ctx, key, off, n, "")
+	return r, size, err
+}
+
+// StreamAtVersion implements KeyVersionStreamerAt
+func (fi *FaultInjector) StreamAtVersion(ctx context.Context, key string, off int64, n int64, version string) (io.ReadCloser, int64, string, error) {
+	f := fi.fault(key, off, n)
+	switch f.Kind {
+	case FaultTemporaryError, FaultPermanentError:
+		if f.Err != nil {
+			return nil, 0, "", f.Err
+		}
+		return nil, 0, "", &InjectedError{Key: key, Offset: off, temporary: f.Kind == FaultTemporaryError}
+	}
+	r, size, v, err := StreamAtVersion(ctx, fi.ks, key, off, n, version)
+	if f.Kind == FaultWrongSize && size > 0 {
+		if f.Bytes == 0 {
+			f.Bytes = 1
+		}
+		size += f.Bytes
+	}
+	if r == nil || f.Kind == FaultNone || f.Kind == FaultWrongSize {
+		return r, size, v, err
+	}
+	if f.Kind == FaultShortReads && f.Bytes <= 0 {
+		f.Bytes = 1
+	}
+	return &faultyReader{ReadCloser: r, ctx: ctx, fault: f}, size, v, err
+}
+
+// ListObjects implements KeyLister. Listings are forwarded without injecting faults.
+func (fi *FaultInjector) ListObjects(ctx context.Context, prefix, delimiter string) ObjectIterator {
+	return ListObjects(ctx, fi.ks, prefix, delimiter)
+}
+
+// CanList implements ListingChecker
+func (fi *FaultInjector) CanList(prefix string) bool {
+	return CanList(fi.ks, prefix)
+}
+
+type faultyReader struct {
+	io.ReadCloser
+	ctx   context.Context
+	fault Fault
+	pos   int64
+}
+
+func (r *faultyReader) Read(buf []byte) (int, error) {
+	f := r.fault
+	switch f.Kind {
+	case FaultDelay:
+		if r.pos == 0 && f.Delay > 0 {
+			t := time.NewTimer(f.Delay)
+			select {
+			case <-r.ctx.Done():
+				t.Stop()
+				return 0, r.ctx.Err()
+			case <-t.C:
+			}
+			r.fault.Delay = 0
+		}
+	case FaultTruncate:
+		if r.pos >= f.Bytes {
+			return 0, io.ErrUnexpectedEOF
+		}
+		if int64(len(buf)) > f.Bytes-r.pos {
+			buf = buf[:f.Bytes-r.pos]
+		}
+	case FaultShortReads:
+		if int64(len(buf)) > f.Bytes {
+			buf = buf[:f.Bytes]
+		}
+	}
+	n, err := r.ReadCloser.Read(buf)
+	if f.Kind == FaultCorrupt && f.Bytes >= r.pos && f.Bytes < r.pos+int64(n) {
+		buf[f.Bytes-r.pos] ^= 0xff
+	}
+	r.pos += int64(n)
+	return n, err
+}
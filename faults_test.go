@@ -0,0 +1,214 @@
+// Copyright 2021 Airbus Defence and Space
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package osio
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFaultInjector(t *testing.T) {
+	_, err := NewFaultInjector(rr, RandomFaults(1, FaultRates{TemporaryError: 0.6, Truncate: 0.6}))
+	assert.Error(t, err)
+	_, err = NewFaultInjector(rr, RandomFaults(1, FaultRates{TemporaryError: 1.5, Truncate: -0.6}))
+	assert.Error(t, err)
+	_, err = NewFaultInjector(rr, RandomFaults(1, FaultRates{Delay: 0.5, MaxDelay: -time.Second}))
+	assert.Error(t, err)
+
+	data := make([]byte, 1000)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	src := TReader{data}
+	boom := errors.New("boom")
+	fi, _ := NewFaultInjector(src, FaultScript(
+		Fault{Kind: FaultTemporaryError},
+		Fault{Kind: FaultPermanentError},
+		Fault{Kind: FaultPermanentError, Err: boom},
+		Fault{Kind: FaultTruncate, Bytes: 5},
+		Fault{Kind: FaultCorrupt, Bytes: 3},
+		Fault{Kind: FaultShortReads},
+		Fault{Kind: FaultDelay, Delay: 20 * time.Millisecond},
+		Fault{Kind: FaultWrongSize, Bytes: 10},
+		Fault{Kind: FaultWrongSize},
+	))
+
+	_, _, err = fi.StreamAt("k", 0, 10)
+	assert.True(t, DefaultRetryable(err))
+	var ie *InjectedError
+	assert.True(t, errors.As(err, &ie))
+	assert.Equal(t, "injected temporary error for k at offset 0", err.Error())
+	_, _, err = fi.StreamAt("k", 0, 10)
+	assert.False(t, DefaultRetryable(err))
+	_, _, err = fi.StreamAt("k", 0, 10)
+	assert.Equal(t, boom, err)
+
+	r, _, err := fi.StreamAt("k", 10, 10)
+	assert.NoError(t, err)
+	buf, err := ioutil.ReadAll(r)
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+	assert.Equal(t, data[10:15], buf)
+	assert.True(t, DefaultRetryable(err))
+
+	r, _, _ = fi.StreamAt("k", 10, 10)
+	buf, _ = ioutil.ReadAll(r)
+	assert.Equal(t, data[10:13], buf[:3])
+	assert.Equal(t, ^data[13], buf[3])
+	assert.Equal(t, data[14:20], buf[4:])
+
+	r, _, _ = fi.StreamAt("k", 10, 10)
+	rb := make([]byte, 10)
+	n, _ := r.Read(rb)
+	assert.Equal(t, 1, n)
+
+	r, _, _ = fi.StreamAt("k", 10, 10)
+	st := time.Now()
+	buf, _ = ioutil.ReadAll(r)
+	assert.GreaterOrEqual(t, int64(time.Since(st)), int64(20*time.Millisecond))
+	assert.Equal(t, data[10:20], buf)
+
+	_, size, _ := fi.StreamAt("k", 0, 10)
+	assert.Equal(t, int64(1010), size)
+	//wrong sizes are only injected at offset 0
+	_, size, _ = fi.StreamAt("k", 10, 10)
+	assert.Equal(t, int64(1000), size)
+
+	//script exhausted
+	r, _, err = fi.StreamAt("k", 10, 10)
+	assert.NoError(t, err)
+	buf, _ = ioutil.ReadAll(r)
+	assert.Equal(t, data[10:20], buf)
+
+	assert.Equal(t, 2, fi.Injected(FaultPermanentError))
+	assert.Equal(t, 1, fi.Injected(FaultWrongSize))
+	assert.Equal(t, 2, fi.Injected(FaultNone))
+	assert.Equal(t, "short reads", FaultShortReads.String())
+
+	//body faults are applied to streams returned along with io.EOF
+	fi, _ = NewFaultInjector(src, FaultScript(Fault{Kind: FaultTruncate, Bytes: 4}))
+	r, size, err = fi.StreamAt("k", 990, 20)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, int64(1000), size)
+	buf, err = ioutil.ReadAll(r)
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+	assert.Equal(t, data[990:994], buf)
+
+	//delays are cancelled with the context
+	fi, _ = NewFaultInjector(src, FaultFunc(func(key string, off, n int64) Fault {
+		return Fault{Kind: FaultDelay, Delay: time.Hour}
+	}))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	r, _, _ = fi.StreamAtContext(ctx, "k", 0, 10)
+	_, err = r.Read(rb)
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
+
+func TestFaultInjectorRange(t *testing.T) {
+	data := make([]byte, 1000)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	src := TReader{data}
+
+	//a multi-block range truncated twice is resumed from the missing bytes
+	fi, _ := NewFaultInjector(src, FaultScript(
+		Fault{Kind: FaultTruncate, Bytes: 6},
+		Fault{Kind: FaultTruncate, Bytes: 5},
+		Fault{Kind: FaultShortReads, Bytes: 3},
+	))
+	a, _ := NewAdapter(fi, BlockSize("4"), WithRetryPolicy(RetryPolicy{InitialBackoff: time.Millisecond}))
+	buf := make([]byte, 20)
+	n, err := a.ReadAt("k", buf, 100)
+	assert.NoError(t, err)
+	assert.Equal(t, 20, n)
+	assert.Equal(t, data[100:120], buf)
+	assert.Equal(t, uint64(2), a.Stats().Retries)
+
+	//errors before streaming are retried
+	fi, _ = NewFaultInjector(src, FaultScript(
+		Fault{Kind: FaultTemporaryError},
+		Fault{Kind: FaultTemporaryError},
+	))
+	a, _ = NewAdapter(fi, BlockSize("4"), WithRetryPolicy(RetryPolicy{InitialBackoff: time.Millisecond}))
+	n, err = a.ReadAt("k", buf, 100)
+	assert.NoError(t, err)
+	assert.Equal(t, 20, n)
+	assert.Equal(t, data[100:120], buf)
+
+	//permanent errors are not
+	fi, _ = NewFaultInjector(src, FaultScript(
+		Fault{Kind: FaultPermanentError},
+	))
+	a, _ = NewAdapter(fi, BlockSize("4"))
+	_, err = a.ReadAt("k", buf, 100)
+	var ie *InjectedError
+	assert.True(t, errors.As(err, &ie))
+	assert.Equal(t, uint64(0), a.Stats().Retries)
+}
+
+func TestFaultInjectorRandom(t *testing.T) {
+	data := make([]byte, 1000)
+	for i := range data {
+		data[i] = byte(i * 7)
+	}
+	src := TReader{data}
+	fi, _ := NewFaultInjector(src, RandomFaults(42, FaultRates{
+		TemporaryError: 0.15,
+		Truncate:       0.15,
+		ShortReads:     0.15,
+		Delay:          0.15,
+		MaxDelay:       2 * time.Millisecond,
+	}))
+	for _, split := range []bool{false, true} {
+		a, _ := NewAdapter(fi, BlockSize("16"), NumCachedBlocks(8), SplitRanges(split),
+			Retries(20), WithRetryPolicy(RetryPolicy{InitialBackoff: time.Millisecond, MaxBackoff: time.Millisecond}))
+		wg := sync.WaitGroup{}
+		for g := 0; g < 8; g++ {
+			wg.Add(1)
+			go func(seed int64) {
+				defer wg.Done()
+				rnd := rand.New(rand.NewSource(seed))
+				for i := 0; i < 20; i++ {
+					bufs := make([][]byte, 3)
+					offsets := make([]int64, 3)
+					for j := range bufs {
+						offsets[j] = rnd.Int63n(900)
+						bufs[j] = make([]byte, 1+rnd.Intn(100))
+					}
+					ns, err := a.ReadAtMulti("k", bufs, offsets)
+					if !assert.NoError(t, err) {
+						return
+					}
+					for j := range bufs {
+						assert.Equal(t, len(bufs[j]), ns[j])
+						assert.Equal(t, data[offsets[j]:offsets[j]+int64(len(bufs[j]))], bufs[j])
+					}
+				}
+			}(int64(g))
+		}
+		wg.Wait()
+	}
+	assert.Greater(t, fi.Injected(FaultTruncate), 0)
+	assert.Greater(t, fi.Injected(FaultTemporaryError), 0)
+}
@@ -0,0 +1,256 @@
+// Copyright 2021 Airbus Defence and Space
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package osio
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// FileHandler is a KeyStreamerAt reading local files. Keys are either plain paths or
+// file:// urls
+type FileHandler struct {
+	root string
+}
+
+// FileOption is an option that can be passed to FileHandle
+type FileOption func(o *FileHandler)
+
+// FileRoot restricts the handler to the files under dir. Keys are then interpreted relative
+// to dir, and cannot refer to files outside of it through "..". Symbolic links are followed as
+// long as they resolve to a file under dir: keys resolving outside of dir are reported as not
+// existing.
+func FileRoot(dir string) FileOption {
+	return func(o *FileHandler) {
+		o.root = dir
+	}
+}
+
+// FileHandle creates a KeyStreamerAt suitable for constructing an Adapter
+// that accesses local files
+func FileHandle(opts ...FileOption) (*FileHandler, error) {
+	handler := &FileHandler{}
+	for _, o := range opts {
+		o(handler)
+	}
+	if handler.root != "" {
+		root, err := filepath.Abs(handler.root)
+		if err != nil {
+			return nil, fmt.Errorf("file root %s: %w", handler.root, err)
+		}
+		//resolved paths are compared against the resolved root
+		if resolved, err := filepath.EvalSymlinks(root); err == nil {
+			root = resolved
+		}
+		handler.root = root
+	}
+	return handler, nil
+}
+
+var (
+	_ KeyVersionStreamerAt = &FileHandler{}
+	_ KeyLister            = &FileHandler{}
+)
+
+// path returns the local path of key
+func (h *FileHandler) path(key string) string {
+	key = strings.TrimPrefix(key, "file://")
+	if h.root == "" {
+		return filepath.FromSlash(key)
+	}
+	return filepath.Join(h.root, filepath.Clean("/"+filepath.FromSlash(key)))
+}
+
+// resolve returns the local path of key, making sure that it does not escape the root once
+// symbolic links are resolved
+func (h *FileHandler) resolve(key string) (string, error) {
+	path := h.path(key)
+	if h.root == "" {
+		return path, nil
+	}
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", err
+	}
+	root := h.root
+	if !strings.HasSuffix(root, string(filepath.Separator)) {
+		root += string(filepath.Separator)
+	}
+	if resolved != h.root && !strings.HasPrefix(resolved, root) {
+		return "", fmt.Errorf("%s resolves outside of %s: %w", key, h.root, os.ErrNotExist)
+	}
+	return resolved, nil
+}
+
+// fileVersion returns a version string changing whenever the file is modified
+func fileVersion(st os.FileInfo) string {
+	return strconv.FormatInt(st.ModTime().UnixNano(), 10) + "-" + strconv.FormatInt(st.Size(), 10)
+}
+
+type fileSection struct {
+	*io.SectionReader
+	f *os.File
+}
+
+func (s fileSection) Close() error {
+	return s.f.Close()
+}
+
+// StreamAt implements KeyStreamerAt
+func (h *FileHandler) StreamAt(key string, off int64, n int64) (io.ReadCloser, int64, error) {
+	r, size, _, err := h.StreamAtVersion(context.Background(), key, off, n, "")
+	return r, size, err
+}
+
+// StreamAtContext implements KeyStreamerAtContext
+func (h *FileHandler) StreamAtContext(ctx context.Context, key string, off int64, n int64) (io.ReadCloser, int64, error) {
+	r, size, _, err := h.StreamAtVersion(ctx, key, off, n, "")
+	return r, size, err
+}
+
+// StreamAtVersion implements KeyVersionStreamerAt. The version of a file is derived from its
+// modification time and size. The size of the file is returned for all offsets.
+func (h *FileHandler) StreamAtVersion(ctx context.Context, key string, off int64, n int64, version string) (io.ReadCloser, int64, string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, 0, "", err
+	}
+	path, err := h.resolve(key)
+	var f *os.File
+	if err == nil {
+		f, err = os.Open(path)
+	}
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, -1, "", syscall.ENOENT
+		}
+		return nil, 0, "", fmt.Errorf("new reader for %s: %w", key, err)
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, "", fmt.Errorf("stat %s: %w", key, err)
+	}
+	if st.IsDir() {
+		f.Close()
+		return nil, -1, "", syscall.ENOENT
+	}
+	curVersion := fileVersion(st)
+	if version != "" && version != curVersion {
+		f.Close()
+		return nil, 0, "", fmt.Errorf("new reader for %s: %w", key, ErrObjectChanged)
+	}
+	size := st.Size()
+	if off >= size {
+		f.Close()
+		return nil, size, curVersion, io.EOF
+	}
+	return fileSection{io.NewSectionReader(f, off, n), f}, size, curVersion, nil
+}
+
+func fileAttrs(key string, st os.FileInfo) ObjectAttrs {
+	return ObjectAttrs{Key: key, Size: st.Size(), Version: fileVersion(st), ModTime: st.ModTime()}
+}
+
+// ListObjects implements KeyLister. Listing with the "/" delimiter only reads the directory
+// containing prefix, whereas other delimiters require walking the whole directory tree.
+func (h *FileHandler) ListObjects(ctx context.Context, prefix, delimiter string) ObjectIterator {
+	base := prefix[:strings.LastIndex(prefix, "/")+1]
+	objects := []ObjectAttrs{}
+	dir, err := h.resolve(base)
+	if errors.Is(err, os.ErrNotExist) {
+		return SliceIterator(objects, prefix, delimiter)
+	}
+	if err != nil {
+		return ErrorIterator(fmt.Errorf("list %s: %w", prefix, err))
+	}
+	if dir == "" {
+		dir = "."
+	}
+	if delimiter == "/" {
+		entries, err := os.ReadDir(dir)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return ErrorIterator(fmt.Errorf("list %s: %w", prefix, err))
+		}
+		for _, e := range entries {
+			key := base + e.Name()
+			if !strings.HasPrefix(key, prefix) {
+				continue
+			}
+			st, err := os.Stat(filepath.Join(dir, e.Name()))
+			if err != nil {
+				//broken symbolic link
+				continue
+			}
+			if e.Type()&os.ModeSymlink != 0 && h.root != "" {
+				if _, err := h.resolve(key); err != nil {
+					continue
+				}
+			}
+			if st.IsDir() {
+				objects = append(objects, ObjectAttrs{Key: key + "/", Prefix: true})
+			} else {
+				objects = append(objects, fileAttrs(key, st))
+			}
+		}
+		return SliceIterator(objects, prefix, delimiter)
+	}
+	err = filepath.Walk(dir, func(path string, st os.FileInfo, err error) error {
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		key := base + filepath.ToSlash(rel)
+		if st.IsDir() {
+			if rel != "." && !strings.HasPrefix(key+"/", prefix) && !strings.HasPrefix(prefix, key+"/") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		if st.Mode()&os.ModeSymlink != 0 {
+			//symbolic links to directories are not walked
+			if _, err := h.resolve(key); err != nil {
+				return nil
+			}
+			if st, err = os.Stat(path); err != nil || st.IsDir() {
+				return nil
+			}
+		}
+		objects = append(objects, fileAttrs(key, st))
+		return nil
+	})
+	if err != nil {
+		return ErrorIterator(fmt.Errorf("list %s: %w", prefix, err))
+	}
+	return SliceIterator(objects, prefix, delimiter)
+}
@@ -0,0 +1,183 @@
+// Copyright 2021 Airbus Defence and Space
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package osio
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFile(t *testing.T) {
+	dir := t.TempDir()
+	fname := filepath.Join(dir, "data.bin")
+	_ = ioutil.WriteFile(fname, []byte("aaaabbbbcccc"), 0644)
+	_ = os.Mkdir(filepath.Join(dir, "sub"), 0755)
+	_ = ioutil.WriteFile(filepath.Join(dir, "sub", "f"), []byte("sub"), 0644)
+
+	fh, _ := FileHandle()
+	r, size, err := fh.StreamAt(fname, 0, 6)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(12), size)
+	buf, _ := ioutil.ReadAll(r)
+	assert.Equal(t, "aaaabb", string(buf))
+	assert.NoError(t, r.Close())
+
+	r, size, err = fh.StreamAt("file://"+filepath.ToSlash(fname), 8, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(12), size)
+	buf, _ = ioutil.ReadAll(r)
+	assert.Equal(t, "cccc", string(buf))
+	r.Close()
+
+	_, size, err = fh.StreamAt(fname, 12, 10)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, int64(12), size)
+
+	_, _, err = fh.StreamAt(filepath.Join(dir, "missing"), 0, 10)
+	assert.Equal(t, syscall.ENOENT, err)
+	_, _, err = fh.StreamAt(dir, 0, 10)
+	assert.Equal(t, syscall.ENOENT, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, _, err = fh.StreamAtContext(ctx, fname, 0, 10)
+	assert.Equal(t, context.Canceled, err)
+
+	//versions
+	_, _, v1, err := fh.StreamAtVersion(context.Background(), fname, 12, 1, "")
+	assert.Equal(t, io.EOF, err)
+	assert.NotEmpty(t, v1)
+	r, _, v, err := fh.StreamAtVersion(context.Background(), fname, 0, 1, v1)
+	assert.NoError(t, err)
+	assert.Equal(t, v1, v)
+	r.Close()
+	_ = os.Chtimes(fname, time.Now(), time.Now().Add(time.Hour))
+	_, _, _, err = fh.StreamAtVersion(context.Background(), fname, 0, 1, v1)
+	assert.True(t, errors.Is(err, ErrObjectChanged))
+
+	//root
+	fh, _ = FileHandle(FileRoot(dir))
+	r, _, err = fh.StreamAt("file://sub/f", 0, 10)
+	assert.NoError(t, err)
+	buf, _ = ioutil.ReadAll(r)
+	assert.Equal(t, "sub", string(buf))
+	r.Close()
+	r, _, err = fh.StreamAt("/data.bin", 0, 4)
+	assert.NoError(t, err)
+	r.Close()
+	_, _, err = fh.StreamAt("sub/../../"+filepath.Base(dir)+"/data.bin", 0, 4)
+	assert.Equal(t, syscall.ENOENT, err)
+	assert.Equal(t, fname, fh.path("../../../data.bin"))
+
+	//symbolic links may not escape the root
+	outside := t.TempDir()
+	_ = ioutil.WriteFile(filepath.Join(outside, "secret"), []byte("secret"), 0644)
+	_ = os.Symlink(filepath.Join(outside, "secret"), filepath.Join(dir, "escape"))
+	_ = os.Symlink(outside, filepath.Join(dir, "escapedir"))
+	_ = os.Symlink(fname, filepath.Join(dir, "inside"))
+	_, _, err = fh.StreamAt("escape", 0, 4)
+	assert.Equal(t, syscall.ENOENT, err)
+	_, _, err = fh.StreamAt("escapedir/secret", 0, 4)
+	assert.Equal(t, syscall.ENOENT, err)
+	r, _, err = fh.StreamAt("inside", 0, 4)
+	assert.NoError(t, err)
+	r.Close()
+	//the root itself may be a symbolic link
+	_ = os.Symlink(dir, filepath.Join(outside, "root"))
+	lfh, _ := FileHandle(FileRoot(filepath.Join(outside, "root")))
+	r, _, err = lfh.StreamAt("inside", 0, 4)
+	assert.NoError(t, err)
+	r.Close()
+	_, _, err = lfh.StreamAt("escape", 0, 4)
+	assert.Equal(t, syscall.ENOENT, err)
+
+	//through an adapter
+	a, _ := NewAdapter(fh, BlockSize("4"))
+	rd, err := a.Reader("data.bin")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(12), rd.Size())
+	buf, _ = ioutil.ReadAll(rd)
+	assert.Equal(t, "aaaabbbbcccc", string(buf))
+	_, err = a.Reader("missing")
+	assert.Equal(t, syscall.ENOENT, err)
+}
+
+func TestFileList(t *testing.T) {
+	dir := t.TempDir()
+	_ = os.MkdirAll(filepath.Join(dir, "a", "b"), 0755)
+	_ = os.Mkdir(filepath.Join(dir, "c"), 0755)
+	for _, f := range []string{"a.txt", "a/1", "a/b/2", "c/3", "d"} {
+		_ = ioutil.WriteFile(filepath.Join(dir, filepath.FromSlash(f)), []byte(f), 0644)
+	}
+	base := filepath.ToSlash(dir) + "/"
+
+	fh, _ := FileHandle()
+	assert.Equal(t, []string{base + "a.txt", base + "a/", base + "c/", base + "d"},
+		listKeys(t, fh.ListObjects(context.Background(), base, "/")))
+	assert.Equal(t, []string{base + "a.txt", base + "a/"},
+		listKeys(t, fh.ListObjects(context.Background(), base+"a", "/")))
+	assert.Equal(t, []string{base + "a/1", base + "a/b/2"},
+		listKeys(t, fh.ListObjects(context.Background(), base+"a/", "")))
+	assert.Equal(t, []string{"file://" + base + "c/3"},
+		listKeys(t, fh.ListObjects(context.Background(), "file://"+base+"c/", "")))
+	assert.Empty(t, listKeys(t, fh.ListObjects(context.Background(), base+"missing/", "/")))
+	assert.Empty(t, listKeys(t, fh.ListObjects(context.Background(), base+"missing/", "")))
+
+	it := fh.ListObjects(context.Background(), base+"a/", "/")
+	attrs, _ := it.Next()
+	assert.Equal(t, int64(3), attrs.Size)
+	assert.False(t, attrs.ModTime.IsZero())
+	_, _, v, _ := fh.StreamAtVersion(context.Background(), attrs.Key, 0, 1, "")
+	assert.Equal(t, v, attrs.Version)
+
+	//root
+	fh, _ = FileHandle(FileRoot(dir))
+	assert.Equal(t, []string{"a.txt", "a/", "c/", "d"},
+		listKeys(t, fh.ListObjects(context.Background(), "", "/")))
+	assert.Equal(t, []string{"a/b/2"},
+		listKeys(t, fh.ListObjects(context.Background(), "a/b", "")))
+
+	//symbolic links escaping the root are not listed
+	outside := t.TempDir()
+	_ = ioutil.WriteFile(filepath.Join(outside, "secret"), []byte("secret"), 0644)
+	_ = os.Symlink(filepath.Join(outside, "secret"), filepath.Join(dir, "c", "escape"))
+	_ = os.Symlink(outside, filepath.Join(dir, "c", "escapedir"))
+	_ = os.Symlink(filepath.Join(dir, "d"), filepath.Join(dir, "c", "inside"))
+	assert.Equal(t, []string{"c/3", "c/inside"},
+		listKeys(t, fh.ListObjects(context.Background(), "c/", "/")))
+	assert.Equal(t, []string{"c/3", "c/inside"},
+		listKeys(t, fh.ListObjects(context.Background(), "c/", "")))
+	assert.Empty(t, listKeys(t, fh.ListObjects(context.Background(), "c/escapedir/", "/")))
+	it = fh.ListObjects(context.Background(), "c/inside", "")
+	attrs, _ = it.Next()
+	assert.Equal(t, int64(1), attrs.Size)
+	_ = os.Remove(filepath.Join(dir, "c", "inside"))
+	_ = os.Remove(filepath.Join(dir, "c", "escape"))
+	_ = os.Remove(filepath.Join(dir, "c", "escapedir"))
+
+	//through FS
+	a, _ := NewAdapter(fh)
+	assert.NoError(t, fstest.TestFS(FS(a, ""), "a.txt", "a/1", "a/b/2", "c/3", "d"))
+}
@@ -0,0 +1,308 @@
+// Copyright 2021 Airbus Defence and Space
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package osio
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// errIsDir is returned when reading from a directory
+var errIsDir = errors.New("is a directory")
+
+// osioFS is an fs.FS serving the objects of an Adapter
+type osioFS struct {
+	a       *Adapter
+	prefix  string
+	listing bool
+}
+
+// listingFS is an osioFS whose KeyStreamerAt can list the objects under its root
+type listingFS struct {
+	*osioFS
+}
+
+var (
+	_ fs.StatFS     = &osioFS{}
+	_ fs.ReadFileFS = &osioFS{}
+	_ fs.ReadDirFS  = listingFS{}
+)
+
+// FS returns an fs.FS serving the objects of a whose keys start with root, e.g. "gs://bucket"
+// or "gs://bucket/path". The names of the files are the keys of the objects relative to root,
+// using "/" as a directory separator.
+//
+// The returned fs.FS implements fs.StatFS and fs.ReadFileFS, and fs.ReadDirFS if the
+// KeyStreamerAt of a can list the objects under root (see CanList). Without listing, only files
+// can be opened.
+//
+// Files are backed by a Reader, and can therefore be used as io.ReaderAt and io.Seeker. Their
+// modification time is only known once they have been listed.
+func FS(a *Adapter, root string) fs.FS {
+	fsys := &osioFS{a: a, prefix: root}
+	if root != "" && !strings.HasSuffix(root, "/") {
+		fsys.prefix += "/"
+	}
+	if CanList(a.keyStreamer, fsys.prefix) {
+		fsys.listing = true
+		return listingFS{fsys}
+	}
+	return fsys
+}
+
+// key returns the key of the object, or the prefix of the directory, of name
+func (fsys *osioFS) key(name string) string {
+	if name == "." {
+		return fsys.prefix
+	}
+	return fsys.prefix + name
+}
+
+// fsError maps the errors of the Adapter to their io/fs equivalent
+func fsError(op, name string, err error) error {
+	if errors.Is(err, syscall.ENOENT) {
+		err = fs.ErrNotExist
+	}
+	return &fs.PathError{Op: op, Path: name, Err: err}
+}
+
+// isDir returns whether there are objects under the directory name
+func (fsys *osioFS) isDir(name string) bool {
+	if !fsys.listing {
+		return false
+	}
+	_, err := fsys.a.ListObjects(context.Background(), fsys.key(name)+"/", "/").Next()
+	return err == nil
+}
+
+// Open implements fs.FS
+func (fsys *osioFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, fsError("open", name, fs.ErrInvalid)
+	}
+	if name == "." {
+		return &dir{fsys: fsys, name: name}, nil
+	}
+	r, err := fsys.a.Reader(fsys.key(name))
+	if err == nil {
+		return &file{Reader: r, name: name}, nil
+	}
+	if errors.Is(err, syscall.ENOENT) && fsys.isDir(name) {
+		return &dir{fsys: fsys, name: name}, nil
+	}
+	return nil, fsError("open", name, err)
+}
+
+// Stat implements fs.StatFS
+func (fsys *osioFS) Stat(name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, fsError("stat", name, fs.ErrInvalid)
+	}
+	if name == "." {
+		return dirInfo(name), nil
+	}
+	key := fsys.key(name)
+	size, err := fsys.a.Size(key)
+	if err == nil {
+		oi, _ := fsys.a.objectInfo(key)
+		return &fileInfo{name: path.Base(name), attrs: ObjectAttrs{Key: key, Size: size, Version: oi.version, ModTime: oi.modTime}}, nil
+	}
+	if errors.Is(err, syscall.ENOENT) && fsys.isDir(name) {
+		return dirInfo(name), nil
+	}
+	return nil, fsError("stat", name, err)
+}
+
+// ReadFile implements fs.ReadFileFS
+func (fsys *osioFS) ReadFile(name string) ([]byte, error) {
+	if !fs.ValidPath(name) {
+		return nil, fsError("read", name, fs.ErrInvalid)
+	}
+	r, err := fsys.a.Reader(fsys.key(name))
+	if err != nil {
+		return nil, fsError("read", name, err)
+	}
+	buf := make([]byte, r.Size())
+	if len(buf) == 0 {
+		return buf, nil
+	}
+	n, err := r.ReadAt(buf, 0)
+	if n == len(buf) {
+		return buf, nil
+	}
+	if err == nil || err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return nil, fsError("read", name, err)
+}
+
+// ReadDir implements fs.ReadDirFS
+func (fsys listingFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, fsError("readdir", name, fs.ErrInvalid)
+	}
+	entries, err := fsys.readDir(name)
+	if err != nil {
+		return nil, fsError("readdir", name, err)
+	}
+	if len(entries) == 0 && name != "." {
+		//object stores have no empty directories
+		return nil, fsError("readdir", name, fs.ErrNotExist)
+	}
+	return entries, nil
+}
+
+// readDir lists the entries of the directory name, sorted by name
+func (fsys *osioFS) readDir(name string) ([]fs.DirEntry, error) {
+	if !fsys.listing {
+		return nil, ErrListingNotSupported
+	}
+	prefix := fsys.key(name)
+	if name != "." {
+		prefix += "/"
+	}
+	it := fsys.a.ListObjects(context.Background(), prefix, "/")
+	entries := []fs.DirEntry{}
+	for {
+		attrs, err := it.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		ename := strings.TrimSuffix(strings.TrimPrefix(attrs.Key, prefix), "/")
+		if ename == "" {
+			//directory placeholder object
+			continue
+		}
+		entries = append(entries, dirEntry{&fileInfo{name: ename, attrs: attrs}})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+// file is an fs.File on an object
+type file struct {
+	*Reader
+	name string
+}
+
+// Stat implements fs.File
+func (f *file) Stat() (fs.FileInfo, error) {
+	var modTime time.Time
+	if oi, ok := f.a.objectInfo(f.key); ok && oi.version == f.version {
+		modTime = oi.modTime
+	}
+	return &fileInfo{name: path.Base(f.name), attrs: ObjectAttrs{Key: f.key, Size: f.size, Version: f.version, ModTime: modTime}}, nil
+}
+
+// Close implements fs.File
+func (f *file) Close() error {
+	return nil
+}
+
+// dir is an fs.ReadDirFile on a directory
+type dir struct {
+	fsys    *osioFS
+	name    string
+	entries []fs.DirEntry
+	listed  bool
+}
+
+func (d *dir) Stat() (fs.FileInfo, error) {
+	return dirInfo(d.name), nil
+}
+
+func (d *dir) Read([]byte) (int, error) {
+	return 0, fsError("read", d.name, errIsDir)
+}
+
+func (d *dir) Close() error {
+	return nil
+}
+
+// ReadDir implements fs.ReadDirFile
+func (d *dir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !d.listed {
+		entries, err := d.fsys.readDir(d.name)
+		if err != nil {
+			return nil, fsError("readdir", d.name, err)
+		}
+		d.entries = entries
+		d.listed = true
+	}
+	if n <= 0 {
+		entries := d.entries
+		d.entries = []fs.DirEntry{}
+		return entries, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(d.entries) {
+		n = len(d.entries)
+	}
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+	return entries, nil
+}
+
+// fileInfo is the fs.FileInfo of an object or of a common prefix
+type fileInfo struct {
+	name  string
+	attrs ObjectAttrs
+}
+
+func dirInfo(name string) *fileInfo {
+	return &fileInfo{name: path.Base(name), attrs: ObjectAttrs{Prefix: true}}
+}
+
+func (fi *fileInfo) Name() string       { return fi.name }
+func (fi *fileInfo) Size() int64        { return fi.attrs.Size }
+func (fi *fileInfo) ModTime() time.Time { return fi.attrs.ModTime }
+func (fi *fileInfo) IsDir() bool        { return fi.attrs.Prefix }
+
+// Sys returns the ObjectAttrs of the object
+func (fi *fileInfo) Sys() interface{} { return fi.attrs }
+
+func (fi *fileInfo) Mode() fs.FileMode {
+	if fi.attrs.Prefix {
+		return fs.ModeDir | 0555
+	}
+	return 0444
+}
+
+func (fi *fileInfo) String() string {
+	return fmt.Sprintf("%s %d %s", fi.Mode(), fi.Size(), fi.name)
+}
+
+// dirEntry is the fs.DirEntry of a listed object or common prefix
+type dirEntry struct {
+	info *fileInfo
+}
+
+func (e dirEntry) Name() string               { return e.info.name }
+func (e dirEntry) IsDir() bool                { return e.info.IsDir() }
+func (e dirEntry) Type() fs.FileMode          { return e.info.Mode().Type() }
+func (e dirEntry) Info() (fs.FileInfo, error) { return e.info, nil }
@@ -0,0 +1,130 @@
+// Copyright 2021 Airbus Defence and Space
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package osio
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// listStore is a replicaStore that can list its objects
+type listStore struct {
+	replicaStore
+}
+
+func (s *listStore) ListObjects(ctx context.Context, prefix, delimiter string) ObjectIterator {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	objects := []ObjectAttrs{}
+	for k, v := range s.objects {
+		objects = append(objects, ObjectAttrs{Key: k, Size: int64(len(v))})
+	}
+	return SliceIterator(objects, prefix, delimiter)
+}
+
+func TestFS(t *testing.T) {
+	objects := map[string][]byte{
+		"gs://bucket/root/a.txt":       []byte("aaaa"),
+		"gs://bucket/root/empty":       {},
+		"gs://bucket/root/dir/b.txt":   []byte("bbbbbbbbbbbbbbbbbbbbbbbbbbbbbb"),
+		"gs://bucket/root/dir/sub/c":   []byte("c"),
+		"gs://bucket/root/dir2/":       {},
+		"gs://bucket/root/dir2/d.json": []byte("{}"),
+		"gs://bucket/other":            []byte("other"),
+	}
+	ls := &listStore{replicaStore{objects: objects}}
+	a, _ := NewAdapter(ls, BlockSize("8"))
+	fsys := FS(a, "gs://bucket/root")
+	assert.Implements(t, (*fs.ReadDirFS)(nil), fsys)
+	assert.NoError(t, fstest.TestFS(fsys, "a.txt", "empty", "dir/b.txt", "dir/sub/c", "dir2/d.json"))
+
+	data, err := fs.ReadFile(fsys, "dir/b.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, objects["gs://bucket/root/dir/b.txt"], data)
+
+	_, err = fsys.Open("missing")
+	assert.True(t, errors.Is(err, fs.ErrNotExist))
+	_, err = fs.Stat(fsys, "dir/missing")
+	assert.True(t, errors.Is(err, fs.ErrNotExist))
+	_, err = fs.ReadDir(fsys, "missing")
+	assert.True(t, errors.Is(err, fs.ErrNotExist))
+	_, err = fs.ReadFile(fsys, "missing")
+	assert.True(t, errors.Is(err, fs.ErrNotExist))
+	_, err = fsys.Open("../other")
+	assert.True(t, errors.Is(err, fs.ErrInvalid))
+
+	st, err := fs.Stat(fsys, "dir")
+	assert.NoError(t, err)
+	assert.True(t, st.IsDir())
+	st, err = fs.Stat(fsys, "a.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), st.Size())
+	assert.Equal(t, "gs://bucket/root/a.txt", st.Sys().(ObjectAttrs).Key)
+
+	//files are io.ReaderAt and io.Seeker
+	f, _ := fsys.Open("dir/b.txt")
+	buf := make([]byte, 4)
+	_, err = f.(io.ReaderAt).ReadAt(buf, 10)
+	assert.NoError(t, err)
+	_, err = f.(io.Seeker).Seek(-2, io.SeekEnd)
+	assert.NoError(t, err)
+	n, _ := f.Read(buf)
+	assert.Equal(t, 2, n)
+	assert.NoError(t, f.Close())
+
+	d, _ := fsys.Open("dir")
+	_, err = d.Read(buf)
+	assert.Error(t, err)
+	entries, err := d.(fs.ReadDirFile).ReadDir(1)
+	assert.NoError(t, err)
+	assert.Equal(t, "b.txt", entries[0].Name())
+	entries, _ = d.(fs.ReadDirFile).ReadDir(5)
+	assert.Equal(t, "sub", entries[0].Name())
+	assert.True(t, entries[0].IsDir())
+	_, err = d.(fs.ReadDirFile).ReadDir(1)
+	assert.Equal(t, io.EOF, err)
+
+	//without listing, only files can be opened
+	fsys = FS(a, "gs://bucket/root/")
+	_, ok := fsys.(fs.ReadDirFS)
+	assert.True(t, ok)
+	a, _ = NewAdapter(&ls.replicaStore)
+	fsys = FS(a, "gs://bucket/root")
+	_, ok = fsys.(fs.ReadDirFS)
+	assert.False(t, ok)
+	data, err = fs.ReadFile(fsys, "a.txt")
+	assert.NoError(t, err)
+	assert.Equal(t, "aaaa", string(data))
+	_, err = fs.Stat(fsys, "dir")
+	assert.True(t, errors.Is(err, fs.ErrNotExist))
+	_, err = fs.ReadDir(fsys, ".")
+	assert.Error(t, err)
+
+	//listing support is decided by the handler serving root
+	mux := NewMux()
+	_ = mux.Register("gs://", ls)
+	_ = mux.Register("https://", rr)
+	a, _ = NewAdapter(mux)
+	_, ok = FS(a, "gs://bucket/root").(fs.ReadDirFS)
+	assert.True(t, ok)
+	_, ok = FS(a, "https://host/root").(fs.ReadDirFS)
+	assert.False(t, ok)
+}
@@ -19,12 +19,20 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"strconv"
 	"syscall"
 
 	"cloud.google.com/go/storage"
 	"github.com/airbusgeo/errs"
+	"github.com/airbusgeo/osio"
 	"github.com/airbusgeo/osio/internal"
 	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iterator"
+)
+
+var (
+	_ osio.KeyVersionStreamerAt = &Handler{}
+	_ osio.KeyLister            = &Handler{}
 )
 
 type Handler struct {
@@ -90,28 +98,108 @@ func (r readWrapper) Close() error {
 	return nil
 }
 
+// StreamAt implements osio.KeyStreamerAt, using the context the handler was created with
 func (gcs *Handler) StreamAt(key string, off int64, n int64) (io.ReadCloser, int64, error) {
+	return gcs.StreamAtContext(gcs.ctx, key, off, n)
+}
+
+// StreamAtContext implements osio.KeyStreamerAtContext
+func (gcs *Handler) StreamAtContext(ctx context.Context, key string, off int64, n int64) (io.ReadCloser, int64, error) {
+	r, size, _, err := gcs.StreamAtVersion(ctx, key, off, n, "")
+	return r, size, err
+}
+
+// StreamAtVersion implements osio.KeyVersionStreamerAt. The version of an object is its generation
+func (gcs *Handler) StreamAtVersion(ctx context.Context, key string, off int64, n int64, version string) (io.ReadCloser, int64, string, error) {
+	//requests are also canceled with the context the handler was created with
+	ctx, cancel := internal.MergeContext(ctx, gcs.ctx)
+	rc, size, version, err := gcs.streamAtVersion(ctx, key, off, n, version)
+	return internal.CancelOnClose(rc, cancel), size, version, err
+}
+
+func (gcs *Handler) streamAtVersion(ctx context.Context, key string, off int64, n int64, version string) (io.ReadCloser, int64, string, error) {
 	bucket, object, err := internal.BucketObject(key)
 	if err != nil {
-		return nil, 0, err
+		return nil, 0, "", err
 	}
 	gbucket := gcs.client.Bucket(bucket)
 	if gcs.billingProjectID != "" {
 		gbucket = gbucket.UserProject(gcs.billingProjectID)
 	}
-	r, err := gbucket.Object(object).NewRangeReader(gcs.ctx, off, n)
+	gobject := gbucket.Object(object)
+	if version != "" {
+		generation, err := strconv.ParseInt(version, 10, 64)
+		if err != nil {
+			return nil, 0, "", fmt.Errorf("invalid generation %s: %w", version, err)
+		}
+		gobject = gobject.If(storage.Conditions{GenerationMatch: generation})
+	}
+	r, err := gobject.NewRangeReader(ctx, off, n)
 	if err != nil {
 		var gerr *googleapi.Error
 		if off > 0 && errors.As(err, &gerr) && gerr.Code == 416 {
-			return nil, 0, io.EOF
+			return nil, 0, "", io.EOF
+		}
+		if errors.As(err, &gerr) && gerr.Code == 412 {
+			return nil, 0, "", fmt.Errorf("new reader for gs://%s/%s: %w", bucket, object, osio.ErrObjectChanged)
 		}
 		if errors.Is(err, storage.ErrObjectNotExist) || errors.Is(err, storage.ErrBucketNotExist) {
-			return nil, -1, syscall.ENOENT
+			return nil, -1, "", syscall.ENOENT
 		}
 		err = errs.AddTemporaryCheck(err)
-		return nil, 0, fmt.Errorf("new reader for gs://%s/%s: %w", bucket, object, err)
+		return nil, 0, "", fmt.Errorf("new reader for gs://%s/%s: %w", bucket, object, err)
+	}
+	return readWrapper{r}, r.Attrs.Size, strconv.FormatInt(r.Attrs.Generation, 10), nil
+}
+
+type objectIterator struct {
+	it     *storage.ObjectIterator
+	base   string
+	bucket string
+}
+
+func (oi *objectIterator) Next() (osio.ObjectAttrs, error) {
+	attrs, err := oi.it.Next()
+	if err == iterator.Done {
+		return osio.ObjectAttrs{}, io.EOF
+	}
+	if err != nil {
+		if errors.Is(err, storage.ErrBucketNotExist) {
+			return osio.ObjectAttrs{}, syscall.ENOENT
+		}
+		return osio.ObjectAttrs{}, fmt.Errorf("list gs://%s: %w", oi.bucket, errs.AddTemporaryCheck(err))
+	}
+	if attrs.Prefix != "" {
+		return osio.ObjectAttrs{Key: oi.base + attrs.Prefix, Prefix: true}, nil
+	}
+	return osio.ObjectAttrs{
+		Key:     oi.base + attrs.Name,
+		Size:    attrs.Size,
+		Version: strconv.FormatInt(attrs.Generation, 10),
+		ModTime: attrs.Updated,
+	}, nil
+}
+
+// ListObjects implements osio.KeyLister. Objects are listed page by page as the iterator is
+// consumed. Listing a bucket that does not exist fails with syscall.ENOENT
+func (gcs *Handler) ListObjects(ctx context.Context, prefix, delimiter string) osio.ObjectIterator {
+	bucket, objectPrefix, err := internal.BucketPrefix(prefix)
+	if err != nil {
+		return osio.ErrorIterator(err)
+	}
+	gbucket := gcs.client.Bucket(bucket)
+	if gcs.billingProjectID != "" {
+		gbucket = gbucket.UserProject(gcs.billingProjectID)
+	}
+	q := &storage.Query{Prefix: objectPrefix, Delimiter: delimiter}
+	if err := q.SetAttrSelection([]string{"Name", "Size", "Generation", "Updated"}); err != nil {
+		return osio.ErrorIterator(err)
+	}
+	return &objectIterator{
+		it:     gbucket.Objects(ctx, q),
+		base:   internal.KeyBase(prefix, objectPrefix),
+		bucket: bucket,
 	}
-	return readWrapper{r}, r.Attrs.Size, nil
 }
 
 func (gcs *Handler) ReadAt(key string, p []byte, off int64) (int, int64, error) {
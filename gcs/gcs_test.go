@@ -16,6 +16,9 @@ package gcs
 
 import (
 	"context"
+	"flag"
+	"io"
+	"path/filepath"
 	"syscall"
 	"testing"
 
@@ -25,11 +28,33 @@ import (
 	"google.golang.org/api/option"
 )
 
-func TestGCS(t *testing.T) {
+var record = flag.Bool("record", false, "record the test fixtures against GCS")
+
+// handler returns a Replayer serving the fixtures testdata/<name>.json, or a Recorder around a
+// GCS handler saving them once the test is done if the tests are run with -record
+func handler(t *testing.T, name string) osio.KeyStreamerAt {
+	fname := filepath.Join("testdata", name+".json")
+	if !*record {
+		rp, err := osio.LoadReplayer(fname, osio.StrictReplay())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rp
+	}
 	ctx := context.Background()
 	stcl, _ := storage.NewClient(ctx, option.WithoutAuthentication())
 	gcs, _ := Handle(ctx, GCSClient(stcl))
-	gcsa, _ := osio.NewAdapter(gcs)
+	rec := osio.NewRecorder(gcs)
+	t.Cleanup(func() {
+		if err := rec.Save(fname); err != nil {
+			t.Error(err)
+		}
+	})
+	return rec
+}
+
+func TestGCS(t *testing.T) {
+	gcsa, _ := osio.NewAdapter(handler(t, "gcs"), osio.BlockSize("256"))
 	_, err := gcsa.Reader("gs://godal-ci-data-public/gdd/doesnotexist.tif")
 	assert.Equal(t, err, syscall.ENOENT)
 	r, err := gcsa.Reader("gs://godal-ci-data-public/test.tif")
@@ -50,3 +75,36 @@ func TestGCS(t *testing.T) {
 	_, err = gcsa.Reader("godal-ci-data/test-notexists.tif")
 	assert.Error(t, err)
 }
+
+func TestGCSList(t *testing.T) {
+	ctx := context.Background()
+	gcsa, _ := osio.NewAdapter(handler(t, "gcs_list"), osio.BlockSize("256"))
+
+	it := gcsa.ListObjects(ctx, "gs://godal-ci-data-public/test", "/")
+	found := false
+	for {
+		attrs, err := it.Next()
+		if err == io.EOF {
+			break
+		}
+		if !assert.NoError(t, err) {
+			return
+		}
+		if attrs.Key == "gs://godal-ci-data-public/test.tif" {
+			found = true
+			assert.Equal(t, int64(212), attrs.Size)
+			assert.NotEmpty(t, attrs.Version)
+		}
+	}
+	assert.True(t, found)
+	//size is served from the listing
+	r, err := gcsa.Reader("gs://godal-ci-data-public/test.tif")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(212), r.Size())
+	assert.Equal(t, uint64(0), gcsa.Stats().Requests)
+
+	_, err = gcsa.ListObjects(ctx, "gs://godal-ci-data-public/gdd/", "/").Next()
+	assert.Equal(t, io.EOF, err)
+	_, err = gcsa.ListObjects(ctx, "gs://ukn-bucket-osio/", "/").Next()
+	assert.Error(t, err)
+}
@@ -0,0 +1,226 @@
+// Copyright 2021 Airbus Defence and Space
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package osio
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultHedgeMaxRatio is the default value of HedgePolicy.MaxRatio
+	DefaultHedgeMaxRatio = 0.1
+	// hedgeMinSamples is the number of latencies that must have been observed before the
+	// percentile threshold is used
+	hedgeMinSamples = 20
+	hedgeNumSamples = 256
+)
+
+// HedgePolicy configures hedged requests: when a request to the KeyStreamerAt has not
+// produced its first byte after a given delay, a duplicate request is sent. The response that
+// arrives first is used, and the other request is cancelled.
+type HedgePolicy struct {
+	// Delay is a fixed delay after which a request is hedged. When Percentile is also set,
+	// Delay is only used until enough latencies have been observed
+	Delay time.Duration
+	// Percentile, if set, hedges the requests that have been running for longer than this
+	// percentile (e.g. 0.95) of the recently observed time to first byte
+	Percentile float64
+	// MaxRatio caps the number of hedged requests to this ratio of the total number of
+	// requests. Defaults to DefaultHedgeMaxRatio
+	MaxRatio float64
+}
+
+type hedger struct {
+	policy    HedgePolicy
+	requests  uint64
+	hedges    uint64
+	mu        sync.Mutex
+	samples   []time.Duration
+	next      int
+	threshold time.Duration
+}
+
+type hao struct {
+	policy HedgePolicy
+}
+
+func (h hao) adapterOpt(a *Adapter) error {
+	p := h.policy
+	if p.Delay < 0 || p.Percentile < 0 || p.Percentile >= 1 || p.MaxRatio < 0 || p.MaxRatio > 1 {
+		return fmt.Errorf("invalid hedge policy")
+	}
+	if p.Delay == 0 && p.Percentile == 0 {
+		return fmt.Errorf("hedge policy requires a delay or a percentile")
+	}
+	if p.MaxRatio == 0 {
+		p.MaxRatio = DefaultHedgeMaxRatio
+	}
+	a.hedger = &hedger{policy: p}
+	return nil
+}
+
+// Hedging is an option to enable hedged requests, in order to reduce the latency induced by
+// the occasional very slow request. Hedged requests are reported in Stats.Hedges.
+func Hedging(policy HedgePolicy) interface {
+	AdapterOption
+} {
+	return hao{policy}
+}
+
+// observe records the time to first byte of a request
+func (h *hedger) observe(d time.Duration) {
+	if h.policy.Percentile == 0 {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.samples) < hedgeNumSamples {
+		h.samples = append(h.samples, d)
+	} else {
+		h.samples[h.next] = d
+	}
+	h.next = (h.next + 1) % hedgeNumSamples
+	if len(h.samples) >= hedgeMinSamples && h.next%(hedgeMinSamples/2) == 0 {
+		sorted := make([]time.Duration, len(h.samples))
+		copy(sorted, h.samples)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+		h.threshold = sorted[int(h.policy.Percentile*float64(len(sorted)))]
+	}
+}
+
+// delay returns the delay after which a request should be hedged
+func (h *hedger) delay() (time.Duration, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.threshold > 0 {
+		return h.threshold, true
+	}
+	return h.policy.Delay, h.policy.Delay > 0
+}
+
+// allow returns true if an additional hedged request does not exceed MaxRatio
+func (h *hedger) allow() bool {
+	hedges := atomic.AddUint64(&h.hedges, 1)
+	if float64(hedges) > h.policy.MaxRatio*float64(atomic.LoadUint64(&h.requests)) {
+		atomic.AddUint64(&h.hedges, ^uint64(0))
+		return false
+	}
+	return true
+}
+
+// hedgedBody cancels the context of the request once its body is closed
+type hedgedBody struct {
+	io.Reader
+	body   io.Closer
+	cancel context.CancelFunc
+}
+
+func (hb *hedgedBody) Close() error {
+	err := hb.body.Close()
+	hb.cancel()
+	return err
+}
+
+type hedgeResult struct {
+	r       io.ReadCloser
+	size    int64
+	version string
+	err     error
+	latency time.Duration
+	attempt int
+	cancel  context.CancelFunc
+}
+
+// streamAt calls the KeyStreamerAt, hedging the request if enabled
+func (a *Adapter) streamAt(ctx context.Context, key string, off int64, n int64, version string) (io.ReadCloser, int64, string, error) {
+	h := a.hedger
+	if h == nil {
+		return a.limitedStreamAt(ctx, key, off, n, version)
+	}
+	atomic.AddUint64(&h.requests, 1)
+	results := make(chan hedgeResult, 2)
+	cancels := []context.CancelFunc{}
+	launch := func() {
+		actx, cancel := context.WithCancel(ctx)
+		attempt := len(cancels)
+		cancels = append(cancels, cancel)
+		go func() {
+			st := time.Now()
+			r, size, v, err := a.limitedStreamAt(actx, key, off, n, version)
+			if r != nil {
+				//wait for the first byte
+				br := bufio.NewReader(r)
+				_, _ = br.Peek(1)
+				r = &hedgedBody{Reader: br, body: r, cancel: cancel}
+			}
+			results <- hedgeResult{r: r, size: size, version: v, err: err, latency: time.Since(st),
+				attempt: attempt, cancel: cancel}
+		}()
+	}
+	launch()
+	pending := 1
+	var timer <-chan time.Time
+	if delay, ok := h.delay(); ok {
+		t := time.NewTimer(delay)
+		defer t.Stop()
+		timer = t.C
+	}
+	var failed *hedgeResult
+	for {
+		select {
+		case <-timer:
+			timer = nil
+			if h.allow() {
+				a.count(key, func(c *statCounters) { atomic.AddUint64(&c.hedges, 1) })
+				launch()
+				pending++
+			}
+		case res := <-results:
+			pending--
+			if res.r == nil && res.err != nil {
+				res.cancel()
+				if failed == nil {
+					failed = &res
+				}
+				if pending > 0 {
+					continue
+				}
+				return nil, failed.size, failed.version, failed.err
+			}
+			h.observe(res.latency)
+			if pending > 0 {
+				//cancel the slower request, and discard its response
+				for i, cancel := range cancels {
+					if i != res.attempt {
+						cancel()
+					}
+				}
+				go func() {
+					if lost := <-results; lost.r != nil {
+						_ = lost.r.Close()
+					}
+				}()
+			}
+			return res.r, res.size, res.version, res.err
+		}
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright 2021 Airbus Defence and Space
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package osio
+
+import (
+	"context"
+	"io"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// SlowReader is a KeyStreamerAt whose requests at the offsets of slow take delay to answer,
+// unless cancelled. slow holds the number of slow requests for each offset
+type SlowReader struct {
+	TReader
+	delay     time.Duration
+	mu        sync.Mutex
+	slow      map[int64]int
+	cancelled int32
+}
+
+func (s *SlowReader) StreamAtContext(ctx context.Context, key string, off int64, n int64) (io.ReadCloser, int64, error) {
+	s.mu.Lock()
+	slow := s.slow[off] > 0
+	s.slow[off]--
+	s.mu.Unlock()
+	if slow {
+		select {
+		case <-time.After(s.delay):
+		case <-ctx.Done():
+			atomic.AddInt32(&s.cancelled, 1)
+			return nil, 0, ctx.Err()
+		}
+	}
+	time.Sleep(time.Millisecond)
+	return s.TReader.StreamAt(key, off, n)
+}
+
+func TestHedging(t *testing.T) {
+	for _, p := range []HedgePolicy{
+		{},
+		{Delay: -1},
+		{Delay: time.Millisecond, Percentile: 1},
+		{Delay: time.Millisecond, MaxRatio: 2},
+	} {
+		_, err := NewAdapter(rr, Hedging(p))
+		assert.Error(t, err)
+	}
+
+	data := make([]byte, 100)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	buf := make([]byte, 10)
+
+	//first request is slow, the hedged one answers
+	sr := &SlowReader{TReader: TReader{data}, delay: time.Second, slow: map[int64]int{20: 1}}
+	bc, _ := NewAdapter(sr, BlockSize("10"), Hedging(HedgePolicy{Delay: 10 * time.Millisecond, MaxRatio: 1}))
+	st := time.Now()
+	n, err := bc.ReadAt("key", buf, 20)
+	assert.NoError(t, err)
+	assert.Equal(t, data[20:30], buf[:n])
+	assert.Less(t, int64(time.Since(st)), int64(500*time.Millisecond))
+	assert.Equal(t, uint64(1), bc.Stats().Hedges)
+	assert.Eventually(t, func() bool { return atomic.LoadInt32(&sr.cancelled) == 1 }, time.Second, time.Millisecond)
+
+	//fast requests are not hedged
+	_, err = bc.ReadAt("key", buf, 40)
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(1), bc.Stats().Hedges)
+
+	//errors are not hedged
+	_, err = bc.ReadAt("enoent", buf, 0)
+	assert.Equal(t, syscall.ENOENT, err)
+	assert.Equal(t, uint64(1), bc.Stats().Hedges)
+
+	//extra load is capped: a single hedge allowed for 3 requests
+	sr = &SlowReader{TReader: TReader{data}, delay: 50 * time.Millisecond, slow: map[int64]int{0: 2, 10: 2, 20: 2}}
+	bc, _ = NewAdapter(sr, BlockSize("10"), SplitRanges(true),
+		Hedging(HedgePolicy{Delay: 10 * time.Millisecond, MaxRatio: 0.34}))
+	for i := int64(0); i < 3; i++ {
+		_, err = bc.ReadAt("key", buf, i*10)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, uint64(1), bc.Stats().Hedges)
+
+	//adaptive threshold: hedge when slower than the observed latencies
+	sr = &SlowReader{TReader: TReader{data}, delay: time.Second, slow: map[int64]int{0: 1, 30: 1}}
+	bc, _ = NewAdapter(sr, BlockSize("1"), SplitRanges(true),
+		Hedging(HedgePolicy{Percentile: 0.9, MaxRatio: 1}))
+	_, _ = bc.ReadAt("key", buf[:1], 0) //slow, not hedged
+	assert.Equal(t, uint64(0), bc.Stats().Hedges)
+	for i := int64(1); i < 30; i++ {
+		_, err = bc.ReadAt("key", buf[:1], i)
+		assert.NoError(t, err)
+	}
+	hedges := bc.Stats().Hedges
+	st = time.Now()
+	_, err = bc.ReadAt("key", buf[:1], 30) //slow, hedged
+	assert.NoError(t, err)
+	assert.Less(t, int64(time.Since(st)), int64(500*time.Millisecond))
+	assert.Equal(t, hedges+1, bc.Stats().Hedges)
+}
@@ -19,7 +19,10 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"strings"
 	"syscall"
+
+	"github.com/airbusgeo/osio/internal"
 )
 
 type Client interface {
@@ -75,6 +78,8 @@ func HTTPHandle(ctx context.Context, opts ...HTTPOption) (*HTTPHandler, error) {
 	return handler, nil
 }
 
+var _ KeyVersionStreamerAt = &HTTPHandler{}
+
 func handleResponse(r *http.Response) (io.ReadCloser, int64, error) {
 	if r.StatusCode == 404 {
 		return nil, -1, syscall.ENOENT
@@ -82,42 +87,116 @@ func handleResponse(r *http.Response) (io.ReadCloser, int64, error) {
 	if r.StatusCode == 416 {
 		return nil, 0, io.EOF
 	}
-	return nil, 0, fmt.Errorf("new reader for %s: status code %d", r.Request.URL.String(), r.StatusCode)
+	if r.StatusCode == 412 {
+		return nil, 0, fmt.Errorf("new reader for %s: %w", r.Request.URL.String(), ErrObjectChanged)
+	}
+	return nil, 0, &httpStatusError{url: r.Request.URL.String(), code: r.StatusCode}
+}
+
+// httpStatusError is returned for unexpected status codes. It is classified by DefaultRetryable
+// through its HTTPStatusCode method
+type httpStatusError struct {
+	url  string
+	code int
+}
+
+func (e *httpStatusError) Error() string {
+	return fmt.Sprintf("new reader for %s: status code %d", e.url, e.code)
 }
 
+func (e *httpStatusError) HTTPStatusCode() int {
+	return e.code
+}
+
+// httpVersion returns the strong ETag of the response, or its Last-Modified date if it
+// does not have one
+func httpVersion(h http.Header) string {
+	if etag := h.Get("ETag"); strings.HasPrefix(etag, `"`) {
+		return etag
+	}
+	return h.Get("Last-Modified")
+}
+
+// setVersion makes req conditional on the object being at the given version. As ETags are
+// quoted strings, they cannot be mistaken for a Last-Modified date
+func setVersion(req *http.Request, version string) {
+	if version == "" {
+		return
+	}
+	if strings.HasPrefix(version, `"`) {
+		req.Header.Set("If-Match", version)
+	} else {
+		req.Header.Set("If-Unmodified-Since", version)
+	}
+}
+
+// StreamAt implements KeyStreamerAt, using the context the handler was created with
 func (h *HTTPHandler) StreamAt(key string, off int64, n int64) (io.ReadCloser, int64, error) {
+	return h.StreamAtContext(h.ctx, key, off, n)
+}
+
+// StreamAtContext implements KeyStreamerAtContext
+func (h *HTTPHandler) StreamAtContext(ctx context.Context, key string, off int64, n int64) (io.ReadCloser, int64, error) {
+	r, size, _, err := h.StreamAtVersion(ctx, key, off, n, "")
+	return r, size, err
+}
+
+// StreamAtVersion implements KeyVersionStreamerAt. The version of an object is its ETag, or
+// its Last-Modified date if the server does not return strong ETags
+func (h *HTTPHandler) StreamAtVersion(ctx context.Context, key string, off int64, n int64, version string) (io.ReadCloser, int64, string, error) {
+	//requests are also canceled with the context the handler was created with
+	ctx, cancel := internal.MergeContext(ctx, h.ctx)
+	rc, size, version, err := h.streamAtVersion(ctx, key, off, n, version)
+	return internal.CancelOnClose(rc, cancel), size, version, err
+}
+
+func (h *HTTPHandler) streamAtVersion(ctx context.Context, key string, off int64, n int64, version string) (io.ReadCloser, int64, string, error) {
 	// HEAD request to get object size as it is not returned in range requests
 	var size int64
 	if off == 0 {
-		req, _ := http.NewRequestWithContext(h.ctx, "HEAD", key, nil)
+		req, _ := http.NewRequestWithContext(ctx, "HEAD", key, nil)
 		for _, mw := range h.requestMiddlewares {
 			mw(req)
 		}
+		setVersion(req, version)
 		r, err := h.client.Do(req)
 		if err != nil {
-			return nil, 0, fmt.Errorf("new reader for %s: %w", key, err)
+			return nil, 0, "", fmt.Errorf("new reader for %s: %w", key, err)
 		}
 		defer r.Body.Close()
 		if r.StatusCode != 200 {
-			return handleResponse(r)
+			rc, size, err := handleResponse(r)
+			return rc, size, "", err
 		}
 		size = r.ContentLength
+		if version == "" {
+			version = httpVersion(r.Header)
+		}
 	}
 
 	// GET request to fetch range
-	req, _ := http.NewRequestWithContext(h.ctx, "GET", key, nil)
+	req, _ := http.NewRequestWithContext(ctx, "GET", key, nil)
 	for _, mw := range h.requestMiddlewares {
 		mw(req)
 	}
 	req.Header.Add("Range", fmt.Sprintf("bytes=%d-%d", off, off+n-1))
+	setVersion(req, version)
 	r, err := h.client.Do(req)
 	if err != nil {
-		return nil, 0, fmt.Errorf("new reader for %s: %w", key, err)
+		return nil, 0, "", fmt.Errorf("new reader for %s: %w", key, err)
 	}
 	if r.StatusCode != 200 && r.StatusCode != 206 {
-		return handleResponse(r)
+		r.Body.Close()
+		rc, size, err := handleResponse(r)
+		if err == io.EOF {
+			return rc, size, version, err
+		}
+		return rc, size, "", err
+	}
+	if version == "" {
+		version = httpVersion(r.Header)
 	}
-	return r.Body, size, err
+	return r.Body, size, version, err
 }
 
 func (h *HTTPHandler) ReadAt(key string, p []byte, off int64) (int, int64, error) {
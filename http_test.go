@@ -16,16 +16,46 @@ package osio
 
 import (
 	"context"
+	"flag"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
 	"syscall"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
 
+var record = flag.Bool("record", false, "record the test fixtures against the live backends")
+
+// fixtureHandler returns a Replayer serving the fixtures testdata/<name>.json, or a Recorder
+// around live saving them once the test is done if the tests are run with -record
+func fixtureHandler(t *testing.T, name string, live func() KeyStreamerAt) KeyStreamerAt {
+	fname := filepath.Join("testdata", name+".json")
+	if !*record {
+		rp, err := LoadReplayer(fname, StrictReplay())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rp
+	}
+	rec := NewRecorder(live())
+	t.Cleanup(func() {
+		if err := rec.Save(fname); err != nil {
+			t.Error(err)
+		}
+	})
+	return rec
+}
+
 func TestHTTP(t *testing.T) {
-	ctx := context.Background()
-	hh, _ := HTTPHandle(ctx)
-	httpa, _ := NewAdapter(hh)
+	httpa, _ := NewAdapter(fixtureHandler(t, "http", func() KeyStreamerAt {
+		hh, _ := HTTPHandle(context.Background())
+		return hh
+	}), BlockSize("256"))
 
 	// bucket not found
 	_, err := httpa.Reader("https://storage.googleapis.com/godal-ci-data-public/doesnotexist.tif")
@@ -43,3 +73,81 @@ func TestHTTP(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, int64(212), r.Size())
 }
+
+func TestHTTPVersion(t *testing.T) {
+	content := "aaaabbbbcccc"
+	etag := `"v1"`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if etag != "" {
+			w.Header().Set("ETag", etag)
+			if im := r.Header.Get("If-Match"); im != "" && im != etag {
+				w.WriteHeader(412)
+				return
+			}
+		} else {
+			w.Header().Set("Last-Modified", "Mon, 02 Jan 2006 15:04:05 GMT")
+			if ius := r.Header.Get("If-Unmodified-Since"); ius != "" && ius != "Mon, 02 Jan 2006 15:04:05 GMT" {
+				w.WriteHeader(412)
+				return
+			}
+		}
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader(content))
+	}))
+	defer srv.Close()
+
+	hh, _ := HTTPHandle(context.Background(), HTTPClient(srv.Client()))
+	httpa, _ := NewAdapter(hh, BlockSize("4"))
+	r, err := httpa.Reader(srv.URL + "/file")
+	assert.NoError(t, err)
+	assert.Equal(t, `"v1"`, r.Version())
+	buf := make([]byte, 4)
+	_, err = r.ReadAt(buf, 4)
+	assert.NoError(t, err)
+	assert.Equal(t, "bbbb", string(buf))
+
+	content = "ddddeeeeffff"
+	etag = `"v2"`
+	_, err = r.ReadAt(buf, 8)
+	assert.ErrorIs(t, err, ErrObjectChanged)
+	r, err = httpa.Reader(srv.URL + "/file")
+	assert.NoError(t, err)
+	assert.Equal(t, `"v2"`, r.Version())
+	_, err = r.ReadAt(buf, 8)
+	assert.NoError(t, err)
+	assert.Equal(t, "ffff", string(buf))
+
+	//fallback to last-modified
+	etag = ""
+	httpa, _ = NewAdapter(hh, BlockSize("4"))
+	r, err = httpa.Reader(srv.URL + "/file")
+	assert.NoError(t, err)
+	assert.Equal(t, "Mon, 02 Jan 2006 15:04:05 GMT", r.Version())
+	_, err = r.ReadAt(buf, 4)
+	assert.NoError(t, err)
+	assert.Equal(t, "eeee", string(buf))
+}
+
+func TestHTTPHandlerContext(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader("aaaabbbbcccc"))
+	}))
+	defer srv.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	hh, _ := HTTPHandle(ctx, HTTPClient(srv.Client()))
+	mux := NewMux()
+	_ = mux.Register("http", hh)
+	r, _, err := mux.StreamAt(srv.URL+"/file", 4, 4)
+	assert.NoError(t, err)
+	buf, _ := io.ReadAll(r)
+	assert.Equal(t, "bbbb", string(buf))
+	r.Close()
+
+	//the context of the handler cancels the requests made through wrappers and adapters
+	cancel()
+	_, _, err = mux.StreamAt(srv.URL+"/file", 4, 4)
+	assert.ErrorIs(t, err, context.Canceled)
+	a, _ := NewAdapter(mux, BlockSize("4"))
+	_, err = a.ReadAt(srv.URL+"/file", make([]byte, 4), 4)
+	assert.ErrorIs(t, err, context.Canceled)
+}
@@ -0,0 +1,45 @@
+package internal
+
+import (
+	"context"
+	"io"
+)
+
+// MergeContext returns a context carrying the values of ctx, that is done as soon as ctx or
+// hctx is done, and the function releasing its resources. It is used by handlers to honor the
+// context they were created with for requests made with another context.
+func MergeContext(ctx, hctx context.Context) (context.Context, context.CancelFunc) {
+	if hctx == nil || hctx.Done() == nil || hctx == ctx {
+		return ctx, func() {}
+	}
+	mctx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-hctx.Done():
+			cancel()
+		case <-mctx.Done():
+		}
+	}()
+	return mctx, cancel
+}
+
+type cancelReadCloser struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (r cancelReadCloser) Close() error {
+	err := r.ReadCloser.Close()
+	r.cancel()
+	return err
+}
+
+// CancelOnClose returns a ReadCloser calling cancel once r is closed, or calls cancel right
+// away if r is nil
+func CancelOnClose(r io.ReadCloser, cancel context.CancelFunc) io.ReadCloser {
+	if r == nil {
+		cancel()
+		return nil
+	}
+	return cancelReadCloser{r, cancel}
+}
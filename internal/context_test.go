@@ -0,0 +1,48 @@
+package internal
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type ctxKey struct{}
+
+func TestMergeContext(t *testing.T) {
+	bg := context.Background()
+	ctx, cancel := MergeContext(bg, bg)
+	assert.Equal(t, bg, ctx)
+	cancel()
+
+	hctx, hcancel := context.WithCancel(bg)
+	ctx, cancel = MergeContext(context.WithValue(bg, ctxKey{}, "v"), hctx)
+	assert.Equal(t, "v", ctx.Value(ctxKey{}))
+	assert.NoError(t, ctx.Err())
+	hcancel()
+	<-ctx.Done()
+	assert.ErrorIs(t, ctx.Err(), context.Canceled)
+	cancel()
+
+	cctx, ccancel := context.WithCancel(bg)
+	ctx, cancel = MergeContext(cctx, context.TODO())
+	assert.Equal(t, cctx, ctx)
+	ccancel()
+	cancel()
+
+	hctx, hcancel = context.WithCancel(bg)
+	defer hcancel()
+	ctx, cancel = MergeContext(bg, hctx)
+	r := CancelOnClose(ioutil.NopCloser(strings.NewReader("abc")), cancel)
+	_, _ = io.ReadAll(r)
+	assert.NoError(t, ctx.Err())
+	r.Close()
+	assert.ErrorIs(t, ctx.Err(), context.Canceled)
+
+	ctx, cancel = MergeContext(bg, hctx)
+	assert.Nil(t, CancelOnClose(nil, cancel))
+	assert.ErrorIs(t, ctx.Err(), context.Canceled)
+}
@@ -33,3 +33,31 @@ func BucketObject(input string) (string, string, error) {
 	}
 	return input[:sep], input[sep+1:], nil
 }
+
+// BucketPrefix splits a listing prefix into a bucket and an object prefix, which may be empty
+func BucketPrefix(input string) (string, string, error) {
+	schemeIdx := strings.Index(input, "://")
+	if schemeIdx >= 0 && isSheme(input[:schemeIdx]) {
+		input = input[schemeIdx+3:]
+	}
+	input = strings.TrimLeft(input, "/")
+	sep := strings.Index(input, "/")
+	if sep == -1 {
+		sep = len(input)
+		input += "/"
+	}
+	if sep == 0 {
+		return "", "", fmt.Errorf("not a bucket/prefix string")
+	}
+	return input[:sep], input[sep+1:], nil
+}
+
+// KeyBase returns the part of a listing prefix that precedes the object prefix, ending with a
+// "/", so that listed object names can be turned back into keys
+func KeyBase(input, objectPrefix string) string {
+	base := strings.TrimSuffix(input, objectPrefix)
+	if !strings.HasSuffix(base, "/") {
+		base += "/"
+	}
+	return base
+}
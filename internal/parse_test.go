@@ -59,3 +59,33 @@ func TestBucketObjectParsing(t *testing.T) {
 	_, _, err = BucketObject("s3:///bucket")
 	assert.Error(t, err)
 }
+
+func TestBucketPrefixParsing(t *testing.T) {
+	b, p, err := BucketPrefix("s3://bucket/prefix/a")
+	assert.NoError(t, err)
+	assert.Equal(t, "bucket", b)
+	assert.Equal(t, "prefix/a", p)
+	assert.Equal(t, "s3://bucket/", KeyBase("s3://bucket/prefix/a", p))
+
+	b, p, err = BucketPrefix("gs://bucket/")
+	assert.NoError(t, err)
+	assert.Equal(t, "bucket", b)
+	assert.Equal(t, "", p)
+	assert.Equal(t, "gs://bucket/", KeyBase("gs://bucket/", p))
+
+	b, p, err = BucketPrefix("gs://bucket")
+	assert.NoError(t, err)
+	assert.Equal(t, "bucket", b)
+	assert.Equal(t, "", p)
+	assert.Equal(t, "gs://bucket/", KeyBase("gs://bucket", p))
+
+	b, p, err = BucketPrefix("/bucket/dir/")
+	assert.NoError(t, err)
+	assert.Equal(t, "bucket", b)
+	assert.Equal(t, "dir/", p)
+
+	_, _, err = BucketPrefix("s3://")
+	assert.Error(t, err)
+	_, _, err = BucketPrefix("")
+	assert.Error(t, err)
+}
@@ -0,0 +1,201 @@
+// Copyright 2021 Airbus Defence and Space
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package osio
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// fifoSemaphore is a counting semaphore granting its slots in arrival order
+type fifoSemaphore struct {
+	mu      sync.Mutex
+	free    int
+	waiters list.List
+}
+
+func newFifoSemaphore(n int) *fifoSemaphore {
+	return &fifoSemaphore{free: n}
+}
+
+// acquire takes a slot, waiting until one is released if needed. It returns true if it had
+// to wait.
+func (s *fifoSemaphore) acquire(ctx context.Context) (bool, error) {
+	s.mu.Lock()
+	if s.free > 0 && s.waiters.Len() == 0 {
+		s.free--
+		s.mu.Unlock()
+		return false, nil
+	}
+	ready := make(chan struct{})
+	el := s.waiters.PushBack(ready)
+	s.mu.Unlock()
+	select {
+	case <-ready:
+		return true, nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		select {
+		case <-ready:
+			//the slot was granted concurrently, give it back
+			s.mu.Unlock()
+			s.release()
+		default:
+			s.waiters.Remove(el)
+			s.mu.Unlock()
+		}
+		return true, ctx.Err()
+	}
+}
+
+// release hands the slot to the first waiter, or frees it
+func (s *fifoSemaphore) release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if front := s.waiters.Front(); front != nil {
+		close(s.waiters.Remove(front).(chan struct{}))
+		return
+	}
+	s.free++
+}
+
+type prefixLimiter struct {
+	prefix string
+	sem    *fifoSemaphore
+}
+
+type mcao struct {
+	prefixed bool
+	prefix   string
+	n        int
+}
+
+func (m mcao) adapterOpt(a *Adapter) error {
+	if m.n <= 0 {
+		return fmt.Errorf("max concurrent requests must be > 0")
+	}
+	if m.prefixed && m.prefix == "" {
+		return fmt.Errorf("prefix must not be empty")
+	}
+	if !m.prefixed {
+		a.limiter = newFifoSemaphore(m.n)
+		return nil
+	}
+	for _, pl := range a.prefixLimiters {
+		if pl.prefix == m.prefix {
+			return fmt.Errorf("duplicate concurrency limit for prefix %s", m.prefix)
+		}
+	}
+	a.prefixLimiters = append(a.prefixLimiters, prefixLimiter{prefix: m.prefix, sem: newFifoSemaphore(m.n)})
+	sort.SliceStable(a.prefixLimiters, func(i, j int) bool {
+		return len(a.prefixLimiters[i].prefix) > len(a.prefixLimiters[j].prefix)
+	})
+	return nil
+}
+
+// MaxConcurrentRequests is an option to limit the number of requests to the KeyStreamerAt
+// that may be in flight at any given time, a request being in flight until its stream has been
+// closed. Requests exceeding the limit are queued and served in arrival order. The time spent
+// in the queue is reported in Stats.QueueWaitTime.
+func MaxConcurrentRequests(n int) interface {
+	AdapterOption
+} {
+	return mcao{n: n}
+}
+
+// MaxConcurrentPrefixRequests is an option to limit the number of in flight requests for the
+// keys starting with prefix (e.g. "s3://" or "gs://bucket/"). It may be passed multiple times
+// for different prefixes, a key being limited by the longest matching prefix only. Requests
+// are additionally subject to the global MaxConcurrentRequests limit.
+func MaxConcurrentPrefixRequests(prefix string, n int) interface {
+	AdapterOption
+} {
+	return mcao{prefixed: true, prefix: prefix, n: n}
+}
+
+// acquireSlots takes a slot of the limiters applying to key, and returns the function that
+// releases them
+func (a *Adapter) acquireSlots(ctx context.Context, key string) (func(), error) {
+	sems := make([]*fifoSemaphore, 0, 2)
+	for _, pl := range a.prefixLimiters {
+		if strings.HasPrefix(key, pl.prefix) {
+			sems = append(sems, pl.sem)
+			break
+		}
+	}
+	if a.limiter != nil {
+		sems = append(sems, a.limiter)
+	}
+	release := func(sems []*fifoSemaphore) {
+		for _, s := range sems {
+			s.release()
+		}
+	}
+	st := time.Now()
+	waited := false
+	for i, s := range sems {
+		w, err := s.acquire(ctx)
+		waited = waited || w
+		if err != nil {
+			release(sems[:i])
+			return nil, err
+		}
+	}
+	if waited {
+		wait := time.Since(st)
+		a.count(key, func(c *statCounters) {
+			atomic.AddUint64(&c.queueWaits, 1)
+			atomic.AddUint64(&c.queueWaitNanos, uint64(wait))
+		})
+	}
+	once := sync.Once{}
+	return func() { once.Do(func() { release(sems) }) }, nil
+}
+
+// limitedStreamAt calls the KeyStreamerAt once a slot is available, and keeps the slot until
+// the returned stream is closed
+func (a *Adapter) limitedStreamAt(ctx context.Context, key string, off int64, n int64, version string) (io.ReadCloser, int64, string, error) {
+	if a.limiter == nil && len(a.prefixLimiters) == 0 {
+		return StreamAtVersion(ctx, a.keyStreamer, key, off, n, version)
+	}
+	release, err := a.acquireSlots(ctx, key)
+	if err != nil {
+		return nil, 0, "", err
+	}
+	r, size, v, err := StreamAtVersion(ctx, a.keyStreamer, key, off, n, version)
+	if r == nil {
+		release()
+		return r, size, v, err
+	}
+	return releasingReadCloser{ReadCloser: r, release: release}, size, v, err
+}
+
+type releasingReadCloser struct {
+	io.ReadCloser
+	release func()
+}
+
+func (rr releasingReadCloser) Close() error {
+	err := rr.ReadCloser.Close()
+	rr.release()
+	return err
+}
@@ -0,0 +1,161 @@
+// Copyright 2021 Airbus Defence and Space
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package osio
+
+import (
+	"context"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// PReader is a KeyStreamerAt tracking the maximum number of concurrently open streams
+type PReader struct {
+	TReader
+	delay   time.Duration
+	open    int32
+	maxOpen int32
+}
+
+type pStream struct {
+	io.ReadCloser
+	p *PReader
+}
+
+func (s pStream) Close() error {
+	atomic.AddInt32(&s.p.open, -1)
+	return s.ReadCloser.Close()
+}
+
+func (p *PReader) StreamAt(key string, off int64, n int64) (io.ReadCloser, int64, error) {
+	open := atomic.AddInt32(&p.open, 1)
+	for {
+		max := atomic.LoadInt32(&p.maxOpen)
+		if open <= max || atomic.CompareAndSwapInt32(&p.maxOpen, max, open) {
+			break
+		}
+	}
+	time.Sleep(p.delay)
+	r, size, err := p.TReader.StreamAt(key, off, n)
+	if r == nil {
+		atomic.AddInt32(&p.open, -1)
+		return r, size, err
+	}
+	return pStream{ReadCloser: r, p: p}, size, err
+}
+
+func TestFifoSemaphore(t *testing.T) {
+	s := newFifoSemaphore(1)
+	waited, err := s.acquire(context.Background())
+	assert.False(t, waited)
+	assert.NoError(t, err)
+
+	//waiters are served in order
+	order := make(chan int, 3)
+	wg := sync.WaitGroup{}
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			waited, err := s.acquire(context.Background())
+			assert.True(t, waited)
+			assert.NoError(t, err)
+			order <- i
+			s.release()
+		}(i)
+		time.Sleep(5 * time.Millisecond)
+	}
+	//cancelled waiter
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+	defer cancel()
+	_, err = s.acquire(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	s.release()
+	wg.Wait()
+	close(order)
+	got := []int{}
+	for i := range order {
+		got = append(got, i)
+	}
+	assert.Equal(t, []int{0, 1, 2}, got)
+	assert.Equal(t, 1, s.free)
+	assert.Equal(t, 0, s.waiters.Len())
+}
+
+func TestMaxConcurrentRequests(t *testing.T) {
+	_, err := NewAdapter(rr, MaxConcurrentRequests(0))
+	assert.Error(t, err)
+	_, err = NewAdapter(rr, MaxConcurrentPrefixRequests("", 1))
+	assert.Error(t, err)
+	_, err = NewAdapter(rr, MaxConcurrentPrefixRequests("a", 1), MaxConcurrentPrefixRequests("a", 2))
+	assert.Error(t, err)
+
+	data := make([]byte, 1000)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	//scattered offsets
+	bufs := make([][]byte, 20)
+	offs := make([]int64, 20)
+	for i := range bufs {
+		bufs[i] = make([]byte, 5)
+		offs[i] = int64(i) * 50
+	}
+	read := func(bc *Adapter, key string) {
+		_, err := bc.ReadAtMulti(key, bufs, offs)
+		assert.NoError(t, err)
+		for i := range bufs {
+			assert.Equal(t, data[offs[i]:offs[i]+5], bufs[i])
+		}
+	}
+
+	p := &PReader{TReader: TReader{data}, delay: 5 * time.Millisecond}
+	bc, _ := NewAdapter(p, BlockSize("10"))
+	read(bc, "key")
+	assert.Greater(t, atomic.LoadInt32(&p.maxOpen), int32(3))
+
+	p = &PReader{TReader: TReader{data}, delay: 5 * time.Millisecond}
+	bc, _ = NewAdapter(p, BlockSize("10"), MaxConcurrentRequests(3))
+	read(bc, "key")
+	assert.Equal(t, int32(3), atomic.LoadInt32(&p.maxOpen))
+	st := bc.Stats()
+	assert.Greater(t, st.QueueWaits, uint64(0))
+	assert.Greater(t, int64(st.QueueWaitTime), int64(0))
+
+	//per prefix limit
+	p = &PReader{TReader: TReader{data}, delay: 5 * time.Millisecond}
+	bc, _ = NewAdapter(p, BlockSize("10"), MaxConcurrentRequests(10),
+		MaxConcurrentPrefixRequests("s3://", 4), MaxConcurrentPrefixRequests("s3://slow/", 2))
+	read(bc, "s3://slow/key")
+	assert.Equal(t, int32(2), atomic.LoadInt32(&p.maxOpen))
+	atomic.StoreInt32(&p.maxOpen, 0)
+	read(bc, "s3://bucket/key")
+	assert.Equal(t, int32(4), atomic.LoadInt32(&p.maxOpen))
+	atomic.StoreInt32(&p.maxOpen, 0)
+	read(bc, "gs://bucket/key")
+	assert.Equal(t, int32(10), atomic.LoadInt32(&p.maxOpen))
+
+	//queued requests are cancelled with their context
+	p = &PReader{TReader: TReader{data}, delay: 50 * time.Millisecond}
+	bc, _ = NewAdapter(p, BlockSize("10"), MaxConcurrentRequests(1))
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = bc.ReadAtMultiContext(ctx, "key", bufs, offs)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
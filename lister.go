@@ -0,0 +1,173 @@
+// Copyright 2021 Airbus Defence and Space
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package osio
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrListingNotSupported is returned when listing the objects of a KeyStreamerAt that does not
+// implement KeyLister
+var ErrListingNotSupported = errors.New("listing not supported")
+
+// ObjectAttrs describes an object, or a common prefix, returned by a KeyLister
+type ObjectAttrs struct {
+	// Key is the full key of the object, or the common prefix including its trailing delimiter
+	Key string
+	// Size is the size of the object
+	Size int64
+	// Version identifies the content of the object, as returned by KeyVersionStreamerAt
+	Version string
+	// ModTime is the last modification time of the object, if known
+	ModTime time.Time
+	// Prefix is set for common prefixes, i.e. "directories"
+	Prefix bool
+}
+
+// ObjectIterator iterates over listed objects
+type ObjectIterator interface {
+	// Next returns the next object. It returns io.EOF once all the objects have been returned.
+	Next() (ObjectAttrs, error)
+}
+
+// KeyLister is an optional interface that can be implemented by a KeyStreamerAt to enumerate
+// the objects it serves.
+type KeyLister interface {
+	// ListObjects returns an iterator over the objects whose key starts with prefix, in
+	// lexicographic order. If delimiter is not empty, the keys containing delimiter after
+	// prefix are not returned, but grouped into a single common prefix ending with the first
+	// delimiter. The keys of the returned objects can be passed to StreamAt.
+	ListObjects(ctx context.Context, prefix, delimiter string) ObjectIterator
+}
+
+// ListingChecker is an optional interface implemented by KeyListers that wrap or route to other
+// KeyStreamerAts, and can therefore only list the objects of some prefixes.
+type ListingChecker interface {
+	// CanList returns whether the objects whose key starts with prefix can be listed
+	CanList(prefix string) bool
+}
+
+// CanList returns whether the objects of ks whose key starts with prefix can be listed, i.e. if
+// ks implements KeyLister and, if it also implements ListingChecker, ks.CanList(prefix)
+func CanList(ks KeyStreamerAt, prefix string) bool {
+	if _, ok := ks.(KeyLister); !ok {
+		return false
+	}
+	if lc, ok := ks.(ListingChecker); ok {
+		return lc.CanList(prefix)
+	}
+	return true
+}
+
+// ListObjects calls ks.ListObjects if ks implements KeyLister, or returns an iterator failing
+// with ErrListingNotSupported otherwise
+func ListObjects(ctx context.Context, ks KeyStreamerAt, prefix, delimiter string) ObjectIterator {
+	if kl, ok := ks.(KeyLister); ok {
+		return kl.ListObjects(ctx, prefix, delimiter)
+	}
+	return ErrorIterator(ErrListingNotSupported)
+}
+
+type errorIterator struct {
+	err error
+}
+
+func (it errorIterator) Next() (ObjectAttrs, error) {
+	return ObjectAttrs{}, it.err
+}
+
+// ErrorIterator returns an ObjectIterator whose Next always fails with err
+func ErrorIterator(err error) ObjectIterator {
+	return errorIterator{err}
+}
+
+type sliceIterator []ObjectAttrs
+
+func (it *sliceIterator) Next() (ObjectAttrs, error) {
+	if len(*it) == 0 {
+		return ObjectAttrs{}, io.EOF
+	}
+	attrs := (*it)[0]
+	*it = (*it)[1:]
+	return attrs, nil
+}
+
+// SliceIterator returns an ObjectIterator over the objects whose key starts with prefix among
+// objects, grouping them into common prefixes if delimiter is not empty. It can be used to
+// implement KeyLister for backends that cannot paginate their listings.
+func SliceIterator(objects []ObjectAttrs, prefix, delimiter string) ObjectIterator {
+	sorted := make([]ObjectAttrs, 0, len(objects))
+	for _, o := range objects {
+		if strings.HasPrefix(o.Key, prefix) {
+			sorted = append(sorted, o)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Key < sorted[j].Key })
+	it := sliceIterator{}
+	for _, o := range sorted {
+		if delimiter != "" {
+			if idx := strings.Index(o.Key[len(prefix):], delimiter); idx >= 0 {
+				p := o.Key[:len(prefix)+idx+len(delimiter)]
+				if len(it) == 0 || it[len(it)-1].Key != p {
+					it = append(it, ObjectAttrs{Key: p, Prefix: true})
+				}
+				continue
+			}
+		}
+		it = append(it, o)
+	}
+	return &it
+}
+
+// ListObjects lists the objects of the KeyStreamerAt of the Adapter, which must implement
+// KeyLister. The sizes and versions of the listed objects are added to the size cache, so
+// that opening them does not require an additional request to the source.
+func (a *Adapter) ListObjects(ctx context.Context, prefix, delimiter string) ObjectIterator {
+	return &cachingIterator{a: a, it: ListObjects(ctx, a.keyStreamer, prefix, delimiter)}
+}
+
+type cachingIterator struct {
+	a  *Adapter
+	it ObjectIterator
+}
+
+func (ci *cachingIterator) Next() (ObjectAttrs, error) {
+	attrs, err := ci.it.Next()
+	if err == nil && !attrs.Prefix {
+		ci.a.addListed(attrs)
+	}
+	return attrs, err
+}
+
+// addListed records the size and version of a listed object
+func (a *Adapter) addListed(attrs ObjectAttrs) {
+	if oi, ok := a.objectInfo(attrs.Key); ok {
+		if oi.size == attrs.Size && oi.version == attrs.Version {
+			if oi.modTime.IsZero() {
+				oi.modTime = attrs.ModTime
+				a.sizeCache.Add(attrs.Key, oi)
+			}
+			return
+		}
+		//the object changed since it was cached
+		a.invalidate(attrs.Key, "")
+	}
+	a.sizeCache.Add(attrs.Key, objectInfo{size: attrs.Size, version: attrs.Version, modTime: attrs.ModTime})
+}
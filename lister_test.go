@@ -0,0 +1,156 @@
+// Copyright 2021 Airbus Defence and Space
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package osio
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func listKeys(t *testing.T, it ObjectIterator) []string {
+	keys := []string{}
+	for {
+		attrs, err := it.Next()
+		if err == io.EOF {
+			return keys
+		}
+		if !assert.NoError(t, err) {
+			return keys
+		}
+		keys = append(keys, attrs.Key)
+	}
+}
+
+func TestSliceIterator(t *testing.T) {
+	objects := []ObjectAttrs{
+		{Key: "b/c/d"}, {Key: "a"}, {Key: "b/a"}, {Key: "b/c/e"}, {Key: "b.txt"}, {Key: "c/"},
+	}
+	assert.Equal(t, []string{"a", "b.txt", "b/a", "b/c/d", "b/c/e", "c/"},
+		listKeys(t, SliceIterator(objects, "", "")))
+	assert.Equal(t, []string{"a", "b.txt", "b/", "c/"},
+		listKeys(t, SliceIterator(objects, "", "/")))
+	assert.Equal(t, []string{"b/a", "b/c/"},
+		listKeys(t, SliceIterator(objects, "b/", "/")))
+	assert.Equal(t, []string{"b/c/d", "b/c/e"},
+		listKeys(t, SliceIterator(objects, "b/c", "")))
+	it := SliceIterator(objects, "b/", "/")
+	_, _ = it.Next()
+	attrs, _ := it.Next()
+	assert.True(t, attrs.Prefix)
+}
+
+func TestAdapterList(t *testing.T) {
+	_, err := ListObjects(context.Background(), rr, "", "").Next()
+	assert.True(t, errors.Is(err, ErrListingNotSupported))
+
+	ls := &listStore{replicaStore{objects: map[string][]byte{
+		"gs://bucket/a":   []byte("aaaa"),
+		"gs://bucket/b/c": []byte("cc"),
+	}}}
+	a, _ := NewAdapter(ls, BlockSize("4"))
+	r, err := a.Reader("gs://bucket/a")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), r.Size())
+	assert.Len(t, ls.reset(), 1)
+
+	ls.objects["gs://bucket/a"] = []byte("aaaaaa")
+	assert.Equal(t, []string{"gs://bucket/a", "gs://bucket/b/c"},
+		listKeys(t, a.ListObjects(context.Background(), "gs://bucket/", "")))
+	assert.Empty(t, ls.reset())
+
+	//listed sizes are served from the size cache, changed objects are invalidated
+	r, err = a.Reader("gs://bucket/a")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(6), r.Size())
+	size, err := a.Size("gs://bucket/b/c")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), size)
+	assert.Empty(t, ls.reset())
+	buf := make([]byte, 6)
+	_, err = r.ReadAt(buf, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "aaaaaa", string(buf))
+
+	//mux
+	mux := NewMux()
+	_ = mux.Register("gs://", ls)
+	_ = mux.Register("https://", rr)
+	assert.Equal(t, []string{"gs://bucket/b/c"},
+		listKeys(t, mux.ListObjects(context.Background(), "gs://bucket/b", "")))
+	_, err = mux.ListObjects(context.Background(), "https://host/", "").Next()
+	assert.True(t, errors.Is(err, ErrListingNotSupported))
+	_, err = mux.ListObjects(context.Background(), "s3://bucket/", "").Next()
+	assert.True(t, errors.Is(err, ErrUnknownScheme))
+
+	assert.False(t, CanList(rr, ""))
+	assert.True(t, CanList(ls, ""))
+	assert.True(t, CanList(mux, "gs://bucket/"))
+	assert.False(t, CanList(mux, "https://host/"))
+	assert.False(t, CanList(mux, "s3://bucket/"))
+}
+
+func TestListWrappers(t *testing.T) {
+	ctx := context.Background()
+	ls := &listStore{replicaStore{objects: map[string][]byte{
+		"gs://bucket/a":   []byte("aaaa"),
+		"gs://bucket/b/c": []byte("cc"),
+	}}}
+	mux := NewMux()
+	_ = mux.Register("gs://", ls)
+	_ = mux.Register("https://", rr)
+
+	limiter, _ := NewRateLimiter(mux, RateLimit{Requests: 100})
+	breaker, _ := NewCircuitBreaker(mux)
+	faulty, _ := NewFaultInjector(mux, FaultScript(Fault{Kind: FaultTemporaryError}))
+	for _, ks := range []KeyStreamerAt{limiter, breaker, faulty, NewRecorder(mux)} {
+		assert.Equal(t, []string{"gs://bucket/a", "gs://bucket/b/"},
+			listKeys(t, ListObjects(ctx, ks, "gs://bucket/", "/")))
+		assert.True(t, CanList(ks, "gs://bucket/"))
+		assert.False(t, CanList(ks, "https://host/"))
+		_, err := ListObjects(ctx, ks, "https://host/", "").Next()
+		assert.True(t, errors.Is(err, ErrListingNotSupported))
+	}
+
+	limiter, _ = NewRateLimiter(mux, RateLimit{Requests: 1})
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	_, _ = limiter.ListObjects(ctx, "gs://bucket/", "").Next()
+	_, err := limiter.ListObjects(cctx, "gs://bucket/", "").Next()
+	assert.Error(t, err)
+
+	breaker, _ = NewCircuitBreaker(&failingStreamer{err: tempErr{}}, FailureThreshold(1))
+	_, _, _ = breaker.StreamAt("gs://bucket/a", 0, 1)
+	_, err = breaker.ListObjects(ctx, "gs://bucket/", "").Next()
+	assert.True(t, errors.Is(err, ErrCircuitOpen))
+
+	//mirrors list the first replica that can be listed, and map the keys back
+	m, _ := NewMirror(mux, Replicas("data/", "https://host/", "gs://bucket/"))
+	assert.True(t, CanList(m, "data/"))
+	assert.Equal(t, []string{"data/a", "data/b/"}, listKeys(t, m.ListObjects(ctx, "data/", "/")))
+	assert.Equal(t, []string{"data/b/c"}, listKeys(t, m.ListObjects(ctx, "data/b", "")))
+	assert.Equal(t, []string{"gs://bucket/a"}, listKeys(t, m.ListObjects(ctx, "gs://bucket/a", "")))
+	m, _ = NewMirror(mux, Replicas("data/", "https://host/"))
+	assert.False(t, CanList(m, "data/"))
+	_, err = m.ListObjects(ctx, "data/", "").Next()
+	assert.True(t, errors.Is(err, ErrListingNotSupported))
+	m, _ = NewMirror(mux, ReplicaMapper(func(key string) []string { return []string{key} }))
+	assert.False(t, CanList(m, "gs://bucket/"))
+	_, err = m.ListObjects(ctx, "gs://bucket/", "").Next()
+	assert.True(t, errors.Is(err, ErrListingNotSupported))
+}
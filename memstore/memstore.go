@@ -0,0 +1,273 @@
+// Copyright 2021 Airbus Defence and Space
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package memstore provides an in-memory object store implementing osio.KeyStreamerAt, to
+// unit-test code built on an osio.Adapter without a real backend.
+//
+//	store := memstore.New(memstore.WithLatency(10 * time.Millisecond))
+//	store.Put("bucket/object", data)
+//	store.SetError("bucket/broken", errors.New("boom"))
+//	adapter, _ := osio.NewAdapter(store)
+//	...
+//	requests := store.Requests()
+package memstore
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/airbusgeo/osio"
+)
+
+// Request is a request received by a Store
+type Request struct {
+	Key     string
+	Offset  int64
+	Length  int64
+	Version string
+	// Err is the error returned to the caller, if any
+	Err error
+}
+
+type object struct {
+	data    []byte
+	version string
+}
+
+// Store is a concurrency-safe in-memory bucket. Objects are versioned, the version of an
+// object changing each time it is overwritten.
+type Store struct {
+	mu         sync.Mutex
+	objects    map[string]object
+	errs       map[string]error
+	latency    time.Duration
+	requests   []Request
+	generation int64
+}
+
+var (
+	_ osio.KeyVersionStreamerAt = &Store{}
+	_ osio.KeyLister            = &Store{}
+)
+
+// Option is an option that can be passed to New
+type Option func(s *Store)
+
+// WithLatency delays every request by d
+func WithLatency(d time.Duration) Option {
+	return func(s *Store) {
+		s.latency = d
+	}
+}
+
+// WithObjects populates the store with the given objects
+func WithObjects(objects map[string][]byte) Option {
+	return func(s *Store) {
+		for k, v := range objects {
+			s.put(k, v)
+		}
+	}
+}
+
+// New creates an empty Store
+func New(opts ...Option) *Store {
+	s := &Store{
+		objects: make(map[string]object),
+		errs:    make(map[string]error),
+	}
+	for _, o := range opts {
+		o(s)
+	}
+	return s
+}
+
+// must be called with s.mu held
+func (s *Store) put(key string, data []byte) {
+	s.generation++
+	s.objects[key] = object{
+		data:    append([]byte(nil), data...),
+		version: strconv.FormatInt(s.generation, 10),
+	}
+}
+
+// Put creates or replaces the object key with a copy of data
+func (s *Store) Put(key string, data []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.put(key, data)
+}
+
+// Overwrite replaces the content of the existing object key, so that readers of its previous
+// version get osio.ErrObjectChanged. It returns syscall.ENOENT if the object does not exist.
+func (s *Store) Overwrite(key string, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.objects[key]; !ok {
+		return syscall.ENOENT
+	}
+	s.put(key, data)
+	return nil
+}
+
+// Delete removes the object key. It returns syscall.ENOENT if the object does not exist.
+func (s *Store) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.objects[key]; !ok {
+		return syscall.ENOENT
+	}
+	delete(s.objects, key)
+	return nil
+}
+
+// List returns the sorted keys of the objects starting with prefix
+func (s *Store) List(prefix string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := []string{}
+	for k := range s.objects {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// ListObjects implements osio.KeyLister
+func (s *Store) ListObjects(ctx context.Context, prefix, delimiter string) osio.ObjectIterator {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	objects := make([]osio.ObjectAttrs, 0, len(s.objects))
+	for k, o := range s.objects {
+		objects = append(objects, osio.ObjectAttrs{Key: k, Size: int64(len(o.data)), Version: o.version})
+	}
+	return osio.SliceIterator(objects, prefix, delimiter)
+}
+
+// Version returns the current version of the object key
+func (s *Store) Version(key string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	o, ok := s.objects[key]
+	if !ok {
+		return "", syscall.ENOENT
+	}
+	return o.version, nil
+}
+
+// SetLatency changes the delay applied to every request
+func (s *Store) SetLatency(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.latency = d
+}
+
+// SetError makes all requests for key fail with err, whether the object exists or not. A nil
+// err removes the injected error.
+func (s *Store) SetError(key string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err == nil {
+		delete(s.errs, key)
+		return
+	}
+	s.errs[key] = err
+}
+
+// Requests returns the requests received since the store was created or reset
+func (s *Store) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// ResetRequests clears the recorded requests
+func (s *Store) ResetRequests() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = nil
+}
+
+// StreamAt implements osio.KeyStreamerAt
+func (s *Store) StreamAt(key string, off int64, n int64) (io.ReadCloser, int64, error) {
+	r, size, _, err := s.StreamAtVersion(context.Background(), key, off, n, "")
+	return r, size, err
+}
+
+// StreamAtContext implements osio.KeyStreamerAtContext
+func (s *Store) StreamAtContext(ctx context.Context, key string, off int64, n int64) (io.ReadCloser, int64, error) {
+	r, size, _, err := s.StreamAtVersion(ctx, key, off, n, "")
+	return r, size, err
+}
+
+// StreamAtVersion implements osio.KeyVersionStreamerAt. The size of the object is returned
+// for all offsets.
+func (s *Store) StreamAtVersion(ctx context.Context, key string, off int64, n int64, version string) (io.ReadCloser, int64, string, error) {
+	s.mu.Lock()
+	latency := s.latency
+	s.mu.Unlock()
+	if latency > 0 {
+		t := time.NewTimer(latency)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+		case <-t.C:
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r, size, v, err := s.streamAt(ctx, key, off, n, version)
+	s.requests = append(s.requests, Request{Key: key, Offset: off, Length: n, Version: version, Err: err})
+	return r, size, v, err
+}
+
+// must be called with s.mu held
+func (s *Store) streamAt(ctx context.Context, key string, off int64, n int64, version string) (io.ReadCloser, int64, string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, 0, "", err
+	}
+	if err := s.errs[key]; err != nil {
+		return nil, 0, "", err
+	}
+	o, ok := s.objects[key]
+	if !ok {
+		return nil, -1, "", syscall.ENOENT
+	}
+	if version != "" && version != o.version {
+		return nil, 0, "", fmt.Errorf("new reader for %s: %w", key, osio.ErrObjectChanged)
+	}
+	size := int64(len(o.data))
+	if off < 0 {
+		return nil, 0, "", fmt.Errorf("new reader for %s: negative offset", key)
+	}
+	if off >= size {
+		return nil, size, o.version, io.EOF
+	}
+	if n < 0 || n > size-off {
+		n = size - off
+	}
+	//objects are never modified in place, so the slice can be shared
+	return ioutil.NopCloser(bytes.NewReader(o.data[off : off+n])), size, o.version, nil
+}
@@ -0,0 +1,162 @@
+// Copyright 2021 Airbus Defence and Space
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package memstore
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/airbusgeo/osio"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStore(t *testing.T) {
+	s := New(WithObjects(map[string][]byte{"b/one": []byte("0123456789")}))
+	s.Put("b/two", []byte("abc"))
+	s.Put("c/three", nil)
+	assert.Equal(t, []string{"b/one", "b/two"}, s.List("b/"))
+	assert.Equal(t, []string{"b/one", "b/two", "c/three"}, s.List(""))
+
+	r, size, err := s.StreamAt("b/one", 2, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(10), size)
+	buf, _ := ioutil.ReadAll(r)
+	assert.Equal(t, "234", string(buf))
+
+	r, _, _ = s.StreamAt("b/one", 8, 10)
+	buf, _ = ioutil.ReadAll(r)
+	assert.Equal(t, "89", string(buf))
+
+	_, size, err = s.StreamAt("b/one", 10, 3)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, int64(10), size)
+	_, _, err = s.StreamAt("c/three", 0, 3)
+	assert.Equal(t, io.EOF, err)
+	_, _, err = s.StreamAt("b/one", -1, 3)
+	assert.Error(t, err)
+	_, _, err = s.StreamAt("missing", 0, 3)
+	assert.Equal(t, syscall.ENOENT, err)
+
+	//overwrite and delete
+	assert.Equal(t, syscall.ENOENT, s.Overwrite("missing", nil))
+	assert.Equal(t, syscall.ENOENT, s.Delete("missing"))
+	v1, _ := s.Version("b/two")
+	_, _, v, err := s.StreamAtVersion(context.Background(), "b/two", 0, 3, v1)
+	assert.NoError(t, err)
+	assert.Equal(t, v1, v)
+	assert.NoError(t, s.Overwrite("b/two", []byte("def")))
+	_, _, _, err = s.StreamAtVersion(context.Background(), "b/two", 0, 3, v1)
+	assert.True(t, errors.Is(err, osio.ErrObjectChanged))
+	assert.NoError(t, s.Delete("b/two"))
+	_, err = s.Version("b/two")
+	assert.Equal(t, syscall.ENOENT, err)
+
+	//stored data is a copy
+	data := []byte("xyz")
+	s.Put("copy", data)
+	data[0] = 'a'
+	r, _, _ = s.StreamAt("copy", 0, 3)
+	buf, _ = ioutil.ReadAll(r)
+	assert.Equal(t, "xyz", string(buf))
+
+	//error injection
+	boom := errors.New("boom")
+	s.SetError("b/one", boom)
+	s.SetError("missing", boom)
+	_, _, err = s.StreamAt("b/one", 0, 3)
+	assert.Equal(t, boom, err)
+	_, _, err = s.StreamAt("missing", 0, 3)
+	assert.Equal(t, boom, err)
+	s.SetError("b/one", nil)
+	_, _, err = s.StreamAt("b/one", 0, 3)
+	assert.NoError(t, err)
+
+	//recording
+	reqs := s.Requests()
+	assert.Len(t, reqs, 12)
+	assert.Equal(t, Request{Key: "b/one", Offset: 2, Length: 3}, reqs[0])
+	assert.Equal(t, boom, reqs[9].Err)
+	s.ResetRequests()
+	assert.Len(t, s.Requests(), 0)
+
+	//lengths past the end of the object
+	r, _, err = s.StreamAt("b/one", 8, math.MaxInt64)
+	assert.NoError(t, err)
+	buf, _ = ioutil.ReadAll(r)
+	assert.Equal(t, "89", string(buf))
+}
+
+func TestStoreLatency(t *testing.T) {
+	s := New(WithLatency(20 * time.Millisecond))
+	s.Put("a", []byte("a"))
+	st := time.Now()
+	_, _, err := s.StreamAt("a", 0, 1)
+	assert.NoError(t, err)
+	assert.GreaterOrEqual(t, int64(time.Since(st)), int64(20*time.Millisecond))
+
+	s.SetLatency(time.Hour)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, _, err = s.StreamAtContext(ctx, "a", 0, 1)
+	assert.Equal(t, context.DeadlineExceeded, err)
+}
+
+func TestStoreAdapter(t *testing.T) {
+	s := New()
+	s.Put("bucket/object", []byte("aaaabbbbcccc"))
+	a, _ := osio.NewAdapter(s, osio.BlockSize("4"))
+	r, err := a.Reader("bucket/object")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(12), r.Size())
+	buf := make([]byte, 4)
+	_, err = r.ReadAt(buf, 4)
+	assert.NoError(t, err)
+	assert.Equal(t, "bbbb", string(buf))
+	_, err = a.Reader("bucket/missing")
+	assert.Equal(t, syscall.ENOENT, err)
+
+	//blocks are cached
+	s.ResetRequests()
+	_, _ = r.ReadAt(buf, 4)
+	assert.Len(t, s.Requests(), 0)
+}
+
+func TestStoreListObjects(t *testing.T) {
+	s := New()
+	s.Put("b/one", []byte("1"))
+	s.Put("b/dir/two", []byte("22"))
+	s.Put("c", nil)
+	a, _ := osio.NewAdapter(s)
+	it := a.ListObjects(context.Background(), "b/", "/")
+	attrs, err := it.Next()
+	assert.NoError(t, err)
+	assert.Equal(t, osio.ObjectAttrs{Key: "b/dir/", Prefix: true}, attrs)
+	attrs, _ = it.Next()
+	v, _ := s.Version("b/one")
+	assert.Equal(t, osio.ObjectAttrs{Key: "b/one", Size: 1, Version: v}, attrs)
+	_, err = it.Next()
+	assert.Equal(t, io.EOF, err)
+
+	size, err := a.Size("b/one")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), size)
+	assert.Empty(t, s.Requests())
+}
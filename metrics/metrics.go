@@ -0,0 +1,335 @@
+// Copyright 2021 Airbus Defence and Space
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package metrics instruments osio Adapters and KeyStreamerAts with OpenTelemetry metrics.
+//
+// Metrics are reported to the global MeterProvider unless another one is given with the
+// WithMeterProvider option. They can be exported to Prometheus with the
+// go.opentelemetry.io/otel/exporters/prometheus exporter.
+package metrics
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/airbusgeo/osio"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/metric"
+)
+
+const instrumentationName = "github.com/airbusgeo/osio/metrics"
+
+// Error classes reported in the "class" attribute of the osio.request.errors metric
+const (
+	ClassENOENT    = "enoent"
+	ClassEOF       = "eof"
+	ClassTemporary = "temporary"
+	ClassOther     = "other"
+)
+
+type config struct {
+	provider metric.MeterProvider
+	backend  string
+	attrs    []attribute.KeyValue
+}
+
+// Option is an option that can be passed to Instrument and ObserveAdapter
+type Option func(c *config)
+
+// WithMeterProvider sets the MeterProvider used to create the instruments. Defaults to
+// the global MeterProvider
+func WithMeterProvider(mp metric.MeterProvider) Option {
+	return func(c *config) {
+		c.provider = mp
+	}
+}
+
+// WithBackend sets the value of the "backend" attribute of the request metrics. Defaults to
+// the scheme of the requested key (e.g. "gs" for "gs://bucket/object")
+func WithBackend(name string) Option {
+	return func(c *config) {
+		c.backend = name
+	}
+}
+
+// WithAttributes adds attributes to all the reported metrics
+func WithAttributes(attrs ...attribute.KeyValue) Option {
+	return func(c *config) {
+		c.attrs = append(c.attrs, attrs...)
+	}
+}
+
+func newConfig(opts []Option) config {
+	c := config{}
+	for _, o := range opts {
+		o(&c)
+	}
+	if c.provider == nil {
+		c.provider = otel.GetMeterProvider()
+	}
+	return c
+}
+
+// KeyStreamer is an osio.KeyStreamerAt that records metrics about the requests made to
+// another KeyStreamerAt:
+//
+//   - osio.request.duration: histogram of the time taken by the wrapped StreamAt to return, in
+//     seconds, by backend
+//   - osio.request.bytes: number of bytes read from the returned streams, by backend
+//   - osio.request.inflight: number of requests whose stream has not been closed yet, by backend
+//   - osio.request.errors: number of failed requests, by backend and error class
+type KeyStreamer struct {
+	ks       osio.KeyStreamerAt
+	backend  string
+	attrs    []attribute.KeyValue
+	duration metric.Float64Histogram
+	bytes    metric.Int64Counter
+	inflight metric.Int64UpDownCounter
+	errors   metric.Int64Counter
+}
+
+var (
+	_ osio.KeyVersionStreamerAt = &KeyStreamer{}
+	_ osio.KeyLister            = &KeyStreamer{}
+	_ osio.ListingChecker       = &KeyStreamer{}
+)
+
+// Instrument wraps ks in a KeyStreamer. The returned KeyStreamer implements
+// osio.KeyStreamerAtContext, osio.KeyVersionStreamerAt and osio.KeyLister by forwarding to ks.
+func Instrument(ks osio.KeyStreamerAt, opts ...Option) (*KeyStreamer, error) {
+	c := newConfig(opts)
+	meter := c.provider.Meter(instrumentationName)
+	k := &KeyStreamer{ks: ks, backend: c.backend, attrs: c.attrs}
+	var err error
+	if k.duration, err = meter.Float64Histogram("osio.request.duration",
+		metric.WithDescription("Time taken by the source to answer a range request"),
+		metric.WithUnit("s")); err != nil {
+		return nil, err
+	}
+	if k.bytes, err = meter.Int64Counter("osio.request.bytes",
+		metric.WithDescription("Bytes downloaded from the source"),
+		metric.WithUnit("By")); err != nil {
+		return nil, err
+	}
+	if k.inflight, err = meter.Int64UpDownCounter("osio.request.inflight",
+		metric.WithDescription("Range requests currently being downloaded")); err != nil {
+		return nil, err
+	}
+	if k.errors, err = meter.Int64Counter("osio.request.errors",
+		metric.WithDescription("Failed range requests")); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+func (k *KeyStreamer) attributes(key string) []attribute.KeyValue {
+	backend := k.backend
+	if backend == "" {
+		backend = "unknown"
+		if idx := strings.Index(key, "://"); idx > 0 {
+			backend = key[:idx]
+		}
+	}
+	return append([]attribute.KeyValue{attribute.String("backend", backend)}, k.attrs...)
+}
+
+// ErrorClass returns the class under which err is reported
+func ErrorClass(err error) string {
+	var tmp interface{ Temporary() bool }
+	switch {
+	case errors.Is(err, syscall.ENOENT):
+		return ClassENOENT
+	case errors.Is(err, io.EOF):
+		return ClassEOF
+	case errors.As(err, &tmp) && tmp.Temporary():
+		return ClassTemporary
+	default:
+		return ClassOther
+	}
+}
+
+// StreamAt implements osio.KeyStreamerAt
+func (k *KeyStreamer) StreamAt(key string, off int64, n int64) (io.ReadCloser, int64, error) {
+	return k.StreamAtContext(context.Background(), key, off, n)
+}
+
+// StreamAtContext implements osio.KeyStreamerAtContext
+func (k *KeyStreamer) StreamAtContext(ctx context.Context, key string, off int64, n int64) (io.ReadCloser, int64, error) {
+	r, size, _, err := k.StreamAtVersion(ctx, key, off, n, "")
+	return r, size, err
+}
+
+// StreamAtVersion implements osio.KeyVersionStreamerAt
+func (k *KeyStreamer) StreamAtVersion(ctx context.Context, key string, off int64, n int64, version string) (io.ReadCloser, int64, string, error) {
+	attrs := k.attributes(key)
+	set := metric.WithAttributes(attrs...)
+	k.inflight.Add(ctx, 1, set)
+	st := time.Now()
+	r, size, v, err := osio.StreamAtVersion(ctx, k.ks, key, off, n, version)
+	k.duration.Record(ctx, time.Since(st).Seconds(), set)
+	if err != nil {
+		k.errors.Add(ctx, 1, metric.WithAttributes(append(attrs, attribute.String("class", ErrorClass(err)))...))
+	}
+	if r == nil {
+		k.inflight.Add(ctx, -1, set)
+		return r, size, v, err
+	}
+	return &countingReader{ReadCloser: r, ctx: ctx, k: k, set: set}, size, v, err
+}
+
+// ListObjects implements osio.KeyLister. Listings are forwarded without recording metrics.
+func (k *KeyStreamer) ListObjects(ctx context.Context, prefix, delimiter string) osio.ObjectIterator {
+	return osio.ListObjects(ctx, k.ks, prefix, delimiter)
+}
+
+// CanList implements osio.ListingChecker
+func (k *KeyStreamer) CanList(prefix string) bool {
+	return osio.CanList(k.ks, prefix)
+}
+
+type countingReader struct {
+	io.ReadCloser
+	ctx    context.Context
+	k      *KeyStreamer
+	set    metric.MeasurementOption
+	closed bool
+}
+
+func (cr *countingReader) Read(buf []byte) (int, error) {
+	n, err := cr.ReadCloser.Read(buf)
+	if n > 0 {
+		cr.k.bytes.Add(cr.ctx, int64(n), cr.set)
+	}
+	return n, err
+}
+
+func (cr *countingReader) Close() error {
+	if !cr.closed {
+		cr.closed = true
+		cr.k.inflight.Add(cr.ctx, -1, cr.set)
+	}
+	return cr.ReadCloser.Close()
+}
+
+// ObserveAdapter reports the statistics returned by a.Stats() as asynchronous instruments:
+//
+//   - osio.cache.hits, osio.cache.misses: block cache lookups
+//   - osio.cache.hit_ratio: ratio of the lookups that were served by the block cache
+//   - osio.cache.enoent_hits: lookups of non-existing objects served by the size cache
+//   - osio.adapter.requests, osio.adapter.retries: requests made to the source
+//   - osio.adapter.bytes: bytes read from the source
+//   - osio.adapter.hedges: duplicate requests sent by hedging
+//   - osio.adapter.queue_waits, osio.adapter.queue_wait_time: requests queued by the
+//     concurrency limits
+//   - osio.adapter.lock_waits, osio.adapter.lock_wait_time: waits on blocks being fetched
+//     by a concurrent read
+//
+// The statistics of the prefixes configured with osio.StatsPrefixes are additionally reported
+// with a "prefix" attribute. The returned Registration must be unregistered to stop observing a.
+func ObserveAdapter(a *osio.Adapter, opts ...Option) (metric.Registration, error) {
+	c := newConfig(opts)
+	meter := c.provider.Meter(instrumentationName)
+	hits, err := meter.Int64ObservableCounter("osio.cache.hits",
+		metric.WithDescription("Blocks served by the block cache"))
+	if err != nil {
+		return nil, err
+	}
+	misses, err := meter.Int64ObservableCounter("osio.cache.misses",
+		metric.WithDescription("Blocks not found in the block cache"))
+	if err != nil {
+		return nil, err
+	}
+	ratio, err := meter.Float64ObservableGauge("osio.cache.hit_ratio",
+		metric.WithDescription("Ratio of the blocks served by the block cache"))
+	if err != nil {
+		return nil, err
+	}
+	enoent, err := meter.Int64ObservableCounter("osio.cache.enoent_hits",
+		metric.WithDescription("Non-existing objects served by the size cache"))
+	if err != nil {
+		return nil, err
+	}
+	requests, err := meter.Int64ObservableCounter("osio.adapter.requests",
+		metric.WithDescription("Requests made to the source, including retries"))
+	if err != nil {
+		return nil, err
+	}
+	retries, err := meter.Int64ObservableCounter("osio.adapter.retries",
+		metric.WithDescription("Requests retried after a temporary error"))
+	if err != nil {
+		return nil, err
+	}
+	bytes, err := meter.Int64ObservableCounter("osio.adapter.bytes",
+		metric.WithDescription("Bytes read from the source"),
+		metric.WithUnit("By"))
+	if err != nil {
+		return nil, err
+	}
+	hedges, err := meter.Int64ObservableCounter("osio.adapter.hedges",
+		metric.WithDescription("Duplicate requests sent by hedging"))
+	if err != nil {
+		return nil, err
+	}
+	queueWaits, err := meter.Int64ObservableCounter("osio.adapter.queue_waits",
+		metric.WithDescription("Requests queued by the concurrency limits"))
+	if err != nil {
+		return nil, err
+	}
+	queueWaitTime, err := meter.Float64ObservableCounter("osio.adapter.queue_wait_time",
+		metric.WithDescription("Time spent by requests queued by the concurrency limits"),
+		metric.WithUnit("s"))
+	if err != nil {
+		return nil, err
+	}
+	waits, err := meter.Int64ObservableCounter("osio.adapter.lock_waits",
+		metric.WithDescription("Waits on blocks being fetched by a concurrent read"))
+	if err != nil {
+		return nil, err
+	}
+	waitTime, err := meter.Float64ObservableCounter("osio.adapter.lock_wait_time",
+		metric.WithDescription("Time spent waiting on blocks being fetched by a concurrent read"),
+		metric.WithUnit("s"))
+	if err != nil {
+		return nil, err
+	}
+	observe := func(o metric.Observer, st osio.Stats, attrs []attribute.KeyValue) {
+		set := metric.WithAttributes(attrs...)
+		o.ObserveInt64(hits, int64(st.BlockHits), set)
+		o.ObserveInt64(misses, int64(st.BlockMisses), set)
+		if lookups := st.BlockHits + st.BlockMisses; lookups > 0 {
+			o.ObserveFloat64(ratio, float64(st.BlockHits)/float64(lookups), set)
+		}
+		o.ObserveInt64(enoent, int64(st.ENOENTHits), set)
+		o.ObserveInt64(requests, int64(st.Requests), set)
+		o.ObserveInt64(retries, int64(st.Retries), set)
+		o.ObserveInt64(bytes, int64(st.BytesFetched), set)
+		o.ObserveInt64(hedges, int64(st.Hedges), set)
+		o.ObserveInt64(queueWaits, int64(st.QueueWaits), set)
+		o.ObserveFloat64(queueWaitTime, st.QueueWaitTime.Seconds(), set)
+		o.ObserveInt64(waits, int64(st.LockWaits), set)
+		o.ObserveFloat64(waitTime, st.LockWaitTime.Seconds(), set)
+	}
+	return meter.RegisterCallback(func(_ context.Context, o metric.Observer) error {
+		observe(o, a.Stats(), c.attrs)
+		for prefix, st := range a.PrefixStats() {
+			observe(o, st, append([]attribute.KeyValue{attribute.String("prefix", prefix)}, c.attrs...))
+		}
+		return nil
+	}, hits, misses, ratio, enoent, requests, retries, bytes, hedges, queueWaits, queueWaitTime, waits, waitTime)
+}
@@ -0,0 +1,205 @@
+// Copyright 2021 Airbus Defence and Space
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/airbusgeo/errs"
+	"github.com/airbusgeo/osio"
+	"github.com/airbusgeo/osio/memstore"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	sdkmetric "go.opentelemetry.io/otel/sdk/metric"
+	"go.opentelemetry.io/otel/sdk/metric/metricdata"
+)
+
+type source map[string][]byte
+
+func (s source) StreamAt(key string, off int64, n int64) (io.ReadCloser, int64, error) {
+	if strings.Contains(key, "unavailable") {
+		return nil, 0, errs.MakeTemporary(fmt.Errorf("unavailable"))
+	}
+	data, ok := s[key]
+	if !ok {
+		return nil, 0, syscall.ENOENT
+	}
+	ll := int64(len(data))
+	if off >= ll {
+		return nil, ll, io.EOF
+	}
+	if off+n >= ll {
+		return ioutil.NopCloser(bytes.NewReader(data[off:])), ll, io.EOF
+	}
+	return ioutil.NopCloser(bytes.NewReader(data[off : off+n])), ll, nil
+}
+
+// points collects the data points of the metric with the given name, keyed by their attributes
+func points(t *testing.T, reader sdkmetric.Reader, name string) map[string]float64 {
+	rm := metricdata.ResourceMetrics{}
+	assert.NoError(t, reader.Collect(context.Background(), &rm))
+	ret := map[string]float64{}
+	key := func(set attribute.Set) string {
+		kvs := []string{}
+		for _, kv := range set.ToSlice() {
+			kvs = append(kvs, string(kv.Key)+"="+kv.Value.Emit())
+		}
+		return strings.Join(kvs, ",")
+	}
+	for _, sm := range rm.ScopeMetrics {
+		for _, m := range sm.Metrics {
+			if m.Name != name {
+				continue
+			}
+			//data points are generic types, walk them with reflection
+			dps := reflect.ValueOf(m.Data).FieldByName("DataPoints")
+			for i := 0; i < dps.Len(); i++ {
+				p := dps.Index(i)
+				set := p.FieldByName("Attributes").Interface().(attribute.Set)
+				var v reflect.Value
+				if v = p.FieldByName("Value"); !v.IsValid() {
+					v = p.FieldByName("Count")
+				}
+				switch v.Kind() {
+				case reflect.Int64:
+					ret[key(set)] = float64(v.Int())
+				case reflect.Uint64:
+					ret[key(set)] = float64(v.Uint())
+				default:
+					ret[key(set)] = v.Float()
+				}
+			}
+		}
+	}
+	return ret
+}
+
+func TestErrorClass(t *testing.T) {
+	assert.Equal(t, ClassENOENT, ErrorClass(fmt.Errorf("wrapped: %w", syscall.ENOENT)))
+	assert.Equal(t, ClassEOF, ErrorClass(io.EOF))
+	assert.Equal(t, ClassTemporary, ErrorClass(errs.MakeTemporary(fmt.Errorf("503"))))
+	assert.Equal(t, ClassOther, ErrorClass(errors.New("other")))
+}
+
+func TestMetrics(t *testing.T) {
+	reader := sdkmetric.NewManualReader()
+	mp := sdkmetric.NewMeterProvider(sdkmetric.WithReader(reader))
+
+	src := source{
+		"gs://bucket/object": []byte("0123456789"),
+		"s3://bucket/object": []byte("abc"),
+	}
+	ks, err := Instrument(src, WithMeterProvider(mp))
+	assert.NoError(t, err)
+	a, _ := osio.NewAdapter(ks, osio.BlockSize("4"), osio.Retries(1), osio.StatsPrefixes("gs://"))
+	reg, err := ObserveAdapter(a, WithMeterProvider(mp), WithAttributes(attribute.String("server", "tiles")))
+	assert.NoError(t, err)
+	defer reg.Unregister()
+
+	buf := make([]byte, 10)
+	_, err = a.ReadAt("gs://bucket/object", buf, 0)
+	assert.NoError(t, err)
+	_, err = a.ReadAt("gs://bucket/object", buf[:2], 4)
+	assert.NoError(t, err)
+	_, err = a.ReadAt("s3://bucket/object", buf[:2], 0)
+	assert.NoError(t, err)
+	_, err = a.ReadAt("s3://bucket/missing", buf[:2], 0)
+	assert.ErrorIs(t, err, syscall.ENOENT)
+	_, err = a.ReadAt("unavailable", buf[:2], 0)
+	assert.Error(t, err)
+
+	assert.Equal(t, map[string]float64{
+		"backend=gs":      1,
+		"backend=s3":      2,
+		"backend=unknown": 2,
+	}, points(t, reader, "osio.request.duration"))
+	assert.Equal(t, map[string]float64{
+		"backend=gs": 10,
+		"backend=s3": 3,
+	}, points(t, reader, "osio.request.bytes"))
+	assert.Equal(t, map[string]float64{
+		"backend=gs":      0,
+		"backend=s3":      0,
+		"backend=unknown": 0,
+	}, points(t, reader, "osio.request.inflight"))
+	assert.Equal(t, map[string]float64{
+		"backend=gs,class=eof":            1,
+		"backend=s3,class=eof":            1,
+		"backend=s3,class=enoent":         1,
+		"backend=unknown,class=temporary": 2,
+	}, points(t, reader, "osio.request.errors"))
+
+	assert.Equal(t, map[string]float64{
+		"server=tiles":              1,
+		"prefix=gs://,server=tiles": 1,
+	}, points(t, reader, "osio.cache.hits"))
+	assert.Equal(t, map[string]float64{
+		"server=tiles":              6,
+		"prefix=gs://,server=tiles": 3,
+	}, points(t, reader, "osio.cache.misses"))
+	assert.Equal(t, map[string]float64{
+		"server=tiles":              1. / 7,
+		"prefix=gs://,server=tiles": 1. / 4,
+	}, points(t, reader, "osio.cache.hit_ratio"))
+	assert.Equal(t, map[string]float64{
+		"server=tiles":              1,
+		"prefix=gs://,server=tiles": 0,
+	}, points(t, reader, "osio.adapter.retries"))
+	assert.Equal(t, map[string]float64{
+		"server=tiles":              13,
+		"prefix=gs://,server=tiles": 10,
+	}, points(t, reader, "osio.adapter.bytes"))
+	assert.Equal(t, map[string]float64{
+		"server=tiles":              0,
+		"prefix=gs://,server=tiles": 0,
+	}, points(t, reader, "osio.adapter.queue_waits"))
+
+	//explicit backend, stream left open
+	ks, _ = Instrument(src, WithMeterProvider(mp), WithBackend("gcs"))
+	r, _, err := ks.StreamAt("gs://bucket/object", 0, 4)
+	assert.NoError(t, err)
+	assert.Equal(t, float64(1), points(t, reader, "osio.request.inflight")["backend=gcs"])
+	_ = r.Close()
+	_ = r.Close()
+	assert.Equal(t, float64(0), points(t, reader, "osio.request.inflight")["backend=gcs"])
+}
+
+func TestListing(t *testing.T) {
+	ks, err := Instrument(source{"gs://bucket/object": []byte("0123456789")})
+	assert.NoError(t, err)
+	assert.False(t, osio.CanList(ks, "gs://bucket/"))
+	_, err = ks.ListObjects(context.Background(), "gs://bucket/", "").Next()
+	assert.ErrorIs(t, err, osio.ErrListingNotSupported)
+
+	store := memstore.New(memstore.WithObjects(map[string][]byte{"gs://bucket/object": []byte("0123456789")}))
+	ks, _ = Instrument(store)
+	assert.True(t, osio.CanList(ks, "gs://bucket/"))
+	it := ks.ListObjects(context.Background(), "gs://bucket/", "")
+	attrs, err := it.Next()
+	assert.NoError(t, err)
+	assert.Equal(t, "gs://bucket/object", attrs.Key)
+	assert.Equal(t, int64(10), attrs.Size)
+	_, err = it.Next()
+	assert.Equal(t, io.EOF, err)
+}
@@ -0,0 +1,378 @@
+// Copyright 2021 Airbus Defence and Space
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package osio
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru"
+)
+
+// ErrReplicaMismatch is returned when a replica of a key does not have the same size as the
+// replica that previously served it
+var ErrReplicaMismatch = errors.New("replica size mismatch")
+
+// failedReplicaLatency is the latency recorded for the host of a replica when a request to it
+// fails, so that failing replicas are tried last with LatencyOrdering
+const failedReplicaLatency = 10 * time.Second
+
+type replicaRule struct {
+	prefix   string
+	replicas []string
+}
+
+// Mirror is a KeyStreamerAt that maps logical keys to an ordered list of physical keys, i.e.
+// replicas of the same object, usually on different backends handled by a Mux. Requests are
+// sent to the first replica and fall back to the following ones when they fail with ENOENT or
+// with a persistent error.
+//
+// Once a replica has served a key, subsequent requests for that key are sent to it first, and
+// replicas that report a different size are skipped, so that all the blocks of a key read
+// through an Adapter come from a consistent object. When the size of a key is not returned by
+// the replica serving a request (i.e. for requests at offset > 0 on some backends) and that
+// replica is not the pinned one, its size is first checked with a request at offset 0.
+//
+// Object versions are specific to the replica that returned them: requests for a version are
+// only sent to the replica that served it if it is still pinned, and do not fall back to other
+// replicas if the object has changed.
+type Mirror struct {
+	ks         KeyStreamerAt
+	rules      []replicaRule
+	mapper     func(key string) []string
+	byLatency  bool
+	isFallback func(error) bool
+	pinned     *lru.Cache
+	mu         sync.Mutex
+	latencies  map[string]time.Duration
+}
+
+var (
+	_ KeyStreamerAtContext = &Mirror{}
+	_ KeyVersionStreamerAt = &Mirror{}
+	_ KeyLister            = &Mirror{}
+	_ ListingChecker       = &Mirror{}
+)
+
+// MirrorOption is an option that can be passed to NewMirror
+type MirrorOption func(m *Mirror) error
+
+// Replicas maps the keys starting with prefix to the keys obtained by replacing prefix with
+// each of the replica prefixes, in order of preference. e.g.:
+//
+//	Replicas("sentinel-2/", "gs://gcp-public-data-sentinel-2/", "s3://sentinel-s2-l1c/")
+//
+// When several prefixes match a key, the longest one is used. Keys that do not match any
+// prefix are passed through unchanged.
+func Replicas(prefix string, replicaPrefixes ...string) MirrorOption {
+	return func(m *Mirror) error {
+		if len(replicaPrefixes) == 0 {
+			return fmt.Errorf("no replicas for prefix %s", prefix)
+		}
+		m.rules = append(m.rules, replicaRule{prefix: prefix, replicas: replicaPrefixes})
+		return nil
+	}
+}
+
+// ReplicaMapper sets a function returning the replicas of a key, in order of preference. It
+// replaces the mapping set up by the Replicas option.
+func ReplicaMapper(mapper func(key string) []string) MirrorOption {
+	return func(m *Mirror) error {
+		if mapper == nil {
+			return fmt.Errorf("mapper must not be nil")
+		}
+		m.mapper = mapper
+		return nil
+	}
+}
+
+// LatencyOrdering tries the replicas of a key by increasing measured latency instead of by
+// order of preference. Latencies are tracked per host or bucket, and failed requests count as
+// very slow ones.
+func LatencyOrdering() MirrorOption {
+	return func(m *Mirror) error {
+		m.byLatency = true
+		return nil
+	}
+}
+
+// FallbackOn sets the function telling whether a failed request should be sent to the next
+// replica. Defaults to falling back on all errors (ENOENT, ErrCircuitOpen, unavailable or
+// misconfigured backend, ...) except context cancellation and io.EOF.
+func FallbackOn(isFallback func(error) bool) MirrorOption {
+	return func(m *Mirror) error {
+		if isFallback == nil {
+			return fmt.Errorf("fallback function must not be nil")
+		}
+		m.isFallback = isFallback
+		return nil
+	}
+}
+
+// PinnedKeys sets the number of keys for which the serving replica is remembered. Defaults to 1000
+func PinnedKeys(n int) MirrorOption {
+	return func(m *Mirror) error {
+		var err error
+		m.pinned, err = lru.New(n)
+		return err
+	}
+}
+
+func defaultFallback(err error) bool {
+	return !errors.Is(err, context.Canceled) && !errors.Is(err, context.DeadlineExceeded) && !errors.Is(err, io.EOF)
+}
+
+// NewMirror creates a Mirror sending requests to ks, typically a Mux handling the schemes of
+// all the replicas
+func NewMirror(ks KeyStreamerAt, opts ...MirrorOption) (*Mirror, error) {
+	m := &Mirror{
+		ks:         ks,
+		isFallback: defaultFallback,
+		latencies:  make(map[string]time.Duration),
+	}
+	m.pinned, _ = lru.New(1000)
+	for _, o := range opts {
+		if err := o(m); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// rule returns the rule with the longest prefix matching key, or a rule mapping key to itself
+func (m *Mirror) rule(key string) replicaRule {
+	best := -1
+	for i, r := range m.rules {
+		if strings.HasPrefix(key, r.prefix) && (best == -1 || len(r.prefix) > len(m.rules[best].prefix)) {
+			best = i
+		}
+	}
+	if best == -1 {
+		return replicaRule{replicas: []string{""}}
+	}
+	return m.rules[best]
+}
+
+func (m *Mirror) replicas(key string) []string {
+	if m.mapper != nil {
+		return m.mapper(key)
+	}
+	rule := m.rule(key)
+	keys := make([]string, len(rule.replicas))
+	for i, p := range rule.replicas {
+		keys[i] = p + key[len(rule.prefix):]
+	}
+	return keys
+}
+
+type pinnedReplica struct {
+	key     string
+	size    int64
+	version string
+}
+
+// observe updates the moving average of the latency of the host of key
+func (m *Mirror) observe(key string, d time.Duration) {
+	host := keyHost(key)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if l, ok := m.latencies[host]; ok {
+		d = (3*l + d) / 4
+	}
+	m.latencies[host] = d
+}
+
+// order returns the replicas to try for key, the pinned replica first
+func (m *Mirror) order(key string) ([]string, *pinnedReplica) {
+	replicas := m.replicas(key)
+	if m.byLatency && len(replicas) > 1 {
+		replicas = append([]string(nil), replicas...)
+		m.mu.Lock()
+		lat := make(map[string]time.Duration, len(replicas))
+		for _, r := range replicas {
+			//unmeasured hosts have a zero latency and are tried first
+			lat[r] = m.latencies[keyHost(r)]
+		}
+		m.mu.Unlock()
+		sort.SliceStable(replicas, func(i, j int) bool { return lat[replicas[i]] < lat[replicas[j]] })
+	}
+	pv, ok := m.pinned.Get(key)
+	if !ok {
+		return replicas, nil
+	}
+	pin := pv.(pinnedReplica)
+	for i, r := range replicas {
+		if r == pin.key {
+			if i > 0 {
+				replicas = append([]string{r}, append(append([]string(nil), replicas[:i]...), replicas[i+1:]...)...)
+			}
+			break
+		}
+	}
+	return replicas, &pin
+}
+
+// StreamAt implements KeyStreamerAt
+func (m *Mirror) StreamAt(key string, off int64, n int64) (io.ReadCloser, int64, error) {
+	return m.StreamAtContext(context.Background(), key, off, n)
+}
+
+// StreamAtContext implements KeyStreamerAtContext
+func (m *Mirror) StreamAtContext(ctx context.Context, key string, off int64, n int64) (io.ReadCloser, int64, error) {
+	r, size, _, err := m.StreamAtVersion(ctx, key, off, n, "")
+	return r, size, err
+}
+
+// StreamAtVersion implements KeyVersionStreamerAt
+func (m *Mirror) StreamAtVersion(ctx context.Context, key string, off int64, n int64, version string) (io.ReadCloser, int64, string, error) {
+	replicas, pin := m.order(key)
+	if version != "" && pin != nil && pin.version == version && len(replicas) > 0 && replicas[0] == pin.key {
+		replicas = replicas[:1]
+	}
+	var firstErr, enoent error
+	for _, replica := range replicas {
+		st := time.Now()
+		r, size, curVersion, err := StreamAtVersion(ctx, m.ks, replica, off, n, version)
+		if err == nil || errors.Is(err, io.EOF) {
+			//some handlers only return the size of the object for requests at offset 0
+			known := off == 0 || size > 0
+			if !known && pin != nil && pin.key != replica {
+				var cerr error
+				size, cerr = m.replicaSize(ctx, replica)
+				if cerr != nil {
+					if r != nil {
+						r.Close()
+					}
+					err = cerr
+				}
+				known = cerr == nil
+			}
+			if known && pin != nil && size != pin.size {
+				if r != nil {
+					r.Close()
+				}
+				err = fmt.Errorf("%s: size %d instead of %d: %w", replica, size, pin.size, ErrReplicaMismatch)
+			} else if err == nil || errors.Is(err, io.EOF) {
+				m.observe(replica, time.Since(st))
+				if known && (pin == nil || pin.key != replica || pin.version != curVersion) {
+					m.pinned.Add(key, pinnedReplica{key: replica, size: size, version: curVersion})
+				}
+				return r, size, curVersion, err
+			}
+		}
+		if ctx.Err() != nil {
+			return nil, 0, "", ctx.Err()
+		}
+		m.observe(replica, failedReplicaLatency)
+		if !m.isFallback(err) || (version != "" && errors.Is(err, ErrObjectChanged)) {
+			return nil, 0, "", err
+		}
+		if errors.Is(err, syscall.ENOENT) {
+			enoent = err
+		} else if firstErr == nil {
+			firstErr = err
+		}
+	}
+	//report ENOENT only if the object is missing from all the replicas
+	if firstErr != nil {
+		return nil, 0, "", firstErr
+	}
+	return nil, 0, "", enoent
+}
+
+// replicaSize returns the size of replica, with a request at offset 0
+func (m *Mirror) replicaSize(ctx context.Context, replica string) (int64, error) {
+	r, size, err := StreamAtContext(ctx, m.ks, replica, 0, 1)
+	if r != nil {
+		r.Close()
+	}
+	if err != nil && !errors.Is(err, io.EOF) {
+		return 0, err
+	}
+	return size, nil
+}
+
+// ListObjects implements KeyLister. The objects are listed from the first replica of prefix that
+// can be listed, falling back to the following replicas on the errors selected by FallbackOn
+// until an object has been returned, and their keys are mapped back to logical keys. Prefixes
+// that do not match any of the Replicas prefixes are listed unchanged. Listing is not supported
+// when the replicas are set with a ReplicaMapper.
+func (m *Mirror) ListObjects(ctx context.Context, prefix, delimiter string) ObjectIterator {
+	if m.mapper != nil {
+		return ErrorIterator(ErrListingNotSupported)
+	}
+	return &mirrorIterator{m: m, ctx: ctx, rule: m.rule(prefix), prefix: prefix, delimiter: delimiter}
+}
+
+// CanList implements ListingChecker, telling whether any replica of prefix can be listed
+func (m *Mirror) CanList(prefix string) bool {
+	if m.mapper != nil {
+		return false
+	}
+	for _, replica := range m.replicas(prefix) {
+		if CanList(m.ks, replica) {
+			return true
+		}
+	}
+	return false
+}
+
+type mirrorIterator struct {
+	m         *Mirror
+	ctx       context.Context
+	rule      replicaRule
+	prefix    string
+	delimiter string
+	// next is the index of the next replica to list
+	next     int
+	it       ObjectIterator
+	started  bool
+	firstErr error
+}
+
+func (mi *mirrorIterator) Next() (ObjectAttrs, error) {
+	for {
+		if mi.it == nil {
+			if mi.next == len(mi.rule.replicas) {
+				return ObjectAttrs{}, mi.firstErr
+			}
+			replica := mi.rule.replicas[mi.next] + mi.prefix[len(mi.rule.prefix):]
+			mi.it = ListObjects(mi.ctx, mi.m.ks, replica, mi.delimiter)
+			mi.next++
+		}
+		attrs, err := mi.it.Next()
+		if err == nil {
+			mi.started = true
+			if replicaPrefix := mi.rule.replicas[mi.next-1]; strings.HasPrefix(attrs.Key, replicaPrefix) {
+				attrs.Key = mi.rule.prefix + attrs.Key[len(replicaPrefix):]
+			}
+			return attrs, nil
+		}
+		if mi.started || errors.Is(err, io.EOF) || mi.ctx.Err() != nil || !mi.m.isFallback(err) {
+			return ObjectAttrs{}, err
+		}
+		if mi.firstErr == nil || errors.Is(mi.firstErr, ErrListingNotSupported) {
+			mi.firstErr = err
+		}
+		mi.it = nil
+	}
+}
@@ -0,0 +1,320 @@
+// Copyright 2021 Airbus Defence and Space
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package osio
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type replicaStore struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	errs    map[string]error
+	calls   []string
+}
+
+func (s *replicaStore) StreamAt(key string, off int64, n int64) (io.ReadCloser, int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls = append(s.calls, key)
+	if err := s.errs[key]; err != nil {
+		return nil, 0, err
+	}
+	data, ok := s.objects[key]
+	if !ok {
+		return nil, 0, syscall.ENOENT
+	}
+	ll := int64(len(data))
+	if off >= ll {
+		return nil, ll, io.EOF
+	}
+	if off+n > ll {
+		n = ll - off
+	}
+	return ioutil.NopCloser(bytes.NewReader(data[off : off+n])), ll, nil
+}
+
+func (s *replicaStore) reset() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	calls := s.calls
+	s.calls = nil
+	return calls
+}
+
+func TestMirror(t *testing.T) {
+	_, err := NewMirror(rr, Replicas("a/"))
+	assert.Error(t, err)
+	_, err = NewMirror(rr, ReplicaMapper(nil))
+	assert.Error(t, err)
+	_, err = NewMirror(rr, FallbackOn(nil))
+	assert.Error(t, err)
+	_, err = NewMirror(rr, PinnedKeys(0))
+	assert.Error(t, err)
+
+	store := &replicaStore{
+		objects: map[string][]byte{
+			"gs://a/obj":     []byte("0123456789"),
+			"s3://b/obj":     []byte("0123456789"),
+			"s3://b/only_s3": []byte("abc"),
+			"gs://a/resized": []byte("0123456789"),
+			"s3://b/resized": []byte("012345"),
+		},
+		errs: map[string]error{},
+	}
+	m, _ := NewMirror(store,
+		Replicas("data/", "gs://a/", "s3://b/"),
+		Replicas("data/sub/", "s3://c/"))
+
+	assert.Equal(t, []string{"gs://a/x", "s3://b/x"}, m.replicas("data/x"))
+	assert.Equal(t, []string{"s3://c/x"}, m.replicas("data/sub/x"))
+	assert.Equal(t, []string{"other/x"}, m.replicas("other/x"))
+
+	r, size, err := m.StreamAt("data/obj", 2, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(10), size)
+	buf, _ := ioutil.ReadAll(r)
+	assert.Equal(t, "234", string(buf))
+	assert.Equal(t, []string{"gs://a/obj"}, store.reset())
+
+	//fallback on ENOENT
+	r, size, err = m.StreamAt("data/only_s3", 0, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), size)
+	r.Close()
+	assert.Equal(t, []string{"gs://a/only_s3", "s3://b/only_s3"}, store.reset())
+
+	//pinned replica is tried first
+	_, _, _ = m.StreamAt("data/only_s3", 0, 3)
+	assert.Equal(t, []string{"s3://b/only_s3"}, store.reset())
+
+	//missing everywhere
+	_, _, err = m.StreamAt("data/missing", 0, 3)
+	assert.True(t, errors.Is(err, syscall.ENOENT))
+	assert.Len(t, store.reset(), 2)
+
+	//fallback on errors, non-ENOENT errors take precedence
+	store.errs["gs://a/obj"] = errRandom
+	r, _, err = m.StreamAt("data/obj", 0, 3)
+	assert.NoError(t, err)
+	r.Close()
+	store.errs["gs://a/missing"] = errRandom
+	_, _, err = m.StreamAt("data/missing", 0, 3)
+	assert.Equal(t, errRandom, err)
+	store.reset()
+
+	//EOF is not a fallback
+	_, size, err = m.StreamAt("data/obj", 20, 3)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, int64(10), size)
+
+	//replicas with a different size are skipped
+	delete(store.errs, "gs://a/obj")
+	r, size, err = m.StreamAt("data/resized", 0, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(10), size)
+	r.Close()
+	store.errs["gs://a/resized"] = tempErr{}
+	_, _, err = m.StreamAt("data/resized", 0, 3)
+	assert.Equal(t, tempErr{}, err)
+	store.errs["gs://a/resized"] = ErrCircuitOpen
+	_, _, err = m.StreamAt("data/resized", 0, 3)
+	assert.True(t, errors.Is(err, ErrCircuitOpen))
+	delete(store.errs, "gs://a/resized")
+	store.objects["gs://a/resized"] = []byte("012345")
+	_, _, err = m.StreamAt("data/resized", 0, 3)
+	assert.True(t, errors.Is(err, ErrReplicaMismatch))
+	store.reset()
+
+	//through an adapter
+	a, _ := NewAdapter(m, BlockSize("4"))
+	buf = make([]byte, 10)
+	n, err := a.ReadAt("data/obj", buf, 0)
+	assert.Equal(t, 10, n)
+	assert.True(t, err == nil || err == io.EOF)
+	assert.Equal(t, "0123456789", string(buf))
+}
+
+func TestMirrorLatency(t *testing.T) {
+	store := &replicaStore{
+		objects: map[string][]byte{
+			"gs://a/obj": []byte("0123456789"),
+			"s3://b/obj": []byte("0123456789"),
+		},
+	}
+	m, _ := NewMirror(store, Replicas("", "gs://a/", "s3://b/"), LatencyOrdering(), PinnedKeys(1))
+	m.latencies["a"] = 100 * time.Millisecond
+	m.latencies["b"] = 10 * time.Millisecond
+	r, _, err := m.StreamAt("obj", 0, 3)
+	assert.NoError(t, err)
+	r.Close()
+	assert.Equal(t, []string{"s3://b/obj"}, store.reset())
+	assert.Less(t, int64(m.latencies["b"]), int64(10*time.Millisecond))
+
+	//unmeasured hosts are tried first
+	m, _ = NewMirror(store, Replicas("", "gs://a/", "s3://b/", "s3://c/"), LatencyOrdering())
+	m.latencies["a"] = 100 * time.Millisecond
+	m.latencies["b"] = 10 * time.Millisecond
+	replicas, _ := m.order("obj")
+	assert.Equal(t, []string{"s3://c/obj", "s3://b/obj", "gs://a/obj"}, replicas)
+
+	//failing replicas are tried last
+	store.errs = map[string]error{"s3://c/obj": errRandom}
+	r, _, err = m.StreamAt("obj", 0, 3)
+	assert.NoError(t, err)
+	r.Close()
+	assert.Equal(t, []string{"s3://c/obj", "s3://b/obj"}, store.reset())
+	assert.Equal(t, failedReplicaLatency, m.latencies["c"])
+	replicas, _ = m.order("other")
+	assert.Equal(t, []string{"s3://b/other", "gs://a/other", "s3://c/other"}, replicas)
+}
+
+// sizelessStore only returns the size of the objects for requests at offset 0
+type sizelessStore struct {
+	*replicaStore
+}
+
+func (s sizelessStore) StreamAt(key string, off int64, n int64) (io.ReadCloser, int64, error) {
+	r, size, err := s.replicaStore.StreamAt(key, off, n)
+	if off > 0 {
+		size = 0
+	}
+	return r, size, err
+}
+
+func TestMirrorUnknownSize(t *testing.T) {
+	store := &replicaStore{
+		objects: map[string][]byte{
+			"gs://a/obj": []byte("0123456789"),
+			"s3://b/obj": []byte("0123456789"),
+		},
+	}
+	m, _ := NewMirror(sizelessStore{store}, Replicas("data/", "gs://a/", "s3://b/"))
+
+	//an unknown size does not pin the replica
+	r, size, err := m.StreamAt("data/obj", 2, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), size)
+	r.Close()
+	_, ok := m.pinned.Get("data/obj")
+	assert.False(t, ok)
+
+	r, size, err = m.StreamAt("data/obj", 0, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(10), size)
+	r.Close()
+
+	//and is not a mismatch with the pinned size
+	r, size, err = m.StreamAt("data/obj", 4, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), size)
+	buf, _ := ioutil.ReadAll(r)
+	assert.Equal(t, "456", string(buf))
+	assert.Equal(t, []string{"gs://a/obj", "gs://a/obj", "gs://a/obj"}, store.reset())
+
+	a, _ := NewAdapter(m, BlockSize("4"))
+	buf = make([]byte, 10)
+	n, err := a.ReadAt("data/obj", buf, 0)
+	assert.Equal(t, 10, n)
+	assert.True(t, err == nil || err == io.EOF)
+	assert.Equal(t, "0123456789", string(buf))
+
+	//falling back mid-object checks the size of the replica
+	store.objects["s3://b/obj"] = []byte("012345")
+	store.errs = map[string]error{"gs://a/obj": errRandom}
+	store.reset()
+	_, _, err = m.StreamAt("data/obj", 4, 3)
+	assert.Equal(t, errRandom, err)
+	assert.Equal(t, []string{"gs://a/obj", "s3://b/obj", "s3://b/obj"}, store.reset())
+	pin, _ := m.pinned.Get("data/obj")
+	assert.Equal(t, "gs://a/obj", pin.(pinnedReplica).key)
+	store.objects["s3://b/obj"] = []byte("0123456789")
+	r, _, err = m.StreamAt("data/obj", 4, 3)
+	assert.NoError(t, err)
+	buf, _ = ioutil.ReadAll(r)
+	assert.Equal(t, "456", string(buf))
+	assert.Equal(t, []string{"gs://a/obj", "s3://b/obj", "s3://b/obj"}, store.reset())
+	pin, _ = m.pinned.Get("data/obj")
+	assert.Equal(t, "s3://b/obj", pin.(pinnedReplica).key)
+}
+
+// versionedStore is a replicaStore returning the replica key and object size as the version of
+// its objects
+type versionedStore struct {
+	*replicaStore
+}
+
+func (s versionedStore) StreamAtContext(ctx context.Context, key string, off int64, n int64) (io.ReadCloser, int64, error) {
+	return s.replicaStore.StreamAt(key, off, n)
+}
+
+func (s versionedStore) StreamAtVersion(ctx context.Context, key string, off int64, n int64, version string) (io.ReadCloser, int64, string, error) {
+	r, size, err := s.replicaStore.StreamAt(key, off, n)
+	cur := fmt.Sprintf("%s@%d", key, size)
+	if err == nil && version != "" && version != cur {
+		r.Close()
+		return nil, 0, "", fmt.Errorf("%s: %w", version, ErrObjectChanged)
+	}
+	return r, size, cur, err
+}
+
+func TestMirrorVersion(t *testing.T) {
+	store := &replicaStore{
+		objects: map[string][]byte{
+			"gs://a/obj": []byte("0123456789"),
+			"s3://b/obj": []byte("0123456789"),
+		},
+		errs: map[string]error{},
+	}
+	m, _ := NewMirror(versionedStore{store}, Replicas("data/", "gs://a/", "s3://b/"))
+
+	r, _, version, err := m.StreamAtVersion(context.Background(), "data/obj", 0, 3, "")
+	assert.NoError(t, err)
+	r.Close()
+	assert.Equal(t, "gs://a/obj@10", version)
+	store.reset()
+
+	//versions are only requested from the replica that served them
+	store.errs["gs://a/obj"] = errRandom
+	_, _, _, err = m.StreamAtVersion(context.Background(), "data/obj", 4, 3, version)
+	assert.Equal(t, errRandom, err)
+	assert.Equal(t, []string{"gs://a/obj"}, store.reset())
+
+	//and changed objects are not a fallback
+	m.pinned.Purge()
+	delete(store.errs, "gs://a/obj")
+	store.objects["gs://a/obj"] = []byte("01234567")
+	_, _, _, err = m.StreamAtVersion(context.Background(), "data/obj", 4, 3, version)
+	assert.True(t, errors.Is(err, ErrObjectChanged))
+	assert.Equal(t, []string{"gs://a/obj"}, store.reset())
+
+	//through an adapter
+	store.objects["gs://a/obj"] = []byte("0123456789")
+	a, _ := NewAdapter(m, BlockSize("4"))
+	ar, err := a.Reader("data/obj")
+	assert.NoError(t, err)
+	assert.Equal(t, "gs://a/obj@10", ar.Version())
+}
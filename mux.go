@@ -0,0 +1,142 @@
+// Copyright 2021 Airbus Defence and Space
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package osio
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ErrUnknownScheme is returned by a Mux for keys that do not match any of its
+// registered prefixes
+var ErrUnknownScheme = errors.New("no handler registered for key")
+
+type muxEntry struct {
+	prefix  string
+	handler KeyStreamerAt
+}
+
+// Mux is a KeyStreamerAt that routes each request to the KeyStreamerAt registered
+// for the longest matching key prefix, e.g.:
+//
+//	mux := osio.NewMux()
+//	mux.Register("gs://", gcsHandler)
+//	mux.Register("s3://", s3Handler)
+//	mux.Register("https://", httpHandler)
+//	adapter, _ := osio.NewAdapter(mux)
+//
+// This allows a single Adapter, and therefore a single block cache and size cache,
+// to serve objects from every backend. Keys are passed unmodified to the handlers.
+type Mux struct {
+	mu      sync.RWMutex
+	entries []muxEntry
+}
+
+var (
+	_ KeyVersionStreamerAt = &Mux{}
+	_ KeyLister            = &Mux{}
+	_ ListingChecker       = &Mux{}
+)
+
+// NewMux creates an empty Mux
+func NewMux() *Mux {
+	return &Mux{}
+}
+
+// Register routes keys starting with prefix to handler. The prefix is typically a
+// scheme such as "gs://", but may be any string, e.g. "https://storage.googleapis.com/".
+// Register returns an error if a handler is already registered for prefix.
+func (m *Mux) Register(prefix string, handler KeyStreamerAt) error {
+	if prefix == "" {
+		return fmt.Errorf("prefix must not be empty")
+	}
+	if handler == nil {
+		return fmt.Errorf("handler must not be nil")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, e := range m.entries {
+		if e.prefix == prefix {
+			return fmt.Errorf("a handler is already registered for %s", prefix)
+		}
+	}
+	m.entries = append(m.entries, muxEntry{prefix: prefix, handler: handler})
+	sort.SliceStable(m.entries, func(i, j int) bool {
+		return len(m.entries[i].prefix) > len(m.entries[j].prefix)
+	})
+	return nil
+}
+
+// Handler returns the KeyStreamerAt that serves key
+func (m *Mux) Handler(key string) (KeyStreamerAt, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	for _, e := range m.entries {
+		if strings.HasPrefix(key, e.prefix) {
+			return e.handler, nil
+		}
+	}
+	return nil, fmt.Errorf("%s: %w", key, ErrUnknownScheme)
+}
+
+// StreamAt implements KeyStreamerAt
+func (m *Mux) StreamAt(key string, off int64, n int64) (io.ReadCloser, int64, error) {
+	return m.StreamAtContext(context.Background(), key, off, n)
+}
+
+// StreamAtContext implements KeyStreamerAtContext. The context is forwarded to the
+// selected handler if it implements KeyStreamerAtContext
+func (m *Mux) StreamAtContext(ctx context.Context, key string, off int64, n int64) (io.ReadCloser, int64, error) {
+	h, err := m.Handler(key)
+	if err != nil {
+		return nil, 0, err
+	}
+	return StreamAtContext(ctx, h, key, off, n)
+}
+
+// StreamAtVersion implements KeyVersionStreamerAt. Versions are only supported for handlers
+// implementing KeyVersionStreamerAt
+func (m *Mux) StreamAtVersion(ctx context.Context, key string, off int64, n int64, version string) (io.ReadCloser, int64, string, error) {
+	h, err := m.Handler(key)
+	if err != nil {
+		return nil, 0, "", err
+	}
+	return StreamAtVersion(ctx, h, key, off, n, version)
+}
+
+// ListObjects implements KeyLister, forwarding the listing to the handler that serves prefix.
+// Listing is only supported for handlers implementing KeyLister
+func (m *Mux) ListObjects(ctx context.Context, prefix, delimiter string) ObjectIterator {
+	h, err := m.Handler(prefix)
+	if err != nil {
+		return ErrorIterator(err)
+	}
+	return ListObjects(ctx, h, prefix, delimiter)
+}
+
+// CanList implements ListingChecker, telling whether the handler that serves prefix can list
+// its objects
+func (m *Mux) CanList(prefix string) bool {
+	h, err := m.Handler(prefix)
+	if err != nil {
+		return false
+	}
+	return CanList(h, prefix)
+}
@@ -0,0 +1,67 @@
+// Copyright 2021 Airbus Defence and Space
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package osio
+
+import (
+	"context"
+	"io"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMux(t *testing.T) {
+	mux := NewMux()
+	assert.Error(t, mux.Register("", rr))
+	assert.Error(t, mux.Register("gs://", nil))
+	assert.NoError(t, mux.Register("gs://", TReader{[]byte("gs")}))
+	assert.NoError(t, mux.Register("s3://", TReader{[]byte("s3")}))
+	assert.NoError(t, mux.Register("https://", TReader{[]byte("https")}))
+	assert.NoError(t, mux.Register("https://storage.googleapis.com/", TReader{[]byte("https-gs")}))
+	assert.Error(t, mux.Register("s3://", rr))
+
+	bc, _ := NewAdapter(mux)
+	for key, expected := range map[string]string{
+		"gs://bucket/object":                           "gs",
+		"s3://bucket/object":                           "s3",
+		"https://example.com/object":                   "https",
+		"https://storage.googleapis.com/bucket/object": "https-gs",
+	} {
+		r, err := bc.Reader(key)
+		assert.NoError(t, err)
+		data, err := io.ReadAll(r)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, string(data))
+	}
+
+	_, err := bc.Reader("az://container/blob")
+	assert.ErrorIs(t, err, ErrUnknownScheme)
+	_, err = bc.Reader("bucket/object")
+	assert.ErrorIs(t, err, ErrUnknownScheme)
+
+	assert.NoError(t, mux.Register("enoent", rr))
+	_, err = bc.Reader("enoent")
+	assert.ErrorIs(t, err, syscall.ENOENT)
+
+	//context is forwarded to handlers implementing KeyStreamerAtContext
+	calls := int32(0)
+	assert.NoError(t, mux.Register("ctx://", CReader{TReader: rr, delay: time.Second, calls: &calls}))
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = bc.ReaderContext(ctx, "ctx://object")
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
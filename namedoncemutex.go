@@ -23,31 +23,35 @@
 
 package osio
 
-import "sync"
+import (
+	"context"
+	"sync"
+)
 
 // onceMutex is a mutex that can be locked only once.
-// Lock operation returns true if mutex has been successfully locked.
-// Any other concurrent attempts will block until mutex is unlocked.
-// However, any other attempts to grab a lock will return false.
+// It is created locked, and any attempt to wait on it will block until the mutex is unlocked,
+// after which all waits return immediately.
 type onceMutex struct {
-	mu   sync.Mutex
-	used bool
+	done chan struct{}
 }
 
-// Lock tries to acquire lock.
-func (om *onceMutex) Lock() bool {
-	om.mu.Lock()
-	if om.used {
-		om.mu.Unlock()
-		return false
+func newOnceMutex() *onceMutex {
+	return &onceMutex{done: make(chan struct{})}
+}
+
+// Wait blocks until the mutex is unlocked, or until ctx is done.
+func (om *onceMutex) Wait(ctx context.Context) error {
+	select {
+	case <-om.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
 	}
-	return true
 }
 
-// Unlock tries to release a lock.
+// Unlock releases the lock.
 func (om *onceMutex) Unlock() {
-	om.used = true
-	om.mu.Unlock()
+	close(om.done)
 }
 
 // NamedOnceMutex is a map of dynamically created mutexes by provided id.
@@ -72,18 +76,24 @@ func newNamedOnceMutex() *namedOnceMutex {
 // Lock try to acquire a lock for provided id. If attempt is successful, true is returned
 // If lock is already acquired by something else it will block until mutex is unlocked returning false.
 func (nom *namedOnceMutex) Lock(useMutexKey interface{}) bool {
+	locked, _ := nom.LockContext(context.Background(), useMutexKey)
+	return locked
+}
+
+// LockContext behaves like Lock, but stops waiting for the mutex to be unlocked if ctx is done,
+// in which case it returns false and the context's error. Giving up does not release the lock
+// held by the current owner.
+func (nom *namedOnceMutex) LockContext(ctx context.Context, useMutexKey interface{}) (bool, error) {
 	nom.mutex.Lock()
 	m, ok := nom.lockMap[useMutexKey]
 	if ok {
 		nom.mutex.Unlock()
-		return m.Lock()
+		return false, m.Wait(ctx)
 	}
 
-	m = &onceMutex{}
-	m.Lock()
-	nom.lockMap[useMutexKey] = m
+	nom.lockMap[useMutexKey] = newOnceMutex()
 	nom.mutex.Unlock()
-	return true
+	return true, nil
 }
 
 // TryLock try to acquire a lock for provided id. If attempt is successful, true is returned
@@ -96,9 +106,7 @@ func (nom *namedOnceMutex) TryLock(useMutexKey interface{}) bool {
 		return false
 	}
 
-	m := &onceMutex{}
-	m.Lock()
-	nom.lockMap[useMutexKey] = m
+	nom.lockMap[useMutexKey] = newOnceMutex()
 	nom.mutex.Unlock()
 	return true
 }
@@ -15,6 +15,7 @@
 package osio
 
 import (
+	"context"
 	"testing"
 	"time"
 
@@ -61,3 +62,36 @@ func TestNamedOnceMutex(t *testing.T) {
 
 	n1.Unlock(key) //check second unlock
 }
+
+func TestNamedOnceMutexContext(t *testing.T) {
+	key := "foo"
+	n1 := newNamedOnceMutex()
+	l1, err := n1.LockContext(context.Background(), key)
+	assert.True(t, l1)
+	assert.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	l1, err = n1.LockContext(ctx, key)
+	assert.False(t, l1)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	//giving up must not have released the lock
+	assert.False(t, n1.TryLock(key))
+
+	ww := make(chan bool)
+	go func() {
+		l, err := n1.LockContext(context.Background(), key)
+		assert.False(t, l)
+		assert.NoError(t, err)
+		close(ww)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	n1.Unlock(key)
+	<-ww
+
+	l1, err = n1.LockContext(ctx, key)
+	assert.True(t, l1)
+	assert.NoError(t, err)
+	n1.Unlock(key)
+}
@@ -0,0 +1,223 @@
+// Copyright 2021 Airbus Defence and Space
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package osio
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"golang.org/x/time/rate"
+)
+
+// RateLimit is a request rate and bandwidth quota. Zero values are unlimited.
+type RateLimit struct {
+	// Requests is the number of requests allowed per second
+	Requests float64
+	// RequestBurst is the number of requests that may be sent at once. Defaults to Requests,
+	// and at least 1
+	RequestBurst int
+	// Bytes is the number of bytes that may be read per second
+	Bytes float64
+	// ByteBurst is the number of bytes that may be read at once. Defaults to Bytes
+	ByteBurst int
+}
+
+func (l RateLimit) validate() error {
+	if l.Requests < 0 || l.Bytes < 0 || l.RequestBurst < 0 || l.ByteBurst < 0 {
+		return fmt.Errorf("rate limits must be >= 0")
+	}
+	return nil
+}
+
+type hostLimiter struct {
+	requests *rate.Limiter
+	bytes    *rate.Limiter
+}
+
+func newHostLimiter(l RateLimit) *hostLimiter {
+	hl := &hostLimiter{}
+	if l.Requests > 0 {
+		burst := l.RequestBurst
+		if burst == 0 {
+			burst = int(l.Requests)
+		}
+		if burst < 1 {
+			burst = 1
+		}
+		hl.requests = rate.NewLimiter(rate.Limit(l.Requests), burst)
+	}
+	if l.Bytes > 0 {
+		burst := l.ByteBurst
+		if burst == 0 {
+			burst = int(l.Bytes)
+		}
+		if burst < 1 {
+			burst = 1
+		}
+		hl.bytes = rate.NewLimiter(rate.Limit(l.Bytes), burst)
+	}
+	return hl
+}
+
+// RateLimiter is a KeyStreamerAt that throttles the requests made to another KeyStreamerAt,
+// and the bandwidth used to read the returned streams, with token buckets. Quotas apply per
+// host (or bucket), and are enforced by delaying the requests and the reads rather than by
+// failing them.
+type RateLimiter struct {
+	ks       KeyStreamerAt
+	limit    RateLimit
+	hosts    map[string]RateLimit
+	mu       sync.Mutex
+	limiters map[string]*hostLimiter
+}
+
+var (
+	_ KeyVersionStreamerAt = &RateLimiter{}
+	_ KeyLister            = &RateLimiter{}
+	_ ListingChecker       = &RateLimiter{}
+)
+
+// RateLimiterOption is an option that can be passed to NewRateLimiter
+type RateLimiterOption func(rl *RateLimiter) error
+
+// HostRateLimit overrides the default RateLimit for the keys of host, e.g. "example.com" for
+// "https://example.com/object" or "bucket" for "gs://bucket/object"
+func HostRateLimit(host string, limit RateLimit) RateLimiterOption {
+	return func(rl *RateLimiter) error {
+		if host == "" {
+			return fmt.Errorf("host must not be empty")
+		}
+		if err := limit.validate(); err != nil {
+			return err
+		}
+		rl.hosts[host] = limit
+		return nil
+	}
+}
+
+// NewRateLimiter creates a RateLimiter applying limit to each host served by ks
+func NewRateLimiter(ks KeyStreamerAt, limit RateLimit, opts ...RateLimiterOption) (*RateLimiter, error) {
+	if err := limit.validate(); err != nil {
+		return nil, err
+	}
+	rl := &RateLimiter{
+		ks:       ks,
+		limit:    limit,
+		hosts:    make(map[string]RateLimit),
+		limiters: make(map[string]*hostLimiter),
+	}
+	for _, o := range opts {
+		if err := o(rl); err != nil {
+			return nil, err
+		}
+	}
+	return rl, nil
+}
+
+// keyHost returns the host or bucket part of key
+func keyHost(key string) string {
+	if idx := strings.Index(key, "://"); idx >= 0 {
+		key = key[idx+3:]
+	}
+	if idx := strings.IndexByte(key, '/'); idx >= 0 {
+		key = key[:idx]
+	}
+	return key
+}
+
+func (rl *RateLimiter) limiter(key string) *hostLimiter {
+	host := keyHost(key)
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	hl, ok := rl.limiters[host]
+	if !ok {
+		limit, ok := rl.hosts[host]
+		if !ok {
+			limit = rl.limit
+		}
+		hl = newHostLimiter(limit)
+		rl.limiters[host] = hl
+	}
+	return hl
+}
+
+// StreamAt implements KeyStreamerAt
+func (rl *RateLimiter) StreamAt(key string, off int64, n int64) (io.ReadCloser, int64, error) {
+	return rl.StreamAtContext(context.Background(), key, off, n)
+}
+
+// StreamAtContext implements KeyStreamerAtContext. Waits for a request or for bandwidth are
+// aborted once ctx is done
+func (rl *RateLimiter) StreamAtContext(ctx context.Context, key string, off int64, n int64) (io.ReadCloser, int64, error) {
+	r, size, _, err := rl.StreamAtVersion(ctx, key, off, n, "")
+	return r, size, err
+}
+
+// StreamAtVersion implements KeyVersionStreamerAt
+func (rl *RateLimiter) StreamAtVersion(ctx context.Context, key string, off int64, n int64, version string) (io.ReadCloser, int64, string, error) {
+	hl := rl.limiter(key)
+	if hl.requests != nil {
+		if err := hl.requests.Wait(ctx); err != nil {
+			return nil, 0, "", err
+		}
+	}
+	r, size, v, err := StreamAtVersion(ctx, rl.ks, key, off, n, version)
+	if r != nil && hl.bytes != nil {
+		r = &throttledReader{ReadCloser: r, ctx: ctx, limiter: hl.bytes}
+	}
+	return r, size, v, err
+}
+
+// ListObjects implements KeyLister. A listing consumes a single request of the quota of the host
+// of prefix, whatever the number of pages it spans.
+func (rl *RateLimiter) ListObjects(ctx context.Context, prefix, delimiter string) ObjectIterator {
+	hl := rl.limiter(prefix)
+	if hl.requests != nil {
+		if err := hl.requests.Wait(ctx); err != nil {
+			return ErrorIterator(err)
+		}
+	}
+	return ListObjects(ctx, rl.ks, prefix, delimiter)
+}
+
+// CanList implements ListingChecker
+func (rl *RateLimiter) CanList(prefix string) bool {
+	return CanList(rl.ks, prefix)
+}
+
+// throttledReader delays reads once the bandwidth quota has been consumed
+type throttledReader struct {
+	io.ReadCloser
+	ctx     context.Context
+	limiter *rate.Limiter
+}
+
+func (tr *throttledReader) Read(buf []byte) (int, error) {
+	n, err := tr.ReadCloser.Read(buf)
+	for consumed := n; consumed > 0; {
+		tokens := consumed
+		if burst := tr.limiter.Burst(); tokens > burst {
+			tokens = burst
+		}
+		if werr := tr.limiter.WaitN(tr.ctx, tokens); werr != nil {
+			return n, werr
+		}
+		consumed -= tokens
+	}
+	return n, err
+}
@@ -0,0 +1,79 @@
+// Copyright 2021 Airbus Defence and Space
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package osio
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyHost(t *testing.T) {
+	assert.Equal(t, "example.com", keyHost("https://example.com/path/object"))
+	assert.Equal(t, "bucket", keyHost("gs://bucket/object"))
+	assert.Equal(t, "bucket", keyHost("bucket/object"))
+	assert.Equal(t, "object", keyHost("object"))
+}
+
+func TestRateLimiter(t *testing.T) {
+	_, err := NewRateLimiter(rr, RateLimit{Requests: -1})
+	assert.Error(t, err)
+	_, err = NewRateLimiter(rr, RateLimit{}, HostRateLimit("", RateLimit{}))
+	assert.Error(t, err)
+	_, err = NewRateLimiter(rr, RateLimit{}, HostRateLimit("a", RateLimit{Bytes: -1}))
+	assert.Error(t, err)
+
+	data := make([]byte, 1000)
+	src := TReader{data}
+	rl, _ := NewRateLimiter(src, RateLimit{Requests: 20, RequestBurst: 1},
+		HostRateLimit("fast", RateLimit{}),
+		HostRateLimit("narrow", RateLimit{Bytes: 1000, ByteBurst: 100}))
+
+	//request rate, per host
+	st := time.Now()
+	for i := 0; i < 5; i++ {
+		r, _, err := rl.StreamAt("gs://slow/object", 0, 10)
+		assert.NoError(t, err)
+		_ = r.Close()
+	}
+	assert.GreaterOrEqual(t, int64(time.Since(st)), int64(190*time.Millisecond))
+	st = time.Now()
+	for i := 0; i < 5; i++ {
+		r, _, err := rl.StreamAt("gs://fast/object", 0, 10)
+		assert.NoError(t, err)
+		_ = r.Close()
+	}
+	assert.Less(t, int64(time.Since(st)), int64(100*time.Millisecond))
+
+	//bandwidth
+	st = time.Now()
+	r, _, err := rl.StreamAt("gs://narrow/object", 0, 300)
+	assert.NoError(t, err)
+	buf, err := io.ReadAll(r)
+	assert.NoError(t, err)
+	assert.Len(t, buf, 300)
+	assert.GreaterOrEqual(t, int64(time.Since(st)), int64(190*time.Millisecond))
+
+	//through an adapter, waits are cancelled with the context
+	bc, _ := NewAdapter(rl, BlockSize("10"), SplitRanges(true))
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = bc.ReadAtContext(ctx, "gs://slow/object", make([]byte, 100), 0)
+	assert.Error(t, err)
+	assert.Less(t, int64(time.Since(st)), int64(time.Second))
+}
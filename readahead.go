@@ -0,0 +1,231 @@
+// Copyright 2021 Airbus Defence and Space
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package osio
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+type raao struct {
+	maxBlocks int
+}
+
+func (r raao) adapterOpt(a *Adapter) error {
+	if r.maxBlocks < 0 {
+		return fmt.Errorf("read-ahead must be >= 0")
+	}
+	a.readAhead = r.maxBlocks
+	return nil
+}
+
+// ReadAhead is an option to make the Readers returned by the Adapter prefetch up to maxBlocks
+// blocks in the background when they detect sequential calls to Read. The number of prefetched
+// blocks starts at one and doubles on each sequential Read, until maxBlocks is reached. It is
+// reset whenever the Reader is read from a non sequential position (i.e. after a Seek).
+//
+// Read-ahead is disabled by default, and can also be configured per Reader with
+// Reader.SetReadAhead
+func ReadAhead(maxBlocks int) interface {
+	AdapterOption
+} {
+	return raao{maxBlocks}
+}
+
+// readAhead holds the sequential access detection state of a Reader
+type readAhead struct {
+	max      int
+	window   int
+	next     int64 //offset at which a sequential Read would start
+	fetchEnd int64 //first block that has not been scheduled for prefetching
+}
+
+// SetReadAhead overrides the ReadAhead option of the Adapter for this Reader. Setting it to 0
+// disables read-ahead
+func (r *Reader) SetReadAhead(maxBlocks int) {
+	r.ra = readAhead{max: maxBlocks, next: r.off}
+}
+
+// readAhead is called after each successful Read that started at offset start, with r.off
+// pointing past the data that was just read
+func (r *Reader) readAhead(start int64) {
+	if r.ra.max <= 0 {
+		return
+	}
+	bs := r.a.blockSize
+	if start != r.ra.next {
+		//seek: restart with a small window
+		r.ra.window = 0
+		r.ra.fetchEnd = 0
+		r.ra.next = r.off
+		return
+	}
+	r.ra.next = r.off
+	if r.ra.window == 0 {
+		r.ra.window = 1
+	} else if r.ra.window < r.ra.max {
+		r.ra.window *= 2
+		if r.ra.window > r.ra.max {
+			r.ra.window = r.ra.max
+		}
+	}
+	if r.off >= r.size {
+		return
+	}
+	first := r.off / bs
+	if first < r.ra.fetchEnd {
+		first = r.ra.fetchEnd
+	}
+	last := r.off/bs + int64(r.ra.window)
+	if lastBlock := (r.size - 1) / bs; last > lastBlock {
+		last = lastBlock
+	}
+	if first > last {
+		return
+	}
+	r.ra.fetchEnd = last + 1
+	blocks := make([]int64, 0, last-first+1)
+	for id := first; id <= last; id++ {
+		blocks = append(blocks, id)
+	}
+	go func() {
+		_ = r.a.fetchBlocks(r.ctx, r.key, blocks)
+	}()
+}
+
+type prefetchOptions struct {
+	wait bool
+}
+
+// PrefetchOption is an option that can be passed to Adapter.Prefetch
+type PrefetchOption func(o *prefetchOptions)
+
+// PrefetchWait makes Prefetch return once all the blocks have been fetched, instead of
+// returning immediately.
+func PrefetchWait() PrefetchOption {
+	return func(o *prefetchOptions) {
+		o.wait = true
+	}
+}
+
+// Prefetch populates the block cache with the blocks covering the byte ranges
+// [offsets[i],offsets[i]+lengths[i]) of the object identified by key, e.g. the tiles of a
+// cogeotiff that will be read shortly. Blocks that are already cached are skipped, and blocks
+// that are being fetched by a concurrent ReadAt are not requested a second time. Ranges
+// extending past the end of the object are truncated.
+//
+// By default Prefetch returns immediately and errors are discarded: the blocks that failed to be
+// fetched will be requested again by subsequent reads. With the PrefetchWait option, Prefetch
+// returns once all the blocks have been fetched, along with the first encountered error.
+// The fetches are aborted once ctx is done, whatever the mode.
+func (a *Adapter) Prefetch(ctx context.Context, key string, offsets, lengths []int64, opts ...PrefetchOption) error {
+	if len(offsets) != len(lengths) {
+		return fmt.Errorf("offsets and lengths must have the same size")
+	}
+	for i := range offsets {
+		if offsets[i] < 0 || lengths[i] < 0 {
+			return fmt.Errorf("invalid range %d-%d", offsets[i], lengths[i])
+		}
+	}
+	po := prefetchOptions{}
+	for _, o := range opts {
+		o(&po)
+	}
+	prefetch := func() error {
+		size, err := a.SizeContext(ctx, key)
+		if err != nil {
+			return err
+		}
+		blids := make(map[int64]bool)
+		for i := range offsets {
+			end := offsets[i] + lengths[i]
+			if end > size {
+				end = size
+			}
+			for ib := offsets[i] / a.blockSize; ib*a.blockSize < end; ib++ {
+				blids[ib] = true
+			}
+		}
+		blocks := make([]int64, 0, len(blids))
+		for k := range blids {
+			blocks = append(blocks, k)
+		}
+		sort.Slice(blocks, func(i, j int) bool {
+			return blocks[i] < blocks[j]
+		})
+		return a.fetchBlocks(ctx, key, blocks)
+	}
+	if po.wait {
+		return prefetch()
+	}
+	go func() {
+		_ = prefetch()
+	}()
+	return nil
+}
+
+// Prefetch behaves like Adapter.Prefetch, using the context and version of the Reader
+func (r *Reader) Prefetch(offsets, lengths []int64, opts ...PrefetchOption) error {
+	return r.a.Prefetch(r.ctx, r.key, offsets, lengths, opts...)
+}
+
+// fetchBlocks populates the block cache with the given sorted blocks of key that are not already
+// cached. Consecutive missing blocks are merged into single requests unless SplitRanges is set,
+// and the requests are issued concurrently.
+func (a *Adapter) fetchBlocks(ctx context.Context, key string, blocks []int64) error {
+	ctx = withVersion(ctx, a.pinnedVersion(ctx, key))
+	ckey := blockCacheKey(ctx, key)
+	var err error
+	errmu := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	fetch := func(rng blockRange) {
+		defer wg.Done()
+		var ferr error
+		if a.splitRanges {
+			_, ferr = a.getBlock(ctx, key, rng.start)
+		} else {
+			_, ferr = a.getRange(ctx, key, rng)
+		}
+		if ferr != nil {
+			errmu.Lock()
+			if err == nil {
+				err = ferr
+			}
+			errmu.Unlock()
+		}
+	}
+	rng := blockRange{start: -1}
+	for i, id := range blocks {
+		if _, ok := a.cache.Get(ckey, uint(id)); !ok {
+			if rng.start == -1 {
+				rng = blockRange{start: id, end: id}
+			} else {
+				rng.end = id
+			}
+		}
+		if rng.start == -1 {
+			continue
+		}
+		if a.splitRanges || i == len(blocks)-1 || blocks[i+1] != id+1 || rng.end != id {
+			wg.Add(1)
+			go fetch(rng)
+			rng.start = -1
+		}
+	}
+	wg.Wait()
+	return err
+}
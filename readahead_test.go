@@ -0,0 +1,138 @@
+// Copyright 2021 Airbus Defence and Space
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package osio
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func cached(c BlockCacher, key string, ids ...uint) bool {
+	for _, id := range ids {
+		if _, ok := c.Get(key, id); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+func TestReadAhead(t *testing.T) {
+	_, err := NewAdapter(rr, ReadAhead(-1))
+	assert.Error(t, err)
+
+	data := make([]byte, 100)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	src := TReader{data}
+	cache, _ := NewByteLRUCache(1000)
+	bc, _ := NewAdapter(src, BlockSize("10"), BlockCache(cache), ReadAhead(4))
+	r, _ := bc.Reader("key")
+	buf := make([]byte, 5)
+
+	//first read: one block ahead
+	_, _ = r.Read(buf)
+	assert.Eventually(t, func() bool { return cached(cache, "key", 1) }, time.Second, time.Millisecond)
+	//window grows to 2 blocks
+	_, _ = r.Read(buf)
+	assert.Eventually(t, func() bool { return cached(cache, "key", 2, 3) }, time.Second, time.Millisecond)
+	time.Sleep(10 * time.Millisecond)
+	assert.False(t, cached(cache, "key", 4))
+
+	//seeking resets the window
+	_, _ = r.Seek(80, io.SeekStart)
+	_, _ = r.Read(buf)
+	time.Sleep(10 * time.Millisecond)
+	assert.False(t, cached(cache, "key", 9))
+	_, _ = r.Read(buf)
+	assert.Eventually(t, func() bool { return cached(cache, "key", 9) }, time.Second, time.Millisecond)
+
+	//disabled per reader
+	cache.Purge()
+	r, _ = bc.Reader("key")
+	r.SetReadAhead(0)
+	_, _ = r.Read(buf)
+	_, _ = r.Read(buf)
+	time.Sleep(10 * time.Millisecond)
+	assert.False(t, cached(cache, "key", 1))
+
+	//enabled per reader, data is unchanged
+	cache.Purge()
+	bc, _ = NewAdapter(src, BlockSize("10"), BlockCache(cache))
+	r, _ = bc.Reader("key")
+	r.SetReadAhead(8)
+	got := bytes.Buffer{}
+	_, err = io.CopyBuffer(&got, struct{ io.Reader }{r}, make([]byte, 7))
+	assert.NoError(t, err)
+	assert.Equal(t, data, got.Bytes())
+}
+
+func TestPrefetch(t *testing.T) {
+	ctx := context.Background()
+	data := make([]byte, 100)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	calls := int32(0)
+	src := CReader{TReader: TReader{data}, delay: 10 * time.Millisecond, calls: &calls}
+	bc, _ := NewAdapter(src, BlockSize("10"))
+
+	assert.Error(t, bc.Prefetch(ctx, "key", []int64{0}, []int64{}))
+	assert.Error(t, bc.Prefetch(ctx, "key", []int64{-1}, []int64{10}))
+	assert.ErrorIs(t, bc.Prefetch(ctx, "enoent", []int64{0}, []int64{10}, PrefetchWait()), syscall.ENOENT)
+
+	//block 0 is fetched by the size lookup, then blocks 1, 4 and 9. End of file is ignored
+	atomic.StoreInt32(&calls, 0)
+	err := bc.Prefetch(ctx, "key", []int64{5, 40, 95}, []int64{10, 5, 100}, PrefetchWait())
+	assert.NoError(t, err)
+	assert.Equal(t, int32(4), atomic.LoadInt32(&calls))
+	buf := make([]byte, 15)
+	_, err = bc.ReadAt("key", buf, 5)
+	assert.NoError(t, err)
+	assert.Equal(t, data[5:20], buf)
+	_, err = bc.ReadAt("key", buf[:5], 40)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(4), atomic.LoadInt32(&calls))
+
+	//consecutive missing blocks are fetched in a single request
+	atomic.StoreInt32(&calls, 0)
+	assert.NoError(t, bc.Prefetch(ctx, "key", []int64{20}, []int64{20}, PrefetchWait()))
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	//async prefetch is not fetched twice by a concurrent read
+	atomic.StoreInt32(&calls, 0)
+	st := time.Now()
+	assert.NoError(t, bc.Prefetch(ctx, "key", []int64{60}, []int64{20}))
+	assert.Less(t, int64(time.Since(st)), int64(5*time.Millisecond))
+	time.Sleep(2 * time.Millisecond)
+	_, err = bc.ReadAt("key", buf, 62)
+	assert.NoError(t, err)
+	assert.Equal(t, data[62:77], buf)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	//split ranges
+	bc, _ = NewAdapter(src, BlockSize("10"), SplitRanges(true))
+	r, _ := bc.Reader("key") //fetches block 0
+	atomic.StoreInt32(&calls, 0)
+	assert.NoError(t, r.Prefetch([]int64{0}, []int64{30}, PrefetchWait()))
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
@@ -0,0 +1,567 @@
+// Copyright 2021 Airbus Defence and Space
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package osio
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// ErrNotRecorded is returned by a Replayer for the requests that are not in its fixtures
+var ErrNotRecorded = errors.New("request not recorded")
+
+// Error kinds stored in the fixtures
+const (
+	recordedENOENT  = "enoent"
+	recordedEOF     = "eof"
+	recordedTrunc   = "unexpected_eof"
+	recordedChanged = "changed"
+	recordedOther   = "other"
+)
+
+type recordedError struct {
+	Kind      string `json:"kind"`
+	Message   string `json:"message,omitempty"`
+	Temporary bool   `json:"temporary,omitempty"`
+}
+
+// replayedError is an error that is not otherwise known to osio, replayed from a fixture
+type replayedError struct {
+	msg       string
+	temporary bool
+}
+
+func (e *replayedError) Error() string   { return e.msg }
+func (e *replayedError) Temporary() bool { return e.temporary }
+
+func recordError(err error) *recordedError {
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, syscall.ENOENT):
+		return &recordedError{Kind: recordedENOENT}
+	case errors.Is(err, io.EOF):
+		return &recordedError{Kind: recordedEOF}
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return &recordedError{Kind: recordedTrunc}
+	case errors.Is(err, ErrObjectChanged):
+		return &recordedError{Kind: recordedChanged, Message: err.Error()}
+	}
+	return &recordedError{Kind: recordedOther, Message: err.Error(), Temporary: DefaultRetryable(err)}
+}
+
+func (re *recordedError) err() error {
+	if re == nil {
+		return nil
+	}
+	switch re.Kind {
+	case recordedENOENT:
+		return syscall.ENOENT
+	case recordedEOF:
+		return io.EOF
+	case recordedTrunc:
+		return io.ErrUnexpectedEOF
+	case recordedChanged:
+		return fmt.Errorf("%s: %w", re.Message, ErrObjectChanged)
+	}
+	return &replayedError{msg: re.Message, temporary: re.Temporary}
+}
+
+// interaction is a recorded StreamAt call
+type interaction struct {
+	Key     string `json:"key"`
+	Offset  int64  `json:"offset"`
+	Length  int64  `json:"length"`
+	Size    int64  `json:"size"`
+	Version string `json:"version,omitempty"`
+	// Error is the error returned by StreamAt
+	Error *recordedError `json:"error,omitempty"`
+	// Stream tells whether StreamAt returned a stream, which it may do along with io.EOF
+	Stream bool `json:"stream,omitempty"`
+	// Body holds the bytes read from the returned stream
+	Body []byte `json:"body,omitempty"`
+	// BodyError is the error that ended the stream. Streams closed before the requested
+	// length was read are recorded as ending with io.ErrUnexpectedEOF
+	BodyError *recordedError `json:"body_error,omitempty"`
+}
+
+// recordedObject is an object returned by a recorded listing
+type recordedObject struct {
+	Key     string     `json:"key"`
+	Size    int64      `json:"size,omitempty"`
+	Version string     `json:"version,omitempty"`
+	ModTime *time.Time `json:"mod_time,omitempty"`
+	Prefix  bool       `json:"prefix,omitempty"`
+}
+
+// listing is a recorded ListObjects call
+type listing struct {
+	Prefix    string           `json:"prefix"`
+	Delimiter string           `json:"delimiter,omitempty"`
+	Objects   []recordedObject `json:"objects,omitempty"`
+	// Error is the error that ended the listing, io.EOF once all the objects were returned.
+	// Listings that were not iterated to the end have no error.
+	Error *recordedError `json:"error,omitempty"`
+}
+
+type fixtures struct {
+	Interactions []*interaction `json:"interactions"`
+	Listings     []*listing     `json:"listings,omitempty"`
+}
+
+// Recorder is a KeyStreamerAt that records the calls made to another KeyStreamerAt, along
+// with the data they returned, so that they can be served offline by a Replayer.
+type Recorder struct {
+	ks           KeyStreamerAt
+	mu           sync.Mutex
+	interactions []*interaction
+	listings     []*listing
+}
+
+var (
+	_ KeyVersionStreamerAt = &Recorder{}
+	_ KeyLister            = &Recorder{}
+	_ ListingChecker       = &Recorder{}
+)
+
+// NewRecorder creates a Recorder around ks
+func NewRecorder(ks KeyStreamerAt) *Recorder {
+	return &Recorder{ks: ks}
+}
+
+// StreamAt implements KeyStreamerAt
+func (rec *Recorder) StreamAt(key string, off int64, n int64) (io.ReadCloser, int64, error) {
+	return rec.StreamAtContext(context.Background(), key, off, n)
+}
+
+// StreamAtContext implements KeyStreamerAtContext
+func (rec *Recorder) StreamAtContext(ctx context.Context, key string, off int64, n int64) (io.ReadCloser, int64, error) {
+	r, size, _, err := rec.StreamAtVersion(ctx, key, off, n, "")
+	return r, size, err
+}
+
+// StreamAtVersion implements KeyVersionStreamerAt. The body of the stream is recorded as it is
+// read, the interaction being complete once the stream is closed.
+func (rec *Recorder) StreamAtVersion(ctx context.Context, key string, off int64, n int64, version string) (io.ReadCloser, int64, string, error) {
+	r, size, v, err := StreamAtVersion(ctx, rec.ks, key, off, n, version)
+	it := &interaction{Key: key, Offset: off, Length: n, Size: size, Version: v, Error: recordError(err), Stream: r != nil}
+	rec.mu.Lock()
+	rec.interactions = append(rec.interactions, it)
+	rec.mu.Unlock()
+	if r == nil {
+		return r, size, v, err
+	}
+	return &recordingReader{rec: rec, it: it, r: r}, size, v, err
+}
+
+// ListObjects implements KeyLister. The listed objects are recorded as they are iterated over.
+func (rec *Recorder) ListObjects(ctx context.Context, prefix, delimiter string) ObjectIterator {
+	lst := &listing{Prefix: prefix, Delimiter: delimiter}
+	rec.mu.Lock()
+	rec.listings = append(rec.listings, lst)
+	rec.mu.Unlock()
+	return &recordingIterator{rec: rec, lst: lst, it: ListObjects(ctx, rec.ks, prefix, delimiter)}
+}
+
+// CanList implements ListingChecker
+func (rec *Recorder) CanList(prefix string) bool {
+	return CanList(rec.ks, prefix)
+}
+
+type recordingIterator struct {
+	rec *Recorder
+	lst *listing
+	it  ObjectIterator
+}
+
+func (ri *recordingIterator) Next() (ObjectAttrs, error) {
+	attrs, err := ri.it.Next()
+	ri.rec.mu.Lock()
+	defer ri.rec.mu.Unlock()
+	if ri.lst.Error != nil {
+		return attrs, err
+	}
+	if err != nil {
+		ri.lst.Error = recordError(err)
+		return attrs, err
+	}
+	obj := recordedObject{Key: attrs.Key, Size: attrs.Size, Version: attrs.Version, Prefix: attrs.Prefix}
+	if !attrs.ModTime.IsZero() {
+		mt := attrs.ModTime
+		obj.ModTime = &mt
+	}
+	ri.lst.Objects = append(ri.lst.Objects, obj)
+	return attrs, nil
+}
+
+type recordingReader struct {
+	rec  *Recorder
+	it   *interaction
+	r    io.ReadCloser
+	done bool
+}
+
+func (rr *recordingReader) Read(buf []byte) (int, error) {
+	n, err := rr.r.Read(buf)
+	rr.rec.mu.Lock()
+	defer rr.rec.mu.Unlock()
+	if !rr.done {
+		rr.it.Body = append(rr.it.Body, buf[:n]...)
+		if err != nil {
+			rr.done = true
+			if err != io.EOF {
+				rr.it.BodyError = recordError(err)
+			}
+		}
+	}
+	return n, err
+}
+
+func (rr *recordingReader) Close() error {
+	rr.rec.mu.Lock()
+	if !rr.done && int64(len(rr.it.Body)) < rr.it.Length {
+		rr.it.BodyError = recordError(io.ErrUnexpectedEOF)
+	}
+	rr.done = true
+	rr.rec.mu.Unlock()
+	return rr.r.Close()
+}
+
+// WriteTo writes the recorded interactions and listings as JSON fixtures to w. Interactions
+// are sorted by key, offset and length, and listings by prefix and delimiter, identical
+// requests being kept in the order they were made.
+func (rec *Recorder) WriteTo(w io.Writer) (int64, error) {
+	rec.mu.Lock()
+	fx := fixtures{
+		Interactions: append([]*interaction{}, rec.interactions...),
+		Listings:     append([]*listing(nil), rec.listings...),
+	}
+	sort.SliceStable(fx.Interactions, func(i, j int) bool {
+		a, b := fx.Interactions[i], fx.Interactions[j]
+		if a.Key != b.Key {
+			return a.Key < b.Key
+		}
+		if a.Offset != b.Offset {
+			return a.Offset < b.Offset
+		}
+		return a.Length < b.Length
+	})
+	sort.SliceStable(fx.Listings, func(i, j int) bool {
+		a, b := fx.Listings[i], fx.Listings[j]
+		if a.Prefix != b.Prefix {
+			return a.Prefix < b.Prefix
+		}
+		return a.Delimiter < b.Delimiter
+	})
+	data, err := json.MarshalIndent(fx, "", "  ")
+	rec.mu.Unlock()
+	if err != nil {
+		return 0, fmt.Errorf("marshal fixtures: %w", err)
+	}
+	n, err := w.Write(data)
+	return int64(n), err
+}
+
+// Save writes the recorded interactions as JSON fixtures to the file fname
+func (rec *Recorder) Save(fname string) error {
+	f, err := os.Create(fname)
+	if err != nil {
+		return fmt.Errorf("create %s: %w", fname, err)
+	}
+	if _, err := rec.WriteTo(f); err != nil {
+		f.Close()
+		return fmt.Errorf("write %s: %w", fname, err)
+	}
+	return f.Close()
+}
+
+type requestKey struct {
+	key string
+	off int64
+	n   int64
+}
+
+type listingKey struct {
+	prefix    string
+	delimiter string
+}
+
+// Replayer is a KeyStreamerAt serving the interactions recorded by a Recorder.
+//
+// Requests identical to a recorded one get the recorded response. When an identical request
+// was recorded several times (e.g. a failure followed by a successful retry), the responses
+// are replayed in order, the last one being repeated. Other requests are served from the
+// recorded bodies of the same key if they cover the requested range, unless the Replayer is
+// strict. Requests that cannot be served fail with ErrNotRecorded.
+//
+// Listings are replayed the same way, for the recorded prefixes and delimiters only. Listings
+// iterated past the objects that were recorded fail with ErrNotRecorded.
+type Replayer struct {
+	strict       bool
+	mu           sync.Mutex
+	interactions map[requestKey][]*interaction
+	objects      map[string]*interaction
+	listings     map[listingKey][]*listing
+}
+
+var (
+	_ KeyVersionStreamerAt = &Replayer{}
+	_ KeyLister            = &Replayer{}
+	_ ListingChecker       = &Replayer{}
+)
+
+// ReplayOption is an option that can be passed to NewReplayer
+type ReplayOption func(rp *Replayer)
+
+// StrictReplay makes the Replayer fail all the requests that were not recorded as such
+func StrictReplay() ReplayOption {
+	return func(rp *Replayer) {
+		rp.strict = true
+	}
+}
+
+// NewReplayer creates a Replayer serving the fixtures read from r
+func NewReplayer(r io.Reader, opts ...ReplayOption) (*Replayer, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("read fixtures: %w", err)
+	}
+	fx := fixtures{}
+	if err := json.Unmarshal(data, &fx); err != nil {
+		return nil, fmt.Errorf("unmarshal fixtures: %w", err)
+	}
+	rp := &Replayer{
+		interactions: make(map[requestKey][]*interaction),
+		objects:      make(map[string]*interaction),
+		listings:     make(map[listingKey][]*listing),
+	}
+	for _, o := range opts {
+		o(rp)
+	}
+	for _, it := range fx.Interactions {
+		rk := requestKey{it.Key, it.Offset, it.Length}
+		rp.interactions[rk] = append(rp.interactions[rk], it)
+	}
+	for _, lst := range fx.Listings {
+		lk := listingKey{lst.Prefix, lst.Delimiter}
+		rp.listings[lk] = append(rp.listings[lk], lst)
+	}
+	return rp, nil
+}
+
+// LoadReplayer creates a Replayer serving the fixtures of the file fname
+func LoadReplayer(fname string, opts ...ReplayOption) (*Replayer, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %w", fname, err)
+	}
+	defer f.Close()
+	return NewReplayer(f, opts...)
+}
+
+// lookup returns the recorded interaction matching a request
+func (rp *Replayer) lookup(key string, off, n int64) *interaction {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	rk := requestKey{key, off, n}
+	if its := rp.interactions[rk]; len(its) > 0 {
+		it := its[0]
+		if len(its) > 1 {
+			rp.interactions[rk] = its[1:]
+		}
+		return it
+	}
+	if rp.strict {
+		return nil
+	}
+	return rp.assemble(key, off, n)
+}
+
+// assemble builds an interaction for an unrecorded request from the bodies recorded for key.
+// Must be called with rp.mu held
+func (rp *Replayer) assemble(key string, off, n int64) *interaction {
+	obj, ok := rp.objects[key]
+	if !ok {
+		obj = &interaction{Key: key, Size: -1}
+		var have []bool
+		for rk, its := range rp.interactions {
+			if rk.key != key {
+				continue
+			}
+			for _, it := range its {
+				if it.Error != nil && it.Error.Kind == recordedENOENT {
+					obj.Error = it.Error
+				}
+				if obj.Error != nil || (it.Error != nil && it.Error.Kind != recordedEOF) {
+					continue
+				}
+				//handlers may only return the size of the object for offset 0
+				if (it.Offset == 0 || it.Size > 0) && it.Size > obj.Size {
+					obj.Size = it.Size
+					obj.Version = it.Version
+				}
+				if end := it.Offset + int64(len(it.Body)); end > int64(len(obj.Body)) {
+					obj.Body = append(obj.Body, make([]byte, end-int64(len(obj.Body)))...)
+					have = append(have, make([]bool, end-int64(len(have)))...)
+				}
+				copy(obj.Body[it.Offset:], it.Body)
+				for i := range it.Body {
+					have[it.Offset+int64(i)] = true
+				}
+			}
+		}
+		//only keep the bytes from the start of the object that are known
+		for i, ok := range have {
+			if !ok {
+				obj.Body = obj.Body[:i]
+				break
+			}
+		}
+		if obj.Size == -1 && obj.Error == nil {
+			return nil
+		}
+		rp.objects[key] = obj
+	}
+	if obj.Error != nil {
+		return obj
+	}
+	end := off + n
+	if end > obj.Size {
+		end = obj.Size
+	}
+	it := &interaction{Key: key, Offset: off, Length: n, Size: obj.Size, Version: obj.Version}
+	if off >= obj.Size {
+		it.Error = &recordedError{Kind: recordedEOF}
+		return it
+	}
+	if end > int64(len(obj.Body)) {
+		return nil
+	}
+	it.Body = obj.Body[off:end]
+	it.Stream = true
+	if off+n > obj.Size {
+		it.Error = &recordedError{Kind: recordedEOF}
+	}
+	return it
+}
+
+// StreamAt implements KeyStreamerAt
+func (rp *Replayer) StreamAt(key string, off int64, n int64) (io.ReadCloser, int64, error) {
+	return rp.StreamAtContext(context.Background(), key, off, n)
+}
+
+// StreamAtContext implements KeyStreamerAtContext
+func (rp *Replayer) StreamAtContext(ctx context.Context, key string, off int64, n int64) (io.ReadCloser, int64, error) {
+	r, size, _, err := rp.StreamAtVersion(ctx, key, off, n, "")
+	return r, size, err
+}
+
+// StreamAtVersion implements KeyVersionStreamerAt
+func (rp *Replayer) StreamAtVersion(ctx context.Context, key string, off int64, n int64, version string) (io.ReadCloser, int64, string, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, 0, "", err
+	}
+	it := rp.lookup(key, off, n)
+	if it == nil {
+		return nil, 0, "", fmt.Errorf("%s [%d:%d]: %w", key, off, off+n, ErrNotRecorded)
+	}
+	if version != "" && it.Version != "" && version != it.Version {
+		return nil, 0, "", fmt.Errorf("new reader for %s: %w", key, ErrObjectChanged)
+	}
+	err := it.Error.err()
+	if err != nil && !it.Stream {
+		return nil, it.Size, it.Version, err
+	}
+	return &replayReader{Reader: bytes.NewReader(it.Body), err: it.BodyError.err()}, it.Size, it.Version, err
+}
+
+type replayReader struct {
+	*bytes.Reader
+	err error
+}
+
+func (r *replayReader) Read(buf []byte) (int, error) {
+	n, err := r.Reader.Read(buf)
+	if err == io.EOF && r.err != nil {
+		err = r.err
+	}
+	return n, err
+}
+
+func (r *replayReader) Close() error {
+	return nil
+}
+
+// ListObjects implements KeyLister
+func (rp *Replayer) ListObjects(ctx context.Context, prefix, delimiter string) ObjectIterator {
+	if err := ctx.Err(); err != nil {
+		return ErrorIterator(err)
+	}
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	lk := listingKey{prefix, delimiter}
+	lsts := rp.listings[lk]
+	if len(lsts) == 0 {
+		return ErrorIterator(fmt.Errorf("list %s: %w", prefix, ErrNotRecorded))
+	}
+	lst := lsts[0]
+	if len(lsts) > 1 {
+		rp.listings[lk] = lsts[1:]
+	}
+	return &replayIterator{lst: lst}
+}
+
+// CanList implements ListingChecker, telling whether a listing of prefix was recorded
+func (rp *Replayer) CanList(prefix string) bool {
+	rp.mu.Lock()
+	defer rp.mu.Unlock()
+	for lk := range rp.listings {
+		if lk.prefix == prefix {
+			return true
+		}
+	}
+	return false
+}
+
+type replayIterator struct {
+	lst  *listing
+	next int
+}
+
+func (ri *replayIterator) Next() (ObjectAttrs, error) {
+	if ri.next == len(ri.lst.Objects) {
+		if ri.lst.Error == nil {
+			return ObjectAttrs{}, fmt.Errorf("list %s: %w", ri.lst.Prefix, ErrNotRecorded)
+		}
+		return ObjectAttrs{}, ri.lst.Error.err()
+	}
+	obj := ri.lst.Objects[ri.next]
+	ri.next++
+	attrs := ObjectAttrs{Key: obj.Key, Size: obj.Size, Version: obj.Version, Prefix: obj.Prefix}
+	if obj.ModTime != nil {
+		attrs.ModTime = *obj.ModTime
+	}
+	return attrs, nil
+}
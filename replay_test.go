@@ -0,0 +1,202 @@
+// Copyright 2021 Airbus Defence and Space
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package osio
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecordReplay(t *testing.T) {
+	data := make([]byte, 100)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	fi, _ := NewFaultInjector(TReader{data}, FaultScript(
+		Fault{},
+		Fault{Kind: FaultTemporaryError},
+		Fault{},
+		Fault{},
+		Fault{Kind: FaultTruncate, Bytes: 3},
+	))
+	rec := NewRecorder(fi)
+	a, _ := NewAdapter(rec, BlockSize("16"), WithRetryPolicy(RetryPolicy{InitialBackoff: 1}))
+	r, err := a.Reader("k")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(100), r.Size())
+	buf := make([]byte, 30)
+	_, err = r.ReadAt(buf, 20)
+	assert.NoError(t, err)
+	_, err = a.Reader("enoent")
+	assert.Equal(t, syscall.ENOENT, err)
+
+	//truncated and partially read streams
+	rs, _, _ := rec.StreamAt("k", 90, 20)
+	_, _ = ioutil.ReadAll(rs)
+	rs.Close()
+	rs, _, _ = rec.StreamAt("k", 60, 20)
+	_, _ = rs.Read(make([]byte, 5))
+	rs.Close()
+
+	fname := filepath.Join(t.TempDir(), "fixtures.json")
+	assert.NoError(t, rec.Save(fname))
+
+	rp, err := LoadReplayer(fname, StrictReplay())
+	assert.NoError(t, err)
+	//failure then retry are replayed in order, the last response is repeated
+	_, _, err = rp.StreamAt("k", 16, 48)
+	assert.True(t, DefaultRetryable(err))
+	assert.Contains(t, err.Error(), "injected temporary error")
+	for i := 0; i < 2; i++ {
+		rs, _, err := rp.StreamAt("k", 16, 48)
+		assert.NoError(t, err)
+		b, _ := ioutil.ReadAll(rs)
+		assert.Equal(t, data[16:64], b)
+	}
+
+	rp, _ = LoadReplayer(fname, StrictReplay())
+	a, _ = NewAdapter(rp, BlockSize("16"), WithRetryPolicy(RetryPolicy{InitialBackoff: 1}))
+	r, err = a.Reader("k")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(100), r.Size())
+	buf2 := make([]byte, 30)
+	_, err = r.ReadAt(buf2, 20)
+	assert.NoError(t, err)
+	assert.Equal(t, buf, buf2)
+	assert.Equal(t, data[20:50], buf2)
+	_, err = a.Reader("enoent")
+	assert.Equal(t, syscall.ENOENT, err)
+
+	//streams can be returned along with io.EOF
+	rs, _, err = rp.StreamAt("k", 90, 20)
+	assert.Equal(t, io.EOF, err)
+	b, err := ioutil.ReadAll(rs)
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+	assert.Equal(t, data[90:93], b)
+	rs, _, _ = rp.StreamAt("k", 60, 20)
+	b, err = ioutil.ReadAll(rs)
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+	assert.Equal(t, data[60:65], b)
+
+	//strict mode
+	_, _, err = rp.StreamAt("k", 0, 10)
+	assert.True(t, errors.Is(err, ErrNotRecorded))
+
+	//non strict mode serves ranges covered by the recorded bodies
+	rp, _ = LoadReplayer(fname)
+	rs, size, err := rp.StreamAt("k", 5, 40)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(100), size)
+	b, _ = ioutil.ReadAll(rs)
+	assert.Equal(t, data[5:45], b)
+	_, _, err = rp.StreamAt("k", 60, 10)
+	assert.True(t, errors.Is(err, ErrNotRecorded))
+	_, _, err = rp.StreamAt("k", 100, 10)
+	assert.Equal(t, io.EOF, err)
+	_, _, err = rp.StreamAt("enoent", 10, 10)
+	assert.Equal(t, syscall.ENOENT, err)
+	_, _, err = rp.StreamAt("other", 0, 10)
+	assert.True(t, errors.Is(err, ErrNotRecorded))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, _, err = rp.StreamAtContext(ctx, "k", 0, 16)
+	assert.Equal(t, context.Canceled, err)
+}
+
+func TestReplayVersion(t *testing.T) {
+	vr := &VReader{}
+	vr.put([]byte("aaaabbbb"))
+	rec := NewRecorder(vr)
+	rs, _, v, err := rec.StreamAtVersion(context.Background(), "k", 0, 4, "")
+	assert.NoError(t, err)
+	assert.Equal(t, "v1", v)
+	rs.Close()
+	buf := bytes.Buffer{}
+	_, err = rec.WriteTo(&buf)
+	assert.NoError(t, err)
+	assert.Contains(t, buf.String(), `"version": "v1"`)
+
+	rp, err := NewReplayer(&buf)
+	assert.NoError(t, err)
+	_, _, v, err = rp.StreamAtVersion(context.Background(), "k", 0, 4, "v1")
+	assert.NoError(t, err)
+	assert.Equal(t, "v1", v)
+	_, _, _, err = rp.StreamAtVersion(context.Background(), "k", 0, 4, "v2")
+	assert.True(t, errors.Is(err, ErrObjectChanged))
+
+	_, err = NewReplayer(strings.NewReader("{"))
+	assert.Error(t, err)
+	_, err = LoadReplayer(filepath.Join(t.TempDir(), "missing.json"))
+	assert.Error(t, err)
+}
+
+func TestReplayListing(t *testing.T) {
+	ls := &listStore{replicaStore{objects: map[string][]byte{
+		"gs://bucket/a":   []byte("aaaa"),
+		"gs://bucket/b/c": []byte("cc"),
+	}}}
+	rec := NewRecorder(ls)
+	assert.True(t, CanList(rec, "gs://bucket/"))
+	assert.Equal(t, []string{"gs://bucket/a", "gs://bucket/b/"}, listKeys(t, rec.ListObjects(context.Background(), "gs://bucket/", "/")))
+	//partially iterated listings
+	it := rec.ListObjects(context.Background(), "gs://bucket/", "")
+	_, _ = it.Next()
+	buf := bytes.Buffer{}
+	_, err := rec.WriteTo(&buf)
+	assert.NoError(t, err)
+
+	rp, err := NewReplayer(&buf, StrictReplay())
+	assert.NoError(t, err)
+	assert.True(t, CanList(rp, "gs://bucket/"))
+	assert.False(t, CanList(rp, "gs://other/"))
+	a, _ := NewAdapter(rp)
+	it = a.ListObjects(context.Background(), "gs://bucket/", "/")
+	attrs, err := it.Next()
+	assert.NoError(t, err)
+	assert.Equal(t, ObjectAttrs{Key: "gs://bucket/a", Size: 4}, attrs)
+	attrs, err = it.Next()
+	assert.NoError(t, err)
+	assert.Equal(t, ObjectAttrs{Key: "gs://bucket/b/", Prefix: true}, attrs)
+	_, err = it.Next()
+	assert.Equal(t, io.EOF, err)
+	//sizes of listed objects are served without requests
+	r, err := a.Reader("gs://bucket/a")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), r.Size())
+
+	it = rp.ListObjects(context.Background(), "gs://bucket/", "")
+	attrs, err = it.Next()
+	assert.NoError(t, err)
+	assert.Equal(t, "gs://bucket/a", attrs.Key)
+	_, err = it.Next()
+	assert.True(t, errors.Is(err, ErrNotRecorded))
+	_, err = rp.ListObjects(context.Background(), "gs://other/", "").Next()
+	assert.True(t, errors.Is(err, ErrNotRecorded))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = rp.ListObjects(ctx, "gs://bucket/", "/").Next()
+	assert.Equal(t, context.Canceled, err)
+}
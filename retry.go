@@ -0,0 +1,234 @@
+// Copyright 2021 Airbus Defence and Space
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package osio
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// DefaultInitialBackoff is the delay before the first retry when RetryPolicy.InitialBackoff
+// is not set
+const DefaultInitialBackoff = 100 * time.Millisecond
+
+// RetryPolicy controls how an Adapter retries failed requests to its KeyStreamerAt. The
+// number of retries is set with the Retries option.
+//
+// The delay before each retry starts at InitialBackoff and doubles after each retry, up to
+// MaxBackoff. Failed reads of a stream that was successfully opened are also retried, by
+// requesting the remainder of the range, and count against the same retry budget.
+type RetryPolicy struct {
+	// InitialBackoff is the delay before the first retry. Defaults to DefaultInitialBackoff
+	InitialBackoff time.Duration
+	// MaxBackoff caps the delay between two retries. Unlimited if 0
+	MaxBackoff time.Duration
+	// MaxElapsed is the time after which a request is not retried anymore, counted from the
+	// first attempt. Unlimited if 0
+	MaxElapsed time.Duration
+	// Jitter enables "full jitter", i.e. the actual delay before each retry is picked uniformly
+	// at random between 0 and the computed backoff
+	Jitter bool
+	// Retryable tells whether a failed request should be retried. Defaults to DefaultRetryable
+	Retryable func(err error) bool
+}
+
+type rpao struct {
+	policy RetryPolicy
+}
+
+func (r rpao) adapterOpt(a *Adapter) error {
+	if r.policy.InitialBackoff < 0 || r.policy.MaxBackoff < 0 || r.policy.MaxElapsed < 0 {
+		return fmt.Errorf("retry policy durations must be >= 0")
+	}
+	a.retryPolicy = r.policy
+	return nil
+}
+
+// WithRetryPolicy is an option to customize the RetryPolicy of the Adapter. The default policy
+// retries errors matched by DefaultRetryable, waiting 100ms before the first retry and doubling
+// the delay for each subsequent retry.
+func WithRetryPolicy(policy RetryPolicy) interface {
+	AdapterOption
+} {
+	return rpao{policy}
+}
+
+var retryableCodes = map[string]bool{
+	"SlowDown":             true,
+	"RequestTimeout":       true,
+	"InternalError":        true,
+	"ServiceUnavailable":   true,
+	"Throttling":           true,
+	"ThrottlingException":  true,
+	"RequestLimitExceeded": true,
+}
+
+// DefaultRetryable is the default RetryPolicy.Retryable classifier. It returns true for:
+//
+//   - errors with a Temporary() method returning true, e.g. the errors returned by the gcs
+//     handler for HTTP 429 and 5xx responses
+//   - errors with an HTTPStatusCode() method returning 429 or a 5xx code, e.g. the errors of the
+//     aws sdk or of the HTTPHandler
+//   - errors with an ErrorCode() method returning an S3 throttling or server error code such as
+//     "SlowDown"
+//   - network timeouts, reset connections and truncated responses
+//
+// It returns false for cancelled contexts, io.EOF, syscall.ENOENT and ErrObjectChanged.
+func DefaultRetryable(err error) bool {
+	if err == nil ||
+		errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, io.EOF) || errors.Is(err, syscall.ENOENT) || errors.Is(err, ErrObjectChanged) {
+		return false
+	}
+	var tmp interface{ Temporary() bool }
+	if errors.As(err, &tmp) && tmp.Temporary() {
+		return true
+	}
+	var sc interface{ HTTPStatusCode() int }
+	if errors.As(err, &sc) {
+		if code := sc.HTTPStatusCode(); code == 429 || code >= 500 {
+			return true
+		}
+	}
+	var ec interface{ ErrorCode() string }
+	if errors.As(err, &ec) && retryableCodes[ec.ErrorCode()] {
+		return true
+	}
+	var nerr net.Error
+	if errors.As(err, &nerr) && nerr.Timeout() {
+		return true
+	}
+	return errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNABORTED) || errors.Is(err, syscall.EPIPE)
+}
+
+// backoff tracks the retry budget of a request
+type backoff struct {
+	policy  RetryPolicy
+	retries int
+	start   time.Time
+	delay   time.Duration
+}
+
+func (a *Adapter) newBackoff() *backoff {
+	bo := &backoff{
+		policy:  a.retryPolicy,
+		retries: a.retries,
+		start:   time.Now(),
+		delay:   a.retryPolicy.InitialBackoff,
+	}
+	if bo.delay == 0 {
+		bo.delay = DefaultInitialBackoff
+	}
+	if bo.policy.Retryable == nil {
+		bo.policy.Retryable = DefaultRetryable
+	}
+	return bo
+}
+
+// next returns the delay to wait before retrying after err, or false if err should not be
+// retried
+func (bo *backoff) next(err error) (time.Duration, bool) {
+	if bo.retries <= 0 || !bo.policy.Retryable(err) {
+		return 0, false
+	}
+	delay := bo.delay
+	if bo.policy.MaxBackoff > 0 && delay > bo.policy.MaxBackoff {
+		delay = bo.policy.MaxBackoff
+	}
+	if bo.policy.Jitter {
+		delay = time.Duration(rand.Int63n(int64(delay) + 1))
+	}
+	if bo.policy.MaxElapsed > 0 && time.Since(bo.start)+delay > bo.policy.MaxElapsed {
+		return 0, false
+	}
+	bo.retries--
+	bo.delay *= 2
+	return delay, true
+}
+
+// wait sleeps for delay, or until ctx is done
+func (bo *backoff) wait(ctx context.Context, delay time.Duration) error {
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// resumingReader is a stream on the range [off,off+n) of key that transparently re-requests
+// the remainder of the range if reading from the source stream fails
+type resumingReader struct {
+	a   *Adapter
+	ctx context.Context
+	key string
+	off int64
+	n   int64
+	bo  *backoff
+	r   io.ReadCloser
+}
+
+func (rr *resumingReader) Read(p []byte) (int, error) {
+	for {
+		nr, err := rr.r.Read(p)
+		rr.off += int64(nr)
+		rr.n -= int64(nr)
+		if err == nil || errors.Is(err, io.EOF) || rr.n <= 0 {
+			return nr, err
+		}
+		delay, ok := rr.bo.next(err)
+		if !ok {
+			return nr, err
+		}
+		rr.a.count(rr.key, func(c *statCounters) { atomic.AddUint64(&c.retries, 1) })
+		trace.SpanFromContext(rr.ctx).AddEvent("stream resumed", trace.WithAttributes(
+			AttrOffset.Int64(rr.off), attribute.String("error", err.Error())))
+		_ = rr.r.Close()
+		rr.r = eofReader{}
+		if werr := rr.bo.wait(rr.ctx, delay); werr != nil {
+			return nr, werr
+		}
+		r, _, _, oerr := rr.a.openStream(rr.ctx, rr.key, rr.off, rr.n, versionFromContext(rr.ctx), rr.bo)
+		if oerr != nil && (r == nil || !errors.Is(oerr, io.EOF)) {
+			return nr, oerr
+		}
+		rr.r = r
+		if nr > 0 {
+			return nr, nil
+		}
+	}
+}
+
+func (rr *resumingReader) Close() error {
+	return rr.r.Close()
+}
+
+type eofReader struct{}
+
+func (eofReader) Read([]byte) (int, error) { return 0, io.EOF }
+func (eofReader) Close() error             { return nil }
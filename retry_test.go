@@ -0,0 +1,232 @@
+// Copyright 2021 Airbus Defence and Space
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package osio
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type codeErr string
+
+func (e codeErr) Error() string     { return string(e) }
+func (e codeErr) ErrorCode() string { return string(e) }
+
+type timeoutErr struct{}
+
+func (timeoutErr) Error() string   { return "i/o timeout" }
+func (timeoutErr) Timeout() bool   { return true }
+func (timeoutErr) Temporary() bool { return false }
+
+func TestDefaultRetryable(t *testing.T) {
+	for err, expected := range map[error]bool{
+		nil:                                   false,
+		io.EOF:                                false,
+		syscall.ENOENT:                        false,
+		context.Canceled:                      false,
+		fmt.Errorf("w: %w", ErrObjectChanged): false,
+		errors.New("other"):                   false,
+		tempErr{}:                             true,
+		&httpStatusError{code: 503}:           true,
+		&httpStatusError{code: 429}:           true,
+		&httpStatusError{code: 403}:           false,
+		codeErr("SlowDown"):                   true,
+		codeErr("AccessDenied"):               false,
+		timeoutErr{}:                          true,
+		io.ErrUnexpectedEOF:                   true,
+		fmt.Errorf("read: %w", syscall.ECONNRESET): true,
+	} {
+		assert.Equal(t, expected, DefaultRetryable(err), "%v", err)
+	}
+}
+
+// brokenReader fails with err after having returned n bytes
+type brokenReader struct {
+	r   io.Reader
+	n   int
+	err error
+}
+
+func (b *brokenReader) Read(buf []byte) (int, error) {
+	if b.n <= 0 {
+		return 0, b.err
+	}
+	if len(buf) > b.n {
+		buf = buf[:b.n]
+	}
+	n, err := b.r.Read(buf)
+	b.n -= n
+	return n, err
+}
+
+// FReader is a KeyStreamerAt whose first failures streams are interrupted after 3 bytes
+type FReader struct {
+	mu       sync.Mutex
+	data     []byte
+	failures int
+	offsets  []int64
+}
+
+func (f *FReader) StreamAt(key string, off int64, n int64) (io.ReadCloser, int64, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.offsets = append(f.offsets, off)
+	ll := int64(len(f.data))
+	if off >= ll {
+		return nil, ll, io.EOF
+	}
+	var err error
+	end := off + n
+	if end >= ll {
+		end = ll
+		err = io.EOF
+	}
+	var r io.Reader = bytes.NewReader(f.data[off:end])
+	if f.failures > 0 {
+		f.failures--
+		r = &brokenReader{r: r, n: 3, err: syscall.ECONNRESET}
+	}
+	return ioutil.NopCloser(r), ll, err
+}
+
+func TestRetryPolicy(t *testing.T) {
+	_, err := NewAdapter(rr, WithRetryPolicy(RetryPolicy{InitialBackoff: -1}))
+	assert.Error(t, err)
+
+	failing := EReader{errbuf: make([]byte, 100), err: tempErr{}}
+	buf := make([]byte, 10)
+
+	//custom classifier
+	bc, _ := NewAdapter(failing, Retries(3), WithRetryPolicy(RetryPolicy{
+		Retryable: func(error) bool { return false },
+	}))
+	_, err = bc.ReadAt("key", buf, 0)
+	assert.Equal(t, tempErr{}, err)
+	assert.Equal(t, uint64(1), bc.Stats().Requests)
+
+	//max elapsed time: retries at 0 and 50ms, the next one would be at 150ms
+	bc, _ = NewAdapter(failing, Retries(10), WithRetryPolicy(RetryPolicy{
+		InitialBackoff: 50 * time.Millisecond,
+		MaxElapsed:     120 * time.Millisecond,
+	}))
+	_, err = bc.ReadAt("key", buf, 0)
+	assert.Error(t, err)
+	assert.Equal(t, uint64(2), bc.Stats().Requests)
+
+	//max backoff
+	bc, _ = NewAdapter(failing, Retries(3), WithRetryPolicy(RetryPolicy{
+		InitialBackoff: 10 * time.Millisecond,
+		MaxBackoff:     10 * time.Millisecond,
+	}))
+	st := time.Now()
+	_, err = bc.ReadAt("key", buf, 0)
+	assert.Error(t, err)
+	assert.Equal(t, uint64(4), bc.Stats().Requests)
+	assert.Less(t, int64(time.Since(st)), int64(60*time.Millisecond))
+
+	//jitter
+	bc, _ = NewAdapter(failing, Retries(3), WithRetryPolicy(RetryPolicy{
+		InitialBackoff: 50 * time.Millisecond,
+		Jitter:         true,
+	}))
+	st = time.Now()
+	_, err = bc.ReadAt("key", buf, 0)
+	assert.Error(t, err)
+	assert.Equal(t, uint64(4), bc.Stats().Requests)
+	assert.Less(t, int64(time.Since(st)), int64(350*time.Millisecond))
+}
+
+func TestRetryMidStream(t *testing.T) {
+	data := []byte("0123456789abcdefghij")
+	retry := WithRetryPolicy(RetryPolicy{InitialBackoff: time.Millisecond})
+
+	//interrupted streams are resumed from the last received byte
+	fr := &FReader{data: data, failures: 2}
+	bc, _ := NewAdapter(fr, BlockSize("10"), SplitRanges(true), retry)
+	buf := make([]byte, 10)
+	n, err := bc.ReadAt("key", buf, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, 10, n)
+	assert.Equal(t, "0123456789", string(buf))
+	assert.Equal(t, []int64{0, 3, 6}, fr.offsets)
+	assert.Equal(t, uint64(2), bc.Stats().Retries)
+
+	//short last block
+	fr = &FReader{data: data[:15], failures: 1}
+	bc, _ = NewAdapter(fr, BlockSize("10"), SplitRanges(true), retry)
+	n, err = bc.ReadAt("key", buf[:5], 10)
+	assert.NoError(t, err)
+	assert.Equal(t, "abcde", string(buf[:n]))
+
+	//retry budget exhausted
+	fr = &FReader{data: data, failures: 3}
+	bc, _ = NewAdapter(fr, BlockSize("10"), SplitRanges(true), Retries(2), retry)
+	_, err = bc.ReadAt("key", buf, 0)
+	assert.ErrorIs(t, err, syscall.ECONNRESET)
+	assert.Equal(t, []int64{0, 3, 6}, fr.offsets)
+}
+
+func TestRetryRange(t *testing.T) {
+	data := make([]byte, 95)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	retry := WithRetryPolicy(RetryPolicy{InitialBackoff: time.Millisecond})
+
+	//a merged range interrupted twice is resumed at the first missing byte
+	fr := &FReader{data: data, failures: 2}
+	bc, _ := NewAdapter(fr, BlockSize("10"), retry)
+	buf := make([]byte, 80)
+	n, err := bc.ReadAt("key", buf, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 80, n)
+	assert.Equal(t, data[10:90], buf)
+	assert.Equal(t, []int64{10, 13, 16}, fr.offsets)
+	//all the blocks were cached
+	n, err = bc.ReadAt("key", buf, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 80, n)
+	assert.Len(t, fr.offsets, 3)
+
+	//range extending past the end of the object
+	fr = &FReader{data: data, failures: 1}
+	bc, _ = NewAdapter(fr, BlockSize("10"), retry)
+	n, err = bc.ReadAt("key", buf[:30], 70)
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, 25, n)
+	assert.Equal(t, data[70:], buf[:25])
+	assert.Equal(t, []int64{70, 73}, fr.offsets)
+
+	//retry budget exhausted: the blocks read before the failure are kept
+	fr = &FReader{data: data, failures: 2}
+	bc, _ = NewAdapter(fr, BlockSize("2"), Retries(1), retry)
+	_, err = bc.ReadAt("key", buf[:10], 0)
+	assert.ErrorIs(t, err, syscall.ECONNRESET)
+	assert.Equal(t, []int64{0, 3}, fr.offsets)
+	n, err = bc.ReadAt("key", buf[:4], 0)
+	assert.NoError(t, err)
+	assert.Equal(t, data[:4], buf[:4])
+	assert.Equal(t, []int64{0, 3}, fr.offsets)
+}
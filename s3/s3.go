@@ -21,6 +21,7 @@ import (
 	"io"
 	"syscall"
 
+	"github.com/airbusgeo/osio"
 	"github.com/airbusgeo/osio/internal"
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
@@ -29,6 +30,11 @@ import (
 	"github.com/aws/smithy-go"
 )
 
+var (
+	_ osio.KeyVersionStreamerAt = &Handler{}
+	_ osio.KeyLister            = &Handler{}
+)
+
 type Handler struct {
 	ctx          context.Context
 	client       *s3.Client
@@ -72,6 +78,10 @@ func Handle(ctx context.Context, opts ...S3Option) (*Handler, error) {
 }
 
 func handleS3ApiError(err error) (io.ReadCloser, int64, error) {
+	var he interface{ HTTPStatusCode() int }
+	if errors.As(err, &he) && he.HTTPStatusCode() == 412 {
+		return nil, 0, fmt.Errorf("%v: %w", err, osio.ErrObjectChanged)
+	}
 	var ae smithy.APIError
 	if errors.As(err, &ae) && ae.ErrorCode() == "InvalidRange" {
 		return nil, 0, io.EOF
@@ -82,39 +92,147 @@ func handleS3ApiError(err error) (io.ReadCloser, int64, error) {
 	return nil, 0, err
 }
 
+// StreamAt implements osio.KeyStreamerAt, using the context the handler was created with
 func (h *Handler) StreamAt(key string, off int64, n int64) (io.ReadCloser, int64, error) {
+	return h.StreamAtContext(h.ctx, key, off, n)
+}
+
+// StreamAtContext implements osio.KeyStreamerAtContext
+func (h *Handler) StreamAtContext(ctx context.Context, key string, off int64, n int64) (io.ReadCloser, int64, error) {
+	r, size, _, err := h.StreamAtVersion(ctx, key, off, n, "")
+	return r, size, err
+}
+
+// StreamAtVersion implements osio.KeyVersionStreamerAt. The version of an object is its ETag
+func (h *Handler) StreamAtVersion(ctx context.Context, key string, off int64, n int64, version string) (io.ReadCloser, int64, string, error) {
+	//requests are also canceled with the context the handler was created with
+	ctx, cancel := internal.MergeContext(ctx, h.ctx)
+	rc, size, version, err := h.streamAtVersion(ctx, key, off, n, version)
+	return internal.CancelOnClose(rc, cancel), size, version, err
+}
+
+func (h *Handler) streamAtVersion(ctx context.Context, key string, off int64, n int64, version string) (io.ReadCloser, int64, string, error) {
 	bucket, object, err := internal.BucketObject(key)
 	if err != nil {
-		return nil, 0, err
+		return nil, 0, "", err
+	}
+	var ifMatch *string
+	if version != "" {
+		ifMatch = aws.String(version)
 	}
 
 	// HEAD request to get object size as it is not returned in range requests
 	var size int64
 	if off == 0 {
-		r, err := h.client.HeadObject(h.ctx, &s3.HeadObjectInput{
+		r, err := h.client.HeadObject(ctx, &s3.HeadObjectInput{
 			Bucket:       &bucket,
 			Key:          &object,
 			RequestPayer: types.RequestPayer(h.requestPayer),
+			IfMatch:      ifMatch,
 		})
 		if err != nil {
-			return handleS3ApiError(fmt.Errorf("new reader for s3://%s/%s: %w", bucket, object, err))
+			rc, size, err := handleS3ApiError(fmt.Errorf("new reader for s3://%s/%s: %w", bucket, object, err))
+			return rc, size, "", err
 		}
 		if r.ContentLength != nil {
 			size = *r.ContentLength
 		}
+		if version == "" && r.ETag != nil {
+			version = *r.ETag
+			ifMatch = r.ETag
+		}
 	}
 
 	// GET request to fetch range
-	r, err := h.client.GetObject(h.ctx, &s3.GetObjectInput{
+	r, err := h.client.GetObject(ctx, &s3.GetObjectInput{
 		Bucket:       &bucket,
 		Key:          &object,
 		RequestPayer: types.RequestPayer(h.requestPayer),
 		Range:        aws.String(fmt.Sprintf("bytes=%d-%d", off, off+n-1)),
+		IfMatch:      ifMatch,
 	})
 	if err != nil {
-		return handleS3ApiError(fmt.Errorf("new reader for s3://%s/%s: %w", bucket, object, err))
+		rc, size, err := handleS3ApiError(fmt.Errorf("new reader for s3://%s/%s: %w", bucket, object, err))
+		if err == io.EOF {
+			return rc, size, version, err
+		}
+		return rc, size, "", err
+	}
+	if version == "" && r.ETag != nil {
+		version = *r.ETag
+	}
+	return r.Body, size, version, err
+}
+
+type objectIterator struct {
+	ctx    context.Context
+	pager  *s3.ListObjectsV2Paginator
+	base   string
+	bucket string
+	page   []osio.ObjectAttrs
+}
+
+func (oi *objectIterator) Next() (osio.ObjectAttrs, error) {
+	for len(oi.page) == 0 {
+		if !oi.pager.HasMorePages() {
+			return osio.ObjectAttrs{}, io.EOF
+		}
+		out, err := oi.pager.NextPage(oi.ctx)
+		if err != nil {
+			_, _, err = handleS3ApiError(fmt.Errorf("list s3://%s: %w", oi.bucket, err))
+			return osio.ObjectAttrs{}, err
+		}
+		oi.page = oi.toAttrs(out)
+	}
+	attrs := oi.page[0]
+	oi.page = oi.page[1:]
+	return attrs, nil
+}
+
+// toAttrs merges the objects and common prefixes of a page, which are each sorted by key
+func (oi *objectIterator) toAttrs(out *s3.ListObjectsV2Output) []osio.ObjectAttrs {
+	attrs := make([]osio.ObjectAttrs, 0, len(out.Contents)+len(out.CommonPrefixes))
+	c, p := 0, 0
+	for c < len(out.Contents) || p < len(out.CommonPrefixes) {
+		if p == len(out.CommonPrefixes) ||
+			(c < len(out.Contents) && aws.ToString(out.Contents[c].Key) < aws.ToString(out.CommonPrefixes[p].Prefix)) {
+			o := out.Contents[c]
+			attrs = append(attrs, osio.ObjectAttrs{
+				Key:     oi.base + aws.ToString(o.Key),
+				Size:    aws.ToInt64(o.Size),
+				Version: aws.ToString(o.ETag),
+				ModTime: aws.ToTime(o.LastModified),
+			})
+			c++
+		} else {
+			attrs = append(attrs, osio.ObjectAttrs{Key: oi.base + aws.ToString(out.CommonPrefixes[p].Prefix), Prefix: true})
+			p++
+		}
+	}
+	return attrs
+}
+
+// ListObjects implements osio.KeyLister. Objects are listed page by page as the iterator is
+// consumed. Listing a bucket that does not exist fails with syscall.ENOENT
+func (h *Handler) ListObjects(ctx context.Context, prefix, delimiter string) osio.ObjectIterator {
+	bucket, objectPrefix, err := internal.BucketPrefix(prefix)
+	if err != nil {
+		return osio.ErrorIterator(err)
+	}
+	in := &s3.ListObjectsV2Input{
+		Bucket:       &bucket,
+		Prefix:       &objectPrefix,
+		RequestPayer: types.RequestPayer(h.requestPayer),
+	}
+	if delimiter != "" {
+		in.Delimiter = &delimiter
+	}
+	return &objectIterator{
+		ctx:    ctx,
+		pager:  s3.NewListObjectsV2Paginator(h.client, in),
+		base:   internal.KeyBase(prefix, objectPrefix),
+		bucket: bucket,
 	}
-	return r.Body, size, err
 }
 
 func (h *Handler) ReadAt(key string, p []byte, off int64) (int, int64, error) {
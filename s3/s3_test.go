@@ -16,6 +16,9 @@ package s3
 
 import (
 	"context"
+	"flag"
+	"io"
+	"path/filepath"
 	"syscall"
 	"testing"
 
@@ -24,14 +27,36 @@ import (
 	"github.com/stretchr/testify/assert"
 )
 
-func TestS3(t *testing.T) {
+var record = flag.Bool("record", false, "record the test fixtures against S3")
+
+// handler returns a Replayer serving the fixtures testdata/<name>.json, or a Recorder around an
+// S3 handler saving them once the test is done if the tests are run with -record
+func handler(t *testing.T, name string) osio.KeyStreamerAt {
+	fname := filepath.Join("testdata", name+".json")
+	if !*record {
+		rp, err := osio.LoadReplayer(fname, osio.StrictReplay())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return rp
+	}
 	ctx := context.Background()
 	s3cl := aws3.New(aws3.Options{
 		Region:      "us-west-2",
 		Credentials: nil,
 	})
 	sss, _ := Handle(ctx, S3Client(s3cl))
-	s3a, _ := osio.NewAdapter(sss)
+	rec := osio.NewRecorder(sss)
+	t.Cleanup(func() {
+		if err := rec.Save(fname); err != nil {
+			t.Error(err)
+		}
+	})
+	return rec
+}
+
+func TestS3(t *testing.T) {
+	s3a, _ := osio.NewAdapter(handler(t, "s3"), osio.BlockSize("256"))
 
 	// bucket not found
 	_, err := s3a.Reader("s3://ukn-bucket/gdd/doesnotexist.tif")
@@ -61,3 +86,39 @@ func TestS3(t *testing.T) {
 	_, err = s3a.Reader("sentinel-cogs/test-notexists.tif")
 	assert.Error(t, err)
 }
+
+func TestS3List(t *testing.T) {
+	ctx := context.Background()
+	s3a, _ := osio.NewAdapter(handler(t, "s3_list"), osio.BlockSize("256"))
+
+	it := s3a.ListObjects(ctx, "s3://sentinel-cogs/sentinel-s2-l2a-cogs/60/V/XL/2019/5/S2A_60VXL_20190521_1_L2A/", "/")
+	found := false
+	for {
+		attrs, err := it.Next()
+		if err == io.EOF {
+			break
+		}
+		if !assert.NoError(t, err) {
+			return
+		}
+		if attrs.Key == "s3://sentinel-cogs/sentinel-s2-l2a-cogs/60/V/XL/2019/5/S2A_60VXL_20190521_1_L2A/TCI.tif" {
+			found = true
+			assert.Equal(t, int64(1252564), attrs.Size)
+			assert.NotEmpty(t, attrs.Version)
+		}
+	}
+	assert.True(t, found)
+	//size is served from the listing
+	r, err := s3a.Reader("s3://sentinel-cogs/sentinel-s2-l2a-cogs/60/V/XL/2019/5/S2A_60VXL_20190521_1_L2A/TCI.tif")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1252564), r.Size())
+	assert.Equal(t, uint64(0), s3a.Stats().Requests)
+
+	it = s3a.ListObjects(ctx, "s3://sentinel-cogs/sentinel-s2-l2a-cogs/60/V/XL/2019/5/", "/")
+	attrs, err := it.Next()
+	assert.NoError(t, err)
+	assert.True(t, attrs.Prefix)
+
+	_, err = s3a.ListObjects(ctx, "s3://ukn-bucket/", "/").Next()
+	assert.Equal(t, syscall.ENOENT, err)
+}
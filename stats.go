@@ -0,0 +1,198 @@
+// Copyright 2021 Airbus Defence and Space
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package osio
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// NumRangeSizes is the number of buckets of Stats.RangeSizes
+const NumRangeSizes = 16
+
+// Stats is a snapshot of the activity of an Adapter
+type Stats struct {
+	// BlockHits is the number of blocks looked up by reads that were found in the block cache
+	BlockHits uint64
+	// BlockMisses is the number of blocks looked up by reads that were not found in the block cache
+	BlockMisses uint64
+	// ENOENTHits is the number of lookups of non-existing objects answered by the size cache
+	ENOENTHits uint64
+	// Requests is the number of calls to the source KeyStreamerAt, including retries
+	Requests uint64
+	// Retries is the number of requests that were retried, including interrupted streams that
+	// were resumed
+	Retries uint64
+	// BytesFetched is the number of bytes read from the source KeyStreamerAt
+	BytesFetched uint64
+	// RangeSizes is the histogram of the number of blocks spanned by each request:
+	// RangeSizes[i] counts the requests spanning between 2^i and 2^(i+1)-1 blocks,
+	// the last bucket counting all the larger requests
+	RangeSizes [NumRangeSizes]uint64
+	// Hedges is the number of duplicate requests sent by the Hedging option
+	Hedges uint64
+	// QueueWaits is the number of requests that were queued by the MaxConcurrentRequests or
+	// MaxConcurrentPrefixRequests options
+	QueueWaits uint64
+	// QueueWaitTime is the total time spent by requests in these queues
+	QueueWaitTime time.Duration
+	// LockWaits is the number of times a read waited for a block being fetched by a
+	// concurrent read
+	LockWaits uint64
+	// LockWaitTime is the total time spent in these waits
+	LockWaitTime time.Duration
+}
+
+type statCounters struct {
+	blockHits      uint64
+	blockMisses    uint64
+	enoentHits     uint64
+	requests       uint64
+	retries        uint64
+	bytesFetched   uint64
+	rangeSizes     [NumRangeSizes]uint64
+	hedges         uint64
+	queueWaits     uint64
+	queueWaitNanos uint64
+	lockWaits      uint64
+	lockWaitNanos  uint64
+}
+
+func (c *statCounters) snapshot() Stats {
+	s := Stats{
+		BlockHits:     atomic.LoadUint64(&c.blockHits),
+		BlockMisses:   atomic.LoadUint64(&c.blockMisses),
+		ENOENTHits:    atomic.LoadUint64(&c.enoentHits),
+		Requests:      atomic.LoadUint64(&c.requests),
+		Retries:       atomic.LoadUint64(&c.retries),
+		BytesFetched:  atomic.LoadUint64(&c.bytesFetched),
+		Hedges:        atomic.LoadUint64(&c.hedges),
+		QueueWaits:    atomic.LoadUint64(&c.queueWaits),
+		QueueWaitTime: time.Duration(atomic.LoadUint64(&c.queueWaitNanos)),
+		LockWaits:     atomic.LoadUint64(&c.lockWaits),
+		LockWaitTime:  time.Duration(atomic.LoadUint64(&c.lockWaitNanos)),
+	}
+	for i := range c.rangeSizes {
+		s.RangeSizes[i] = atomic.LoadUint64(&c.rangeSizes[i])
+	}
+	return s
+}
+
+type prefixCounters struct {
+	prefix string
+	statCounters
+}
+
+type stao struct {
+	prefixes []string
+}
+
+func (s stao) adapterOpt(a *Adapter) error {
+	for _, p := range s.prefixes {
+		if p == "" {
+			return fmt.Errorf("stats prefix must not be empty")
+		}
+		for _, pc := range a.prefixStats {
+			if pc.prefix == p {
+				return fmt.Errorf("duplicate stats prefix %s", p)
+			}
+		}
+		a.prefixStats = append(a.prefixStats, &prefixCounters{prefix: p})
+	}
+	sort.SliceStable(a.prefixStats, func(i, j int) bool {
+		return len(a.prefixStats[i].prefix) > len(a.prefixStats[j].prefix)
+	})
+	return nil
+}
+
+// StatsPrefixes is an option to make the Adapter additionally account its activity per key
+// prefix, e.g. StatsPrefixes("gs://bucket1/","gs://bucket2/"). The activity on a key is
+// accounted to the longest matching prefix only. Per prefix statistics are returned by
+// Adapter.PrefixStats
+func StatsPrefixes(prefixes ...string) interface {
+	AdapterOption
+} {
+	return stao{prefixes}
+}
+
+// count calls f with the global counters, and with the counters of the prefix matching key
+func (a *Adapter) count(key string, f func(c *statCounters)) {
+	f(&a.stats)
+	for _, pc := range a.prefixStats {
+		if strings.HasPrefix(key, pc.prefix) {
+			f(&pc.statCounters)
+			return
+		}
+	}
+}
+
+func (a *Adapter) countRequest(key string, n int64) {
+	blocks := (n + a.blockSize - 1) / a.blockSize
+	bucket := 0
+	for blocks > 1 && bucket < NumRangeSizes-1 {
+		blocks >>= 1
+		bucket++
+	}
+	a.count(key, func(c *statCounters) {
+		atomic.AddUint64(&c.requests, 1)
+		atomic.AddUint64(&c.rangeSizes[bucket], 1)
+	})
+}
+
+func (a *Adapter) countLookup(key string, hit bool) {
+	a.count(key, func(c *statCounters) {
+		if hit {
+			atomic.AddUint64(&c.blockHits, 1)
+		} else {
+			atomic.AddUint64(&c.blockMisses, 1)
+		}
+	})
+}
+
+// Stats returns a snapshot of the activity of the Adapter since its creation
+func (a *Adapter) Stats() Stats {
+	return a.stats.snapshot()
+}
+
+// PrefixStats returns a snapshot of the activity of the Adapter for each of the prefixes
+// configured with the StatsPrefixes option
+func (a *Adapter) PrefixStats() map[string]Stats {
+	ret := make(map[string]Stats, len(a.prefixStats))
+	for _, pc := range a.prefixStats {
+		ret[pc.prefix] = pc.snapshot()
+	}
+	return ret
+}
+
+// countingReadCloser accounts the bytes read from a source stream
+type countingReadCloser struct {
+	io.ReadCloser
+	a   *Adapter
+	key string
+}
+
+func (cr countingReadCloser) Read(buf []byte) (int, error) {
+	n, err := cr.ReadCloser.Read(buf)
+	if n > 0 {
+		cr.a.count(cr.key, func(c *statCounters) {
+			atomic.AddUint64(&c.bytesFetched, uint64(n))
+		})
+	}
+	return n, err
+}
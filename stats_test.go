@@ -0,0 +1,97 @@
+// Copyright 2021 Airbus Defence and Space
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package osio
+
+import (
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type tempErr struct{}
+
+func (tempErr) Error() string   { return "temporary" }
+func (tempErr) Temporary() bool { return true }
+
+func TestStats(t *testing.T) {
+	_, err := NewAdapter(rr, StatsPrefixes(""))
+	assert.Error(t, err)
+	_, err = NewAdapter(rr, StatsPrefixes("a", "a"))
+	assert.Error(t, err)
+
+	data := make([]byte, 100)
+	src := TReader{data}
+	bc, _ := NewAdapter(src, BlockSize("10"), StatsPrefixes("a/", "a/b/", "enoent"))
+	buf := make([]byte, 25)
+
+	_, _ = bc.ReadAt("a/x", buf, 5)  //blocks 0-2
+	_, _ = bc.ReadAt("a/x", buf, 15) //blocks 1-2 cached, 3 fetched
+	st := bc.Stats()
+	assert.Equal(t, uint64(2), st.BlockHits)
+	assert.Equal(t, uint64(4), st.BlockMisses)
+	assert.Equal(t, uint64(2), st.Requests)
+	assert.Equal(t, uint64(0), st.Retries)
+	assert.Equal(t, uint64(40), st.BytesFetched)
+	assert.Equal(t, uint64(1), st.RangeSizes[0]) //1 block
+	assert.Equal(t, uint64(1), st.RangeSizes[1]) //3 blocks
+
+	//short last block
+	_, _ = bc.ReadAt("a/b/y", buf, 90)
+	_, err = bc.Size("enoent")
+	assert.Equal(t, syscall.ENOENT, err)
+	_, err = bc.Size("enoent")
+	assert.Equal(t, syscall.ENOENT, err)
+	_, _ = bc.ReadAt("d", buf[:1], 0)
+
+	st = bc.Stats()
+	assert.Equal(t, uint64(1), st.ENOENTHits)
+	assert.Equal(t, uint64(5), st.Requests)
+	assert.Equal(t, uint64(60), st.BytesFetched)
+	ps := bc.PrefixStats()
+	assert.Len(t, ps, 3)
+	assert.Equal(t, uint64(2), ps["a/"].Requests)
+	assert.Equal(t, uint64(40), ps["a/"].BytesFetched)
+	assert.Equal(t, uint64(1), ps["a/b/"].Requests)
+	assert.Equal(t, uint64(10), ps["a/b/"].BytesFetched)
+	assert.Equal(t, uint64(1), ps["enoent"].ENOENTHits)
+	assert.Equal(t, uint64(0), ps["enoent"].BytesFetched)
+
+	//retries
+	ebc, _ := NewAdapter(EReader{errbuf: data, err: tempErr{}}, BlockSize("10"), Retries(2))
+	_, _ = ebc.ReadAt("a", buf, 0)
+	assert.Equal(t, uint64(2), ebc.Stats().Retries)
+	assert.Equal(t, uint64(3), ebc.Stats().Requests)
+
+	//concurrent reads of the same block wait for the first one
+	delay = 20 * time.Millisecond
+	defer func() { delay = 0 }()
+	sbc, _ := NewAdapter(src, BlockSize("10"), SplitRanges(true))
+	wg := sync.WaitGroup{}
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _ = sbc.ReadAt("a", make([]byte, 5), 0)
+		}()
+	}
+	wg.Wait()
+	st = sbc.Stats()
+	assert.Equal(t, uint64(1), st.Requests)
+	assert.Equal(t, uint64(2), st.LockWaits)
+	assert.Greater(t, int64(st.LockWaitTime), int64(10*time.Millisecond))
+}
@@ -0,0 +1,208 @@
+// Copyright 2021 Airbus Defence and Space
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package osio
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+)
+
+// TierStats holds the number of lookups that were served by a cache tier (Hits), and the
+// number of lookups that reached the tier but were not found in it (Misses)
+type TierStats struct {
+	Hits   uint64
+	Misses uint64
+}
+
+type tierCounters struct {
+	hits   uint64
+	misses uint64
+}
+
+// TieredCache is a BlockCacher that composes an ordered list of BlockCachers, typically a
+// fast in-memory LRUCache backed by a larger DiskCache.
+//
+// Lookups are done on each tier in order. When a block is found in a lower tier, it is
+// promoted to all the upper tiers. Blocks are added to all tiers: the first tier is always
+// written synchronously, while lower tiers may be written asynchronously with the
+// AsyncWrites option.
+type TieredCache struct {
+	tiers    []BlockCacher
+	counters []tierCounters
+	async    chan struct{}
+	pending  sync.WaitGroup
+
+	// mu is held by asynchronous writes, and exclusively while purging
+	mu sync.RWMutex
+	// gen is incremented by each purge. Asynchronous writes of blocks of a key purged after
+	// the write was requested are dropped. Purged keys are only recorded while asynchronous
+	// writes are in flight.
+	gen         uint64
+	purgedAt    map[string]uint64
+	purgedAllAt uint64
+}
+
+var _ BlockCacher = &TieredCache{}
+
+// TieredCacheOption is an option that can be passed to NewTieredCache
+type TieredCacheOption func(tc *TieredCache) error
+
+// AsyncWrites makes the TieredCache write blocks to the lower tiers in the background.
+// At most maxPending background writes are in flight at any given time, and writes that
+// would exceed this limit are dropped instead of blocking the caller.
+func AsyncWrites(maxPending int) TieredCacheOption {
+	return func(tc *TieredCache) error {
+		if maxPending <= 0 {
+			return fmt.Errorf("maxPending must be > 0")
+		}
+		tc.async = make(chan struct{}, maxPending)
+		return nil
+	}
+}
+
+// NewTieredCache creates a TieredCache looking up blocks in tiers, in order
+func NewTieredCache(tiers []BlockCacher, opts ...TieredCacheOption) (*TieredCache, error) {
+	if len(tiers) == 0 {
+		return nil, fmt.Errorf("at least one tier is required")
+	}
+	for _, t := range tiers {
+		if t == nil {
+			return nil, fmt.Errorf("BlockCacher must not be nil")
+		}
+	}
+	tc := &TieredCache{
+		tiers:    tiers,
+		counters: make([]tierCounters, len(tiers)),
+		purgedAt: make(map[string]uint64),
+	}
+	for _, o := range opts {
+		if err := o(tc); err != nil {
+			return nil, err
+		}
+	}
+	return tc, nil
+}
+
+// Add inserts data in all the tiers
+func (tc *TieredCache) Add(key string, id uint, data []byte) {
+	tc.tiers[0].Add(key, id, data)
+	if len(tc.tiers) == 1 {
+		return
+	}
+	if tc.async == nil {
+		for _, t := range tc.tiers[1:] {
+			t.Add(key, id, data)
+		}
+		return
+	}
+	select {
+	case tc.async <- struct{}{}:
+	default:
+		return
+	}
+	gen := atomic.LoadUint64(&tc.gen)
+	tc.pending.Add(1)
+	go func() {
+		defer func() {
+			<-tc.async
+			tc.forgetPurges()
+			tc.pending.Done()
+		}()
+		tc.mu.RLock()
+		defer tc.mu.RUnlock()
+		if tc.purgedAllAt > gen || tc.purgedAt[key] > gen {
+			return
+		}
+		for _, t := range tc.tiers[1:] {
+			t.Add(key, id, data)
+		}
+	}()
+}
+
+// Get looks up the block in each tier in order, and promotes it to the upper tiers if it
+// was found in a lower one
+func (tc *TieredCache) Get(key string, id uint) ([]byte, bool) {
+	for i, t := range tc.tiers {
+		data, ok := t.Get(key, id)
+		if !ok {
+			atomic.AddUint64(&tc.counters[i].misses, 1)
+			continue
+		}
+		atomic.AddUint64(&tc.counters[i].hits, 1)
+		for j := 0; j < i; j++ {
+			tc.tiers[j].Add(key, id, data)
+		}
+		return data, true
+	}
+	return nil, false
+}
+
+// forgetPurges clears the purged keys once no asynchronous write is in flight anymore. Writes
+// requested afterwards were requested after these purges.
+func (tc *TieredCache) forgetPurges() {
+	if len(tc.async) > 0 {
+		return
+	}
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	if len(tc.async) == 0 && len(tc.purgedAt) > 0 {
+		tc.purgedAt = make(map[string]uint64)
+	}
+}
+
+// Flush waits for all the pending asynchronous writes to complete
+func (tc *TieredCache) Flush() {
+	tc.pending.Wait()
+}
+
+// Stats returns the hit and miss counters of each tier
+func (tc *TieredCache) Stats() []TierStats {
+	stats := make([]TierStats, len(tc.tiers))
+	for i := range tc.counters {
+		stats[i].Hits = atomic.LoadUint64(&tc.counters[i].hits)
+		stats[i].Misses = atomic.LoadUint64(&tc.counters[i].misses)
+	}
+	return stats
+}
+
+// PurgeKey removes the blocks of key from all the tiers that support it. Pending asynchronous
+// writes of blocks of key are dropped.
+func (tc *TieredCache) PurgeKey(key string) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	gen := atomic.AddUint64(&tc.gen, 1)
+	if len(tc.async) > 0 {
+		tc.purgedAt[key] = gen
+	}
+	for _, t := range tc.tiers {
+		if p, ok := t.(interface{ PurgeKey(string) }); ok {
+			p.PurgeKey(key)
+		}
+	}
+}
+
+// Purge empties all the tiers that support it. Pending asynchronous writes are dropped.
+func (tc *TieredCache) Purge() {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+	tc.purgedAllAt = atomic.AddUint64(&tc.gen, 1)
+	tc.purgedAt = make(map[string]uint64)
+	for _, t := range tc.tiers {
+		if p, ok := t.(interface{ Purge() }); ok {
+			p.Purge()
+		}
+	}
+}
@@ -0,0 +1,169 @@
+// Copyright 2021 Airbus Defence and Space
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package osio
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type slowCache struct {
+	*LRUCache
+	release chan struct{}
+}
+
+func (sc slowCache) Add(key string, id uint, data []byte) {
+	<-sc.release
+	sc.LRUCache.Add(key, id, data)
+}
+
+func TestTieredCache(t *testing.T) {
+	_, err := NewTieredCache(nil)
+	assert.Error(t, err)
+	_, err = NewTieredCache([]BlockCacher{nil})
+	assert.Error(t, err)
+	mem, _ := NewLRUCache(1)
+	_, err = NewTieredCache([]BlockCacher{mem}, AsyncWrites(0))
+	assert.Error(t, err)
+
+	disk, _ := NewDiskCache(t.TempDir(), 1000)
+	tc, err := NewTieredCache([]BlockCacher{mem, disk})
+	assert.NoError(t, err)
+
+	tc.Add("foo", 0, bytea(0))
+	tc.Add("foo", 1, bytea(1))
+	//0 evicted from memory, served from disk and promoted
+	b, ok := tc.Get("foo", 0)
+	assert.True(t, ok)
+	assert.Equal(t, bytea(0), b)
+	assert.Equal(t, []TierStats{{Hits: 0, Misses: 1}, {Hits: 1, Misses: 0}}, tc.Stats())
+	_, ok = mem.Get("foo", 0)
+	assert.True(t, ok)
+	_, ok = tc.Get("foo", 0)
+	assert.True(t, ok)
+	_, ok = tc.Get("bar", 0)
+	assert.False(t, ok)
+	assert.Equal(t, []TierStats{{Hits: 1, Misses: 2}, {Hits: 1, Misses: 1}}, tc.Stats())
+
+	tc.PurgeKey("foo")
+	_, ok = tc.Get("foo", 1)
+	assert.False(t, ok)
+	tc.Add("foo", 1, bytea(1))
+	tc.Purge()
+	_, ok = tc.Get("foo", 1)
+	assert.False(t, ok)
+
+	//asynchronous writes to lower tiers
+	mem, _ = NewLRUCache(10)
+	lower, _ := NewLRUCache(10)
+	slow := slowCache{lower, make(chan struct{})}
+	tc, _ = NewTieredCache([]BlockCacher{mem, slow}, AsyncWrites(1))
+	st := time.Now()
+	tc.Add("foo", 0, bytea(0))
+	tc.Add("foo", 1, bytea(1)) //dropped, as a write is already pending
+	assert.Less(t, int64(time.Since(st)), int64(100*time.Millisecond))
+	_, ok = mem.Get("foo", 1)
+	assert.True(t, ok)
+	close(slow.release)
+	tc.Flush()
+	_, ok = lower.Get("foo", 0)
+	assert.True(t, ok)
+	_, ok = lower.Get("foo", 1)
+	assert.False(t, ok)
+
+	//as an adapter cache
+	ll := &logger{}
+	mem, _ = NewLRUCache(1)
+	tc, _ = NewTieredCache([]BlockCacher{mem, disk})
+	bc, _ := NewAdapter(rr, BlockSize("4"), BlockCache(tc), WithLogger(ll))
+	buf := make([]byte, 8)
+	test(t, bc, buf, 0, 8, []byte{0, 0, 0, 0, 1, 1, 1, 1}, nil)
+	ll.last = ""
+	test(t, bc, buf, 0, 8, []byte{0, 0, 0, 0, 1, 1, 1, 1}, nil)
+	assert.Equal(t, "", ll.last)
+}
+
+// gatedCache is a LRUCache whose Adds signal entered and wait for release, and counts the
+// blocks added to it
+type gatedCache struct {
+	*LRUCache
+	entered chan struct{}
+	release chan struct{}
+	adds    int32
+}
+
+func (gc *gatedCache) Add(key string, id uint, data []byte) {
+	gc.entered <- struct{}{}
+	<-gc.release
+	atomic.AddInt32(&gc.adds, 1)
+	gc.LRUCache.Add(key, id, data)
+}
+
+func TestTieredCachePurgePending(t *testing.T) {
+	mem, _ := NewLRUCache(10)
+	lower, _ := NewLRUCache(10)
+	gated := &gatedCache{LRUCache: lower, entered: make(chan struct{}, 10), release: make(chan struct{})}
+	tc, _ := NewTieredCache([]BlockCacher{mem, gated}, AsyncWrites(2))
+
+	tc.Add("foo", 0, bytea(0))
+	<-gated.entered
+	purged := make(chan struct{})
+	go func() {
+		tc.PurgeKey("foo")
+		close(purged)
+	}()
+	//the purge waits for the write in flight
+	select {
+	case <-purged:
+		close(gated.release)
+		t.Fatal("purge did not wait for the pending write")
+	case <-time.After(50 * time.Millisecond):
+	}
+	//requested before the purge, written after it: dropped
+	tc.Add("foo", 1, bytea(1))
+	close(gated.release)
+	<-purged
+	tc.Flush()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&gated.adds))
+	_, ok := lower.Get("foo", 0)
+	assert.False(t, ok)
+	_, ok = lower.Get("foo", 1)
+	assert.False(t, ok)
+
+	//later writes are kept, pending writes of other keys too
+	tc.Add("foo", 2, bytea(2))
+	tc.Flush()
+	_, ok = lower.Get("foo", 2)
+	assert.True(t, ok)
+	tc.Add("bar", 0, bytea(0))
+	tc.PurgeKey("foo")
+	tc.Flush()
+	_, ok = lower.Get("bar", 0)
+	assert.True(t, ok)
+
+	//purged keys are forgotten once the pending writes are done
+	assert.Empty(t, tc.purgedAt)
+	tc.PurgeKey("baz")
+	assert.Empty(t, tc.purgedAt)
+
+	tc.Add("bar", 1, bytea(1))
+	tc.Purge()
+	tc.Flush()
+	_, ok = tc.Get("bar", 1)
+	assert.False(t, ok)
+}
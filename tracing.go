@@ -0,0 +1,113 @@
+// Copyright 2021 Airbus Defence and Space
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package osio
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"syscall"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/airbusgeo/osio"
+
+// Attribute keys set on the spans created by an Adapter
+const (
+	AttrKey     = attribute.Key("osio.key")
+	AttrOffset  = attribute.Key("osio.offset")
+	AttrLength  = attribute.Key("osio.length")
+	AttrBlock   = attribute.Key("osio.block")
+	AttrAttempt = attribute.Key("osio.attempt")
+	AttrOutcome = attribute.Key("osio.outcome")
+)
+
+type tpao struct {
+	tp trace.TracerProvider
+}
+
+func (t tpao) adapterOpt(a *Adapter) error {
+	if t.tp == nil {
+		return fmt.Errorf("TracerProvider must not be nil")
+	}
+	a.tracer = t.tp.Tracer(tracerName)
+	return nil
+}
+
+// TracerProvider is an option to set the OpenTelemetry TracerProvider used by the Adapter.
+// Defaults to the global TracerProvider.
+//
+// The Adapter creates an "osio.ReadAtMulti" span for each read, with "cache hits" and
+// "lock wait" events, and child spans for the ranges it fetches ("osio.getRange") and for
+// each call to the KeyStreamerAt ("osio.StreamAt"). The latter lasts until the returned
+// stream is closed, and records the failed attempts as events.
+func TracerProvider(tp trace.TracerProvider) interface {
+	AdapterOption
+} {
+	return tpao{tp}
+}
+
+func defaultTracer() trace.Tracer {
+	return otel.GetTracerProvider().Tracer(tracerName)
+}
+
+// outcome returns a short description of the result of a source request
+func outcome(err error) string {
+	switch {
+	case err == nil:
+		return "ok"
+	case errors.Is(err, io.EOF):
+		return "eof"
+	case errors.Is(err, syscall.ENOENT):
+		return "enoent"
+	case errors.Is(err, ErrObjectChanged):
+		return "changed"
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return "canceled"
+	default:
+		return "error"
+	}
+}
+
+// endSpan marks span as failed if err is not nil and not io.EOF, and ends it
+func endSpan(span trace.Span, err error) {
+	if err != nil && !errors.Is(err, io.EOF) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// spanReadCloser ends the span of a source request once its stream is closed
+type spanReadCloser struct {
+	io.ReadCloser
+	span trace.Span
+	err  error
+	once sync.Once
+}
+
+func (sr *spanReadCloser) Close() error {
+	err := sr.ReadCloser.Close()
+	sr.once.Do(func() {
+		endSpan(sr.span, sr.err)
+	})
+	return err
+}
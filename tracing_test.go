@@ -0,0 +1,111 @@
+// Copyright 2021 Airbus Defence and Space
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package osio
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func spanAttr(s sdktrace.ReadOnlySpan, key attribute.Key) attribute.Value {
+	for _, kv := range s.Attributes() {
+		if kv.Key == key {
+			return kv.Value
+		}
+	}
+	return attribute.Value{}
+}
+
+func TestTracing(t *testing.T) {
+	_, err := NewAdapter(rr, TracerProvider(nil))
+	assert.Error(t, err)
+
+	rec := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec))
+	bc, _ := NewAdapter(EReader{errbuf: make([]byte, 100), err: tempErr{}, erroff: 50},
+		BlockSize("10"), Retries(1), TracerProvider(tp))
+
+	buf := make([]byte, 20)
+	_, err = bc.ReadAt("key", buf, 5)
+	assert.NoError(t, err)
+	spans := rec.Ended()
+	assert.Len(t, spans, 3)
+	//children end first
+	assert.Equal(t, "osio.StreamAt", spans[0].Name())
+	assert.Equal(t, "osio.getRange", spans[1].Name())
+	assert.Equal(t, "osio.ReadAtMulti", spans[2].Name())
+	assert.Equal(t, spans[1].SpanContext().SpanID(), spans[0].Parent().SpanID())
+	assert.Equal(t, spans[2].SpanContext().SpanID(), spans[1].Parent().SpanID())
+	assert.Equal(t, "key", spanAttr(spans[0], AttrKey).AsString())
+	assert.Equal(t, int64(0), spanAttr(spans[0], AttrOffset).AsInt64())
+	assert.Equal(t, int64(30), spanAttr(spans[0], AttrLength).AsInt64())
+	assert.Equal(t, int64(1), spanAttr(spans[0], AttrAttempt).AsInt64())
+	assert.Equal(t, "ok", spanAttr(spans[0], AttrOutcome).AsString())
+
+	//cache hits
+	_, err = bc.ReadAt("key", buf[:5], 0)
+	assert.NoError(t, err)
+	spans = rec.Ended()
+	assert.Len(t, spans, 4)
+	assert.Equal(t, "osio.ReadAtMulti", spans[3].Name())
+	assert.Len(t, spans[3].Events(), 1)
+	assert.Equal(t, "cache hits", spans[3].Events()[0].Name)
+
+	//retries
+	_, err = bc.ReadAt("key", buf[:5], 60)
+	assert.Error(t, err)
+	spans = rec.Ended()
+	assert.Len(t, spans, 7)
+	assert.Equal(t, "osio.StreamAt", spans[4].Name())
+	assert.Equal(t, int64(2), spanAttr(spans[4], AttrAttempt).AsInt64())
+	assert.Equal(t, "error", spanAttr(spans[4], AttrOutcome).AsString())
+	assert.Equal(t, codes.Error, spans[4].Status().Code)
+	assert.Len(t, spans[4].Events(), 2) //failed attempt and recorded error
+	assert.Equal(t, "attempt failed", spans[4].Events()[0].Name)
+	assert.Equal(t, codes.Error, spans[6].Status().Code)
+
+	//lock waits
+	delay = 20 * time.Millisecond
+	defer func() { delay = 0 }()
+	rec = tracetest.NewSpanRecorder()
+	tp = sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec))
+	bc, _ = NewAdapter(rr, BlockSize("10"), SplitRanges(true), TracerProvider(tp))
+	wg := sync.WaitGroup{}
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, _ = bc.ReadAtContext(context.Background(), "key", make([]byte, 5), 0)
+		}()
+	}
+	wg.Wait()
+	waits := 0
+	for _, s := range rec.Ended() {
+		for _, e := range s.Events() {
+			if e.Name == "lock wait" {
+				waits++
+			}
+		}
+	}
+	assert.Equal(t, 1, waits)
+}